@@ -45,21 +45,26 @@ async function sendRequest(request) {
   }
 }
 
+// Tópicos do broker: "ch/<canal>\0" e "dm/<usuário>\0" (ver ARCHITECTURE.md)
+const channelTopic = (name) => `ch/${name}\0`;
+const userTopic = (name) => `dm/${name}\0`;
+
 // Função para receber mensagens do broker (em background)
 async function receiveMessages() {
   for await (const [topic, msg] of subSocket) {
     try {
       const topicStr = topic.toString();
+      const name = topicStr.slice(3, -1);
       const data = msgpack.decode(msg);
       const timestamp = new Date(data.timestamp * 1000).toLocaleString();
       
       // Mensagem de canal
-      if (subscribedChannels.has(topicStr)) {
-        console.log(`\n📺 [#${topicStr}] ${data.user}: ${data.message}`);
+      if (topicStr.startsWith('ch/') && subscribedChannels.has(name)) {
+        console.log(`\n📺 [#${name}] ${data.user}: ${data.message}`);
         console.log(`   ⏰ ${timestamp}`);
       } 
       // Mensagem direta
-      else if (topicStr === currentUser) {
+      else if (topicStr === userTopic(currentUser)) {
         console.log(`\n💬 [DM de ${data.from}]: ${data.message}`);
         console.log(`   ⏰ ${timestamp}`);
       }
@@ -90,7 +95,7 @@ async function login(username) {
       currentUser = username;
       
       // Inscrever-se para receber mensagens diretas
-      subSocket.subscribe(username);
+      subSocket.subscribe(userTopic(username));
       console.log(`✅ Login realizado com sucesso! Bem-vindo, ${username}!`);
       console.log(`📬 Inscrito para receber mensagens diretas`);
       console.log(`⏰ Timestamp: ${new Date(response.data.timestamp * 1000).toLocaleString()}`);
@@ -192,7 +197,7 @@ async function subscribeChannel(channelName) {
   const response = await sendRequest(channelsReq);
   
   if (response && response.data.channels.includes(channelName)) {
-    subSocket.subscribe(channelName);
+    subSocket.subscribe(channelTopic(channelName));
     subscribedChannels.add(channelName);
     console.log(`✅ Inscrito no canal #${channelName}`);
     return true;
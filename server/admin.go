@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/protocol"
+)
+
+// ----------------------------
+// Serviços administrativos (cmd/admin)
+// ----------------------------
+
+// Serviços:
+//
+//	admin_status    estado do nó (rank, coordenador, relógios, contagens)
+//	admin_election  inicia uma eleição Bully neste nó
+//	admin_sync      inicia uma sincronização Berkeley coordenada por este nó
+//	admin_resync    copia de outro servidor o que falta na réplica local
+//	admin_dump      devolve os dados persistidos (mesmo formato do arquivo)
+//	admin_restore   substitui ou mescla os dados persistidos
+//
+// Todos exigem ADMIN_TOKEN no servidor e uma assinatura em data.auth (ver
+// protocol.AdminSignature). Sem ADMIN_TOKEN, os serviços ficam desativados.
+
+const adminPeerTimeout = 5 * time.Second
+
+type AdminRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Auth      string `msgpack:"auth"`
+		Nonce     string `msgpack:"nonce"`
+		Peer      string `msgpack:"peer,omitempty"` // admin_resync: servidor de origem
+		JSON      []byte `msgpack:"json,omitempty"` // admin_restore: conteúdo do arquivo de dados
+		Mode      string `msgpack:"mode,omitempty"` // admin_restore: "replace" (padrão) ou "merge"
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type AdminResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string                `msgpack:"status"`
+		Error     string                `msgpack:"error,omitempty"`
+		Message   string                `msgpack:"message,omitempty"` // também a descrição do erro
+		Info      *protocol.AdminStatus `msgpack:"info,omitempty"`
+		JSON      []byte                `msgpack:"json,omitempty"`  // admin_dump
+		Added     int                   `msgpack:"added,omitempty"` // admin_restore (merge)
+		Timestamp int64                 `msgpack:"timestamp"`
+		Clock     int64                 `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+func isAdminService(service string) bool {
+	return strings.HasPrefix(service, "admin_")
+}
+
+// maxAdminNonce limita o tamanho do nonce guardado no cache.
+const maxAdminNonce = 64
+
+// checkAdminAuth confere a assinatura antes de qualquer outra validação e
+// recusa nonces já usados dentro da janela de AdminMaxSkew.
+func (srv *Server) checkAdminAuth(service string, msg []byte) *ValidationError {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return &ValidationError{ErrForbidden, "", "Serviços administrativos desativados (ADMIN_TOKEN não definido)"}
+	}
+	var req AdminRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return &ValidationError{ErrInvalidPayload, "", "Formato de mensagem inválido"}
+	}
+	skew := time.Now().Unix() - req.Data.Timestamp
+	if skew < -protocol.AdminMaxSkew || skew > protocol.AdminMaxSkew {
+		return &ValidationError{ErrForbidden, "timestamp", "Assinatura expirada"}
+	}
+	if req.Data.Nonce == "" || len(req.Data.Nonce) > maxAdminNonce {
+		return &ValidationError{ErrForbidden, "nonce", "Nonce ausente ou inválido"}
+	}
+	params := protocol.AdminParams{Peer: req.Data.Peer, Mode: req.Data.Mode, JSON: req.Data.JSON}
+	expected := protocol.AdminSignature(token, service, req.Data.Timestamp, req.Data.Nonce, params)
+	if !hmac.Equal([]byte(expected), []byte(req.Data.Auth)) {
+		return &ValidationError{ErrForbidden, "auth", "Assinatura inválida"}
+	}
+	if !srv.adminNonces.use(req.Data.Nonce, req.Data.Timestamp+protocol.AdminMaxSkew) {
+		return &ValidationError{ErrForbidden, "nonce", "Requisição repetida"}
+	}
+	return nil
+}
+
+func (srv *Server) adminStatus() *protocol.AdminStatus {
+	info := &protocol.AdminStatus{
+		Server:      srv.serverName,
+		Rank:        srv.rank(),
+		Coordinator: srv.coordinator(),
+		Clock:       srv.getClock(),
+		Time:        srv.getAdjustedTime(),
+	}
+	srv.clockMutex.Lock()
+	info.Offset = srv.timeOffset
+	info.LastSync = srv.lastSyncTime
+	srv.clockMutex.Unlock()
+	srv.dataMutex.Lock()
+	info.Users = len(srv.data.Logins)
+	info.Channels = len(srv.data.Channels)
+	info.ChannelMessages = len(srv.data.ChannelMessages)
+	info.UserMessages = len(srv.data.UserMessages)
+	srv.dataMutex.Unlock()
+
+	srv.resyncMutex.Lock()
+	if srv.lastResync != nil {
+		r := *srv.lastResync
+		info.LastResync = &r
+	}
+	srv.resyncMutex.Unlock()
+	return info
+}
+
+// newReferenceSocket abre um REQ próprio para o servidor de referência, para
+// não disputar o socket do loop principal.
+func (srv *Server) newReferenceSocket() (PeerConn, error) {
+	sock, err := srv.dial(referencePeer)
+	if err != nil {
+		return nil, err
+	}
+	sock.SetRcvtimeo(adminPeerTimeout)
+	return sock, nil
+}
+
+// mergeData acrescenta o que falta nos dados locais: usuários, canais e
+// mensagens por ID (as antigas recebem ID antes), respeitando os expurgos.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) mergeData(in PersistentData) int {
+	in.normalize()
+	added := 0
+	for id, at := range in.Tombstones {
+		if !srv.purged(id) {
+			srv.purgeMessages([]string{id}, at)
+		}
+	}
+	for _, ul := range in.Logins {
+		if !srv.userExists(ul.Username) {
+			srv.data.Logins = append(srv.data.Logins, ul)
+			added++
+		}
+	}
+	for _, ch := range in.Channels {
+		if !srv.channelExists(ch.Name) {
+			srv.data.Channels = append(srv.data.Channels, ch)
+			added++
+		}
+	}
+
+	known := make(map[string]bool, len(srv.data.ChannelMessages)+len(srv.data.UserMessages))
+	for _, cm := range srv.data.ChannelMessages {
+		known[cm.ID] = true
+	}
+	for _, um := range srv.data.UserMessages {
+		known[um.ID] = true
+	}
+	// known cresce com o que é copiado: IDs repetidos no próprio dump entram
+	// uma vez só
+	for _, cm := range in.ChannelMessages {
+		if !known[cm.ID] && !srv.purged(cm.ID) {
+			srv.insertChannelMessage(cm)
+			srv.indexMessage(cm.ID, cm.Message)
+			known[cm.ID] = true
+			added++
+		}
+	}
+	for _, um := range in.UserMessages {
+		if !known[um.ID] && !srv.purged(um.ID) {
+			srv.data.UserMessages = append(srv.data.UserMessages, um)
+			srv.indexMessage(um.ID, um.Message)
+			known[um.ID] = true
+			added++
+		}
+	}
+	return added
+}
+
+// fetchDump pede admin_dump a outro servidor.
+func (srv *Server) fetchDump(peer string) (PersistentData, error) {
+	var dump PersistentData
+	if peer == srv.serverName {
+		return dump, fmt.Errorf("não é possível ressincronizar a partir de si mesmo")
+	}
+	sock, err := srv.dial(peer)
+	if err != nil {
+		return dump, err
+	}
+	defer sock.Close()
+	sock.SetRcvtimeo(adminPeerTimeout)
+
+	req := AdminRequest{Service: "admin_dump"}
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Nonce = protocol.NewAdminNonce()
+	req.Data.Auth = protocol.AdminSignature(os.Getenv("ADMIN_TOKEN"), req.Service, req.Data.Timestamp, req.Data.Nonce, protocol.AdminParams{})
+	req.Data.Clock = srv.incrementClock()
+	reqData, _ := msgpack.Marshal(req)
+	if _, err := sock.SendBytes(reqData, 0); err != nil {
+		return dump, err
+	}
+	respData, err := sock.RecvBytes(0)
+	if err != nil {
+		return dump, fmt.Errorf("%s não respondeu: %v", peer, err)
+	}
+
+	var resp AdminResponse
+	if err := msgpack.Unmarshal(respData, &resp); err != nil {
+		return dump, err
+	}
+	srv.updateClock(resp.Data.Clock)
+	if resp.Data.Status == "erro" {
+		return dump, fmt.Errorf("%s: %s", resp.Data.Error, resp.Data.Message)
+	}
+	err = json.Unmarshal(resp.Data.JSON, &dump)
+	return dump, err
+}
+
+// resyncFrom copia de peer o que falta localmente. Roda fora do loop de
+// requisições; o resultado aparece em admin_status.
+func (srv *Server) resyncFrom(peer string) {
+	result := &protocol.ResyncResult{Peer: peer, Started: time.Now().Unix()}
+	srv.resyncMutex.Lock()
+	srv.lastResync = result
+	srv.resyncMutex.Unlock()
+
+	dump, err := srv.fetchDump(peer)
+	added := 0
+	if err == nil {
+		srv.dataMutex.Lock()
+		added = srv.mergeData(dump)
+		if added > 0 {
+			err = srv.writeData()
+		}
+		srv.dataMutex.Unlock()
+	}
+
+	srv.resyncMutex.Lock()
+	result.Finished = time.Now().Unix()
+	result.Added = added
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("❌ Ressincronização a partir de %s falhou: %v", peer, err)
+	} else {
+		log.Printf("🔄 Ressincronização a partir de %s concluída: %d itens copiados", peer, added)
+	}
+	srv.resyncMutex.Unlock()
+}
+
+func (srv *Server) handleAdmin(service string, msg []byte) ([]byte, error) {
+	var req AdminRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := AdminResponse{Service: service}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Status = "OK"
+	fail := func(code, message string) ([]byte, error) {
+		return srv.errorPayload(service, code, message), nil
+	}
+
+	switch service {
+	case "admin_status":
+		resp.Data.Info = srv.adminStatus()
+
+	case "admin_election":
+		sock, err := srv.newReferenceSocket()
+		if err != nil {
+			return fail(ErrInternal, err.Error())
+		}
+		log.Printf("🛠️  Eleição solicitada pelo administrador")
+		srv.spawn(func() {
+			defer sock.Close()
+			if err := srv.initiateElection(sock); err != nil {
+				log.Printf("⚠️  Eleição solicitada falhou: %v", err)
+			}
+		})
+		resp.Data.Message = "Eleição iniciada"
+
+	case "admin_sync":
+		sock, err := srv.newReferenceSocket()
+		if err != nil {
+			return fail(ErrInternal, err.Error())
+		}
+		log.Printf("🛠️  Sincronização Berkeley solicitada pelo administrador")
+		srv.spawn(func() {
+			defer sock.Close()
+			if err := srv.berkeleyCoordinator(sock); err != nil {
+				log.Printf("⚠️  Sincronização solicitada falhou: %v", err)
+			}
+		})
+		resp.Data.Message = "Sincronização iniciada"
+
+	case "admin_resync":
+		if req.Data.Peer == "" || req.Data.Peer == srv.serverName {
+			return fail(ErrInvalidArgument, "Informe outro servidor em peer")
+		}
+		log.Printf("🛠️  Ressincronização a partir de %s solicitada pelo administrador", req.Data.Peer)
+		srv.spawn(func() { srv.resyncFrom(req.Data.Peer) })
+		resp.Data.Message = "Ressincronização iniciada; acompanhe em admin_status"
+
+	case "admin_dump":
+		srv.dataMutex.Lock()
+		dump, err := json.MarshalIndent(srv.data, "", "  ")
+		srv.dataMutex.Unlock()
+		if err != nil {
+			return fail(ErrInternal, err.Error())
+		}
+		resp.Data.JSON = dump
+
+	case "admin_restore":
+		var in PersistentData
+		if err := json.Unmarshal(req.Data.JSON, &in); err != nil {
+			return fail(ErrInvalidPayload, "Arquivo de dados inválido: "+err.Error())
+		}
+		srv.dataMutex.Lock()
+		switch req.Data.Mode {
+		case "merge":
+			resp.Data.Added = srv.mergeData(in)
+		case "", "replace":
+			in.normalize()
+			srv.data = in
+			srv.rebuildSearchIndex()
+		default:
+			srv.dataMutex.Unlock()
+			return fail(ErrInvalidArgument, "mode deve ser replace ou merge")
+		}
+		err := srv.writeData()
+		srv.dataMutex.Unlock()
+		if err != nil {
+			return fail(ErrStorage, err.Error())
+		}
+		log.Printf("🛠️  Dados restaurados pelo administrador (modo %s)", req.Data.Mode)
+
+	default:
+		return fail(ErrUnknownService, "Serviço administrativo desconhecido: "+service)
+	}
+	return msgpack.Marshal(resp)
+}
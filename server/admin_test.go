@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/protocol"
+)
+
+func adminRequest(service, nonce string, signed, sent protocol.AdminParams) []byte {
+	var req AdminRequest
+	req.Service = service
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Nonce = nonce
+	req.Data.Auth = protocol.AdminSignature(testAdminToken, service, req.Data.Timestamp, nonce, signed)
+	req.Data.Peer, req.Data.Mode, req.Data.JSON = sent.Peer, sent.Mode, sent.JSON
+	msg, _ := msgpack.Marshal(req)
+	return msg
+}
+
+func TestAdminAuthRejectsReplay(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", testAdminToken)
+	srv := newServer(Config{Name: "a"})
+	params := protocol.AdminParams{Peer: "b"}
+	msg := adminRequest("admin_resync", "n1", params, params)
+
+	if verr := srv.checkAdminAuth("admin_resync", msg); verr != nil {
+		t.Fatalf("primeira requisição recusada: %v", verr)
+	}
+	if verr := srv.checkAdminAuth("admin_resync", msg); verr == nil || verr.Field != "nonce" {
+		t.Fatalf("repetição aceita ou recusada pelo motivo errado: %v", verr)
+	}
+}
+
+func TestAdminAuthCoversParams(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", testAdminToken)
+	srv := newServer(Config{Name: "a"})
+	signed := protocol.AdminParams{Mode: "merge", JSON: []byte(`{}`)}
+	sent := protocol.AdminParams{Mode: "replace", JSON: []byte(`{}`)}
+
+	msg := adminRequest("admin_restore", "n1", signed, sent)
+	if verr := srv.checkAdminAuth("admin_restore", msg); verr == nil || verr.Field != "auth" {
+		t.Fatalf("parâmetros alterados aceitos: %v", verr)
+	}
+	if verr := srv.checkAdminAuth("admin_restore", adminRequest("admin_restore", "", signed, signed)); verr == nil {
+		t.Fatal("requisição sem nonce aceita")
+	}
+}
+
+// Dump com mensagens fora de ordem, sem ID, repetidas e listas nulas.
+func legacyDump() PersistentData {
+	return PersistentData{
+		Channels: []Channel{{Name: "geral"}},
+		ChannelMessages: []ChannelMessage{
+			{ID: "m2", Channel: "geral", User: "alice", Message: "dois", Clock: 2},
+			{Channel: "geral", User: "alice", Message: "antiga", Timestamp: 1},
+			{ID: "m2", Channel: "geral", User: "alice", Message: "dois", Clock: 2},
+			{ID: "m1", Channel: "geral", User: "alice", Message: "um", Clock: 1},
+		},
+	}
+}
+
+func TestRestoreNormalizesData(t *testing.T) {
+	srv := newServer(Config{Name: "a", DataDir: t.TempDir()})
+	srv.data.normalize()
+
+	var req AdminRequest
+	req.Service = "admin_restore"
+	req.Data.JSON, _ = json.Marshal(legacyDump())
+	msg, _ := msgpack.Marshal(req)
+	if _, err := srv.handleAdmin("admin_restore", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	d := srv.data
+	if d.UserMessages == nil || d.Logins == nil || d.Tombstones == nil {
+		t.Errorf("listas nulas depois do restore: %+v", d)
+	}
+	for i, cm := range d.ChannelMessages {
+		if cm.ID == "" {
+			t.Errorf("mensagem %d sem ID", i)
+		}
+		if i > 0 && channelOrderLess(cm, d.ChannelMessages[i-1]) {
+			t.Errorf("mensagem %d fora da ordem do canal", i)
+		}
+	}
+}
+
+func TestMergeSkipsDuplicates(t *testing.T) {
+	srv := newServer(Config{Name: "a"})
+	srv.data.normalize()
+	if added := srv.mergeData(legacyDump()); added != 4 { // canal, antiga, m1, m2
+		t.Errorf("%d itens copiados, esperados 4", added)
+	}
+	if n := len(srv.data.ChannelMessages); n != 3 {
+		t.Errorf("%d mensagens depois do merge, esperadas 3", n)
+	}
+	if added := srv.mergeData(legacyDump()); added != 0 {
+		t.Errorf("merge repetido copiou %d itens", added)
+	}
+}
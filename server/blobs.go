@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Anexos (armazenamento de blobs endereçado por conteúdo)
+// ----------------------------
+
+const (
+	maxChunkSize       = 256 * 1024
+	defaultMaxBlobSize = 10 * 1024 * 1024
+	uploadTimeout      = 10 * time.Minute
+)
+
+type UploadRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		UploadID  string `msgpack:"upload_id,omitempty"` // vazio no primeiro pedaço
+		Offset    int64  `msgpack:"offset"`
+		Chunk     []byte `msgpack:"chunk"`
+		Final     bool   `msgpack:"final"` // último pedaço
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type UploadResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string `msgpack:"status"`
+		Error     string `msgpack:"error,omitempty"`
+		Message   string `msgpack:"message,omitempty"`
+		UploadID  string `msgpack:"upload_id,omitempty"`
+		Hash      string `msgpack:"hash,omitempty"` // preenchido no último pedaço
+		Size      int64  `msgpack:"size"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type DownloadRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Hash      string `msgpack:"hash"`
+		Offset    int64  `msgpack:"offset"`
+		Length    int    `msgpack:"length,omitempty"` // padrão e máximo: 256 KiB
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type DownloadResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string `msgpack:"status"`
+		Error     string `msgpack:"error,omitempty"`
+		Message   string `msgpack:"message,omitempty"`
+		Hash      string `msgpack:"hash"`
+		Offset    int64  `msgpack:"offset"`
+		Size      int64  `msgpack:"size"` // tamanho total do blob
+		Chunk     []byte `msgpack:"chunk"`
+		EOF       bool   `msgpack:"eof"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Blob replicado entre servidores ("blob")
+type Blob struct {
+	Hash string `msgpack:"hash"`
+	Data []byte `msgpack:"data"`
+}
+
+// Upload em andamento
+type uploadSession struct {
+	user     string
+	file     *os.File
+	hasher   hash.Hash
+	size     int64
+	lastSeen time.Time
+}
+
+func maxBlobSize() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("MAX_BLOB_SIZE"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxBlobSize
+}
+
+// validBlobHash aceita apenas SHA-256 em hexadecimal, o que também impede
+// caminhos arbitrários no disco.
+func validBlobHash(h string) bool {
+	if len(h) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+func (srv *Server) blobDir() string {
+	return filepath.Join(srv.cfg.DataDir, "blobs")
+}
+
+func (srv *Server) blobPath(h string) string {
+	return filepath.Join(srv.blobDir(), h[:2], h)
+}
+
+func (srv *Server) blobExists(h string) bool {
+	if !validBlobHash(h) {
+		return false
+	}
+	_, err := os.Stat(srv.blobPath(h))
+	return err == nil
+}
+
+// storeBlob grava o conteúdo se ainda não existir. Retorna false quando o
+// blob já estava no disco (deduplicação).
+func (srv *Server) storeBlob(h string, content []byte) (bool, error) {
+	if srv.blobExists(h) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(srv.blobPath(h)), 0755); err != nil {
+		return false, err
+	}
+	tmp := srv.blobPath(h) + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, srv.blobPath(h))
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// expireUploads descarta uploads abandonados. Deve ser chamada com uploadsMutex travado.
+func (srv *Server) expireUploads() {
+	for id, s := range srv.uploads {
+		if time.Since(s.lastSeen) > uploadTimeout {
+			s.file.Close()
+			os.Remove(s.file.Name())
+			delete(srv.uploads, id)
+		}
+	}
+}
+
+func (srv *Server) discardUpload(id string) {
+	if s, ok := srv.uploads[id]; ok {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		delete(srv.uploads, id)
+	}
+}
+
+// applyBlob grava um blob recebido por replicação, após conferir o hash e o limite de tamanho.
+func (srv *Server) applyBlob(b Blob) {
+	sum := sha256.Sum256(b.Data)
+	if hex.EncodeToString(sum[:]) != b.Hash || int64(len(b.Data)) > maxBlobSize() {
+		log.Printf("handleReplication: blob %s inválido, descartado", b.Hash)
+		return
+	}
+	if _, err := srv.storeBlob(b.Hash, b.Data); err != nil {
+		log.Printf("handleReplication: erro ao gravar blob %s: %v", b.Hash, err)
+	}
+}
+
+// validAttachments verifica se todos os hashes referenciados existem.
+func (srv *Server) validAttachments(hashes []string) bool {
+	for _, h := range hashes {
+		if !srv.blobExists(h) {
+			return false
+		}
+	}
+	return true
+}
+
+func (srv *Server) handleUpload(msg []byte) ([]byte, error) {
+	var req UploadRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := UploadResponse{Service: "upload"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+
+	fail := func(code, message string) ([]byte, error) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = code
+		resp.Data.Message = message
+		return msgpack.Marshal(resp)
+	}
+
+	if !srv.userExists(req.Data.User) {
+		return fail(ErrUserNotFound, "Usuário não existe")
+	}
+	if len(req.Data.Chunk) > maxChunkSize {
+		return fail(ErrFieldTooLarge, fmt.Sprintf("Pedaço maior que o limite de %d bytes", maxChunkSize))
+	}
+
+	srv.uploadsMutex.Lock()
+	defer srv.uploadsMutex.Unlock()
+	srv.expireUploads()
+
+	session, ok := srv.uploads[req.Data.UploadID]
+	if req.Data.UploadID == "" {
+		if err := os.MkdirAll(filepath.Join(srv.blobDir(), "tmp"), 0755); err != nil {
+			return fail(ErrStorage, "Erro ao preparar armazenamento: "+err.Error())
+		}
+		id := newUploadID()
+		file, err := os.Create(filepath.Join(srv.blobDir(), "tmp", id))
+		if err != nil {
+			return fail(ErrStorage, "Erro ao preparar armazenamento: "+err.Error())
+		}
+		session = &uploadSession{user: req.Data.User, file: file, hasher: sha256.New(), lastSeen: time.Now()}
+		srv.uploads[id] = session
+		req.Data.UploadID = id
+	} else if !ok || session.user != req.Data.User {
+		return fail(ErrUploadNotFound, "Upload não encontrado")
+	}
+	resp.Data.UploadID = req.Data.UploadID
+
+	if req.Data.Offset != session.size {
+		resp.Data.Size = session.size
+		return fail(ErrInvalidOffset, "Offset inválido, esperado "+strconv.FormatInt(session.size, 10))
+	}
+	if session.size+int64(len(req.Data.Chunk)) > maxBlobSize() {
+		srv.discardUpload(req.Data.UploadID)
+		return fail(ErrBlobTooLarge, fmt.Sprintf("Arquivo maior que o limite de %d bytes", maxBlobSize()))
+	}
+
+	if _, err := io.MultiWriter(session.file, session.hasher).Write(req.Data.Chunk); err != nil {
+		srv.discardUpload(req.Data.UploadID)
+		return fail(ErrStorage, "Erro ao gravar pedaço: "+err.Error())
+	}
+	session.size += int64(len(req.Data.Chunk))
+	session.lastSeen = time.Now()
+	resp.Data.Size = session.size
+
+	if !req.Data.Final {
+		resp.Data.Status = "OK"
+		return msgpack.Marshal(resp)
+	}
+
+	// Último pedaço: mover para o endereço definitivo (hash do conteúdo)
+	h := hex.EncodeToString(session.hasher.Sum(nil))
+	tmpPath := session.file.Name()
+	session.file.Close()
+	delete(srv.uploads, req.Data.UploadID)
+
+	isNew := !srv.blobExists(h)
+	if isNew {
+		os.MkdirAll(filepath.Dir(srv.blobPath(h)), 0755)
+		if err := os.Rename(tmpPath, srv.blobPath(h)); err != nil {
+			os.Remove(tmpPath)
+			return fail(ErrStorage, "Erro ao salvar arquivo: "+err.Error())
+		}
+	} else {
+		os.Remove(tmpPath)
+	}
+
+	resp.Data.Status = "OK"
+	resp.Data.Hash = h
+	log.Printf("📎 Upload de %s concluído: %s (%d bytes, novo: %v)", req.Data.User, h, session.size, isNew)
+
+	if isNew {
+		if content, err := os.ReadFile(srv.blobPath(h)); err == nil {
+			srv.replicateAsync("blob", Blob{Hash: h, Data: content})
+		}
+	}
+
+	return msgpack.Marshal(resp)
+}
+
+func (srv *Server) handleDownload(msg []byte) ([]byte, error) {
+	var req DownloadRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := DownloadResponse{Service: "download"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Hash = req.Data.Hash
+	resp.Data.Offset = req.Data.Offset
+
+	if !srv.blobExists(req.Data.Hash) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrBlobNotFound
+		resp.Data.Message = "Arquivo não encontrado"
+		return msgpack.Marshal(resp)
+	}
+
+	file, err := os.Open(srv.blobPath(req.Data.Hash))
+	if err != nil {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrStorage
+		resp.Data.Message = "Erro ao abrir arquivo: " + err.Error()
+		return msgpack.Marshal(resp)
+	}
+	defer file.Close()
+
+	info, _ := file.Stat()
+	resp.Data.Size = info.Size()
+
+	length := req.Data.Length
+	if length <= 0 || length > maxChunkSize {
+		length = maxChunkSize
+	}
+	if req.Data.Offset < 0 || req.Data.Offset > resp.Data.Size {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidOffset
+		resp.Data.Message = "Offset inválido"
+		return msgpack.Marshal(resp)
+	}
+
+	buf := make([]byte, length)
+	n, err := file.ReadAt(buf, req.Data.Offset)
+	if err != nil && err != io.EOF {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrStorage
+		resp.Data.Message = "Erro ao ler arquivo: " + err.Error()
+		return msgpack.Marshal(resp)
+	}
+
+	resp.Data.Status = "OK"
+	resp.Data.Chunk = buf[:n]
+	resp.Data.EOF = req.Data.Offset+int64(n) >= resp.Data.Size
+
+	return msgpack.Marshal(resp)
+}
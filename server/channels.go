@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/protocol"
+)
+
+// ----------------------------
+// Metadados de canais
+// ----------------------------
+
+// Estruturas para gerenciamento de canais
+type ChannelUpdateRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User        string           `msgpack:"user"`
+		Channel     string           `msgpack:"channel"`
+		Topic       *string          `msgpack:"topic,omitempty"`
+		Description *string          `msgpack:"description,omitempty"`
+		Retention   *RetentionPolicy `msgpack:"retention,omitempty"` // {} remove a política do canal
+		Timestamp   int64            `msgpack:"timestamp"`
+		Clock       int64            `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ChannelRenameRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		Channel   string `msgpack:"channel"`
+		NewName   string `msgpack:"new_name"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ChannelArchiveRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		Channel   string `msgpack:"channel"`
+		Unarchive bool   `msgpack:"unarchive,omitempty"` // true para reabrir o canal
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ChannelDeleteRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		Channel   string `msgpack:"channel"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Conteúdo replicado em uma renomeação
+type ChannelRename struct {
+	From string `msgpack:"from"`
+	To   string `msgpack:"to"`
+}
+
+// UnmarshalJSON aceita o formato antigo do arquivo de dados, em que cada
+// canal era apenas uma string com o nome.
+func (c *Channel) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*c = Channel{Name: name}
+		return nil
+	}
+
+	type plain Channel
+	return json.Unmarshal(b, (*plain)(c))
+}
+
+// findChannel retorna o índice do canal em data.Channels, ou -1.
+func (srv *Server) findChannel(name string) int {
+	for i, ch := range srv.data.Channels {
+		if ch.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (srv *Server) channelArchived(name string) bool {
+	srv.dataMutex.Lock()
+	defer srv.dataMutex.Unlock()
+	return srv.isArchived(name)
+}
+
+// isArchived é a versão de channelArchived para quem já travou dataMutex.
+func (srv *Server) isArchived(name string) bool {
+	i := srv.findChannel(name)
+	return i >= 0 && srv.data.Channels[i].Archived
+}
+
+// isModerator verifica se o usuário está na lista MODERATORS (separada por vírgulas).
+func isModerator(user string) bool {
+	if user == "" {
+		return false
+	}
+	for _, m := range strings.Split(os.Getenv("MODERATORS"), ",") {
+		if strings.TrimSpace(m) == user {
+			return true
+		}
+	}
+	return false
+}
+
+// canManageChannel: dono do canal ou moderador. Canais sem dono (criados
+// antes dos metadados ou por clientes que não enviam user) só podem ser
+// gerenciados por moderadores.
+func canManageChannel(ch Channel, user string) bool {
+	return (ch.Owner != "" && ch.Owner == user) || isModerator(user)
+}
+
+// renameChannel renomeia o canal e move o histórico para o novo nome.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) renameChannel(from, to string) bool {
+	i := srv.findChannel(from)
+	if i < 0 || srv.findChannel(to) >= 0 {
+		return false
+	}
+	srv.data.Channels[i].Name = to
+	for j := range srv.data.ChannelMessages {
+		if srv.data.ChannelMessages[j].Channel == from {
+			srv.data.ChannelMessages[j].Channel = to
+		}
+	}
+	return true
+}
+
+// deleteChannel remove o canal e suas mensagens. Deve ser chamada com dataMutex travado.
+func (srv *Server) deleteChannel(name string) bool {
+	i := srv.findChannel(name)
+	if i < 0 {
+		return false
+	}
+	srv.data.Channels = append(srv.data.Channels[:i], srv.data.Channels[i+1:]...)
+
+	kept := srv.data.ChannelMessages[:0]
+	for _, cm := range srv.data.ChannelMessages {
+		if cm.Channel != name {
+			kept = append(kept, cm)
+		} else {
+			srv.unindexMessage(cm.ID)
+		}
+	}
+	srv.data.ChannelMessages = kept
+	return true
+}
+
+// listChannels monta a listagem de canais com filtro e ordenação.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) listChannels(filter, sortBy string, includeArchived bool) []ChannelInfo {
+	stats := make(map[string]*ChannelInfo)
+	infos := []ChannelInfo{}
+	filter = strings.ToLower(filter)
+
+	for _, ch := range srv.data.Channels {
+		if ch.Archived && !includeArchived {
+			continue
+		}
+		if filter != "" &&
+			!strings.Contains(strings.ToLower(ch.Name), filter) &&
+			!strings.Contains(strings.ToLower(ch.Topic), filter) &&
+			!strings.Contains(strings.ToLower(ch.Description), filter) {
+			continue
+		}
+		infos = append(infos, ChannelInfo{
+			Name:         ch.Name,
+			Topic:        ch.Topic,
+			Description:  ch.Description,
+			Owner:        ch.Owner,
+			CreatedAt:    ch.CreatedAt,
+			Archived:     ch.Archived,
+			Retention:    (*protocol.RetentionPolicy)(ch.Retention),
+			LastActivity: ch.CreatedAt,
+		})
+	}
+	for i := range infos {
+		stats[infos[i].Name] = &infos[i]
+	}
+
+	for _, cm := range srv.data.ChannelMessages {
+		if info, ok := stats[cm.Channel]; ok {
+			info.MessageCount++
+			if cm.Timestamp > info.LastActivity {
+				info.LastActivity = cm.Timestamp
+			}
+		}
+	}
+
+	switch sortBy {
+	case "activity":
+		sort.SliceStable(infos, func(i, j int) bool { return infos[i].LastActivity > infos[j].LastActivity })
+	case "created":
+		sort.SliceStable(infos, func(i, j int) bool { return infos[i].CreatedAt < infos[j].CreatedAt })
+	default:
+		sort.SliceStable(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	}
+	return infos
+}
+
+// Handler para atualização de tópico/descrição
+func (srv *Server) handleChannelUpdate(msg []byte) ([]byte, error) {
+	var req ChannelUpdateRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := ChannelResponse{Service: "channel_update"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+
+	srv.dataMutex.Lock()
+	i := srv.findChannel(req.Data.Channel)
+	if i < 0 {
+		srv.dataMutex.Unlock()
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelNotFound
+		resp.Data.Description = "Canal não existe"
+		return msgpack.Marshal(resp)
+	}
+	if !canManageChannel(srv.data.Channels[i], req.Data.User) {
+		srv.dataMutex.Unlock()
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrForbidden
+		resp.Data.Description = "Sem permissão para alterar o canal"
+		return msgpack.Marshal(resp)
+	}
+	if req.Data.Topic != nil {
+		srv.data.Channels[i].Topic = *req.Data.Topic
+	}
+	if req.Data.Description != nil {
+		srv.data.Channels[i].Description = *req.Data.Description
+	}
+	if req.Data.Retention != nil {
+		if *req.Data.Retention == (RetentionPolicy{}) {
+			srv.data.Channels[i].Retention = nil
+		} else {
+			srv.data.Channels[i].Retention = req.Data.Retention
+		}
+	}
+	channel := srv.data.Channels[i]
+	srv.dataMutex.Unlock()
+
+	if err := srv.saveData(); err != nil {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrStorage
+		resp.Data.Description = "Erro ao salvar dados: " + err.Error()
+		return msgpack.Marshal(resp)
+	}
+
+	resp.Data.Status = "sucesso"
+	log.Printf("📝 Canal #%s atualizado por %s (clock: %d)", channel.Name, req.Data.User, resp.Data.Clock)
+	srv.replicateAsync("channel_update", channel)
+
+	return msgpack.Marshal(resp)
+}
+
+// Handler para renomear canal (o histórico acompanha o novo nome)
+func (srv *Server) handleChannelRename(msg []byte) ([]byte, error) {
+	var req ChannelRenameRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := ChannelResponse{Service: "channel_rename"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+
+	if strings.TrimSpace(req.Data.NewName) == "" {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidName
+		resp.Data.Description = "Nome do canal não pode ser vazio"
+		return msgpack.Marshal(resp)
+	}
+
+	srv.dataMutex.Lock()
+	i := srv.findChannel(req.Data.Channel)
+	switch {
+	case i < 0:
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelNotFound
+		resp.Data.Description = "Canal não existe"
+	case !canManageChannel(srv.data.Channels[i], req.Data.User):
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrForbidden
+		resp.Data.Description = "Sem permissão para alterar o canal"
+	case !srv.renameChannel(req.Data.Channel, req.Data.NewName):
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelExists
+		resp.Data.Description = "Canal já existe"
+	}
+	srv.dataMutex.Unlock()
+
+	if resp.Data.Status != "" {
+		return msgpack.Marshal(resp)
+	}
+
+	if err := srv.saveData(); err != nil {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrStorage
+		resp.Data.Description = "Erro ao salvar dados: " + err.Error()
+		return msgpack.Marshal(resp)
+	}
+
+	resp.Data.Status = "sucesso"
+	log.Printf("✏️  Canal #%s renomeado para #%s por %s (clock: %d)",
+		req.Data.Channel, req.Data.NewName, req.Data.User, resp.Data.Clock)
+	srv.replicateAsync("channel_rename", ChannelRename{From: req.Data.Channel, To: req.Data.NewName})
+
+	return msgpack.Marshal(resp)
+}
+
+// Handler para arquivar (somente leitura) ou reabrir um canal
+func (srv *Server) handleChannelArchive(msg []byte) ([]byte, error) {
+	var req ChannelArchiveRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := ChannelResponse{Service: "channel_archive"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+
+	srv.dataMutex.Lock()
+	i := srv.findChannel(req.Data.Channel)
+	if i < 0 {
+		srv.dataMutex.Unlock()
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelNotFound
+		resp.Data.Description = "Canal não existe"
+		return msgpack.Marshal(resp)
+	}
+	if !canManageChannel(srv.data.Channels[i], req.Data.User) {
+		srv.dataMutex.Unlock()
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrForbidden
+		resp.Data.Description = "Sem permissão para alterar o canal"
+		return msgpack.Marshal(resp)
+	}
+	srv.data.Channels[i].Archived = !req.Data.Unarchive
+	channel := srv.data.Channels[i]
+	srv.dataMutex.Unlock()
+
+	if err := srv.saveData(); err != nil {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrStorage
+		resp.Data.Description = "Erro ao salvar dados: " + err.Error()
+		return msgpack.Marshal(resp)
+	}
+
+	resp.Data.Status = "sucesso"
+	log.Printf("🗄️  Canal #%s arquivado=%v por %s (clock: %d)",
+		channel.Name, channel.Archived, req.Data.User, resp.Data.Clock)
+	srv.replicateAsync("channel_update", channel)
+
+	return msgpack.Marshal(resp)
+}
+
+// Handler para remover um canal e seu histórico
+func (srv *Server) handleChannelDelete(msg []byte) ([]byte, error) {
+	var req ChannelDeleteRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := ChannelResponse{Service: "channel_delete"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+
+	srv.dataMutex.Lock()
+	i := srv.findChannel(req.Data.Channel)
+	switch {
+	case i < 0:
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelNotFound
+		resp.Data.Description = "Canal não existe"
+	case !canManageChannel(srv.data.Channels[i], req.Data.User):
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrForbidden
+		resp.Data.Description = "Sem permissão para remover o canal"
+	default:
+		srv.deleteChannel(req.Data.Channel)
+	}
+	srv.dataMutex.Unlock()
+
+	if resp.Data.Status != "" {
+		return msgpack.Marshal(resp)
+	}
+
+	if err := srv.saveData(); err != nil {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrStorage
+		resp.Data.Description = "Erro ao salvar dados: " + err.Error()
+		return msgpack.Marshal(resp)
+	}
+
+	resp.Data.Status = "sucesso"
+	log.Printf("🗑️  Canal #%s removido por %s (clock: %d)", req.Data.Channel, req.Data.User, resp.Data.Clock)
+	srv.replicateAsync("channel_delete", req.Data.Channel)
+
+	return msgpack.Marshal(resp)
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"server/protocol"
+)
+
+// Serviços administrativos. Cada chamada é assinada com o token (o mesmo
+// ADMIN_TOKEN do servidor) e vale só para o servidor que a recebe; para
+// administrar um nó específico, use um Client com apenas esse endpoint.
+
+// Admin executa um serviço "admin_*" assinado com token. A assinatura cobre
+// peer, mode e json e usa um nonce novo a cada chamada.
+func (c *Client) Admin(ctx context.Context, token, service string, data map[string]interface{}, out interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	params := protocol.AdminParams{}
+	params.Peer, _ = data["peer"].(string)
+	params.Mode, _ = data["mode"].(string)
+	params.JSON, _ = data["json"].([]byte)
+	ts, nonce := time.Now().Unix(), protocol.NewAdminNonce()
+	data["timestamp"] = ts
+	data["nonce"] = nonce
+	data["auth"] = protocol.AdminSignature(token, service, ts, nonce, params)
+	return c.Call(ctx, service, data, out)
+}
+
+// AdminStatus devolve rank, coordenador, relógios e contagens do servidor.
+func (c *Client) AdminStatus(ctx context.Context, token string) (*protocol.AdminStatus, error) {
+	var resp struct {
+		Info *protocol.AdminStatus `msgpack:"info"`
+	}
+	if err := c.Admin(ctx, token, "admin_status", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Info, nil
+}
+
+// TriggerElection faz o servidor iniciar uma eleição (assíncrona).
+func (c *Client) TriggerElection(ctx context.Context, token string) error {
+	return c.Admin(ctx, token, "admin_election", nil, nil)
+}
+
+// TriggerSync faz o servidor coordenar uma sincronização Berkeley (assíncrona).
+func (c *Client) TriggerSync(ctx context.Context, token string) error {
+	return c.Admin(ctx, token, "admin_sync", nil, nil)
+}
+
+// Resync faz o servidor copiar de peer (nome do servidor, ex.: "server2") o
+// que falta na sua réplica. O resultado aparece depois em AdminStatus.
+func (c *Client) Resync(ctx context.Context, token, peer string) error {
+	return c.Admin(ctx, token, "admin_resync", map[string]interface{}{"peer": peer}, nil)
+}
+
+// Dump devolve os dados persistidos do servidor no formato do arquivo
+// server_data.json.
+func (c *Client) Dump(ctx context.Context, token string) ([]byte, error) {
+	var resp struct {
+		JSON []byte `msgpack:"json"`
+	}
+	if err := c.Admin(ctx, token, "admin_dump", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.JSON, nil
+}
+
+// Restore substitui os dados do servidor pelo conteúdo de um Dump. Com
+// merge, só acrescenta o que falta e devolve quantos itens foram copiados.
+func (c *Client) Restore(ctx context.Context, token string, dump []byte, merge bool) (int, error) {
+	mode := "replace"
+	if merge {
+		mode = "merge"
+	}
+	var resp struct {
+		Added int `msgpack:"added"`
+	}
+	err := c.Admin(ctx, token, "admin_restore", map[string]interface{}{"json": dump, "mode": mode}, &resp)
+	return resp.Added, err
+}
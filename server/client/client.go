@@ -0,0 +1,489 @@
+// Package client é o SDK Go do servidor de mensagens. Fala o protocolo do
+// socket ZeroMQ (MessagePack, envelope v2) e cuida do relógio lógico de
+// Lamport, dos timeouts, das novas tentativas e do failover entre servidores.
+//
+//	c, err := client.New(client.Config{
+//		Endpoints: []string{"tcp://localhost:5555", "tcp://localhost:5556"},
+//		BrokerURL: "tcp://localhost:5558",
+//	})
+//	if err != nil { ... }
+//	defer c.Close()
+//	c.Login(ctx, "alice")
+//	id, err := c.Publish(ctx, "alice", "geral", "Olá!")
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/protocol"
+)
+
+const (
+	DefaultTimeout = 5 * time.Second
+	DefaultRetries = 2
+)
+
+// Config define os servidores e a política de novas tentativas.
+type Config struct {
+	Endpoints []string      // servidores (ROUTER), em ordem de preferência
+	BrokerURL string        // XPUB do broker, usado por Subscribe
+	Timeout   time.Duration // por tentativa (padrão DefaultTimeout)
+	Retries   int           // tentativas extras após a primeira (padrão DefaultRetries; -1 = nenhuma)
+	Backoff   time.Duration // espera antes de cada nova tentativa (padrão 200ms, dobra a cada vez)
+}
+
+// Error é um erro devolvido pelo servidor. Code é um dos códigos de
+// protocol (ex.: protocol.ErrChannelNotFound).
+type Error struct {
+	Service    string
+	Code       string
+	Message    string
+	RequestID  string
+	RetryAfter time.Duration // só em rate_limited
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", e.Service, e.Message, e.Code)
+}
+
+// ErrClosed indica uso do cliente depois de Close.
+var ErrClosed = errors.New("client: cliente fechado")
+
+// ErrNoEndpoints indica uma Config sem servidores.
+var ErrNoEndpoints = errors.New("client: nenhum servidor configurado")
+
+// Client é seguro para uso concorrente; as requisições são serializadas no
+// mesmo socket REQ.
+type Client struct {
+	cfg Config
+
+	mu       sync.Mutex
+	sock     *zmq.Socket
+	endpoint int // índice do servidor atual em cfg.Endpoints
+	closed   bool
+
+	clock   int64 // relógio lógico de Lamport (atômico)
+	counter int64 // sequência dos request_id
+	id      string
+}
+
+// New cria o cliente. A conexão é aberta na primeira requisição.
+func New(cfg Config) (*Client, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = DefaultRetries
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 200 * time.Millisecond
+	}
+	return &Client{cfg: cfg, id: fmt.Sprintf("go-%x", time.Now().UnixNano())}, nil
+}
+
+// Close fecha o socket. Assinaturas abertas continuam até o próprio Close.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return c.disconnect()
+}
+
+// Endpoint devolve o servidor em uso no momento.
+func (c *Client) Endpoint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg.Endpoints[c.endpoint]
+}
+
+// Clock devolve o relógio lógico atual do cliente.
+func (c *Client) Clock() int64 {
+	return atomic.LoadInt64(&c.clock)
+}
+
+// tick incrementa o relógio antes de um envio.
+func (c *Client) tick() int64 {
+	return atomic.AddInt64(&c.clock, 1)
+}
+
+// observe aplica a regra de Lamport ao receber um relógio: max(local, recebido) + 1.
+func (c *Client) observe(received int64) {
+	for {
+		local := atomic.LoadInt64(&c.clock)
+		next := local
+		if received > next {
+			next = received
+		}
+		if atomic.CompareAndSwapInt64(&c.clock, local, next+1) {
+			return
+		}
+	}
+}
+
+func (c *Client) header(service string) protocol.Header {
+	return protocol.Header{
+		Service:   service,
+		Version:   protocol.Version,
+		RequestID: fmt.Sprintf("%s-%d", c.id, atomic.AddInt64(&c.counter, 1)),
+	}
+}
+
+func (c *Client) connect() error {
+	if c.sock != nil {
+		return nil
+	}
+	sock, err := zmq.NewSocket(zmq.REQ)
+	if err != nil {
+		return err
+	}
+	sock.SetLinger(0)
+	if err := sock.Connect(c.cfg.Endpoints[c.endpoint]); err != nil {
+		sock.Close()
+		return err
+	}
+	c.sock = sock
+	return nil
+}
+
+func (c *Client) disconnect() error {
+	if c.sock == nil {
+		return nil
+	}
+	err := c.sock.Close()
+	c.sock = nil
+	return err
+}
+
+// failover descarta o socket (um REQ sem resposta não pode ser reaproveitado)
+// e passa para o próximo servidor.
+func (c *Client) failover() {
+	c.disconnect()
+	c.endpoint = (c.endpoint + 1) % len(c.cfg.Endpoints)
+}
+
+// roundTrip faz uma tentativa no servidor atual.
+func (c *Client) roundTrip(ctx context.Context, payload []byte) ([]byte, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	timeout := c.cfg.Timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); left < timeout {
+			timeout = left
+		}
+	}
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	c.sock.SetSndtimeo(timeout)
+	c.sock.SetRcvtimeo(timeout)
+
+	if _, err := c.sock.SendBytes(payload, 0); err != nil {
+		return nil, err
+	}
+	reply, err := c.sock.RecvBytes(0)
+	if err != nil {
+		return nil, fmt.Errorf("sem resposta de %s em %v: %w", c.cfg.Endpoints[c.endpoint], timeout, err)
+	}
+	return reply, nil
+}
+
+// retryable indica erros do servidor que valem uma nova tentativa em outro nó.
+func retryable(err error) bool {
+	var serr *Error
+	if errors.As(err, &serr) {
+		return serr.Code == protocol.ErrBrokerUnavailable || serr.Code == protocol.ErrStorage
+	}
+	return true // timeout ou falha de transporte
+}
+
+// call envia a requisição, com novas tentativas e failover, e decodifica o
+// data da resposta em out. O relógio do cliente vai em data.clock, que
+// deve ser preenchido por quem chama com c.tick().
+//
+// Uma publicação cuja resposta se perdeu pode ser repetida em outro servidor;
+// quem precisa de exatamente uma entrega deve deduplicar pelo conteúdo.
+func (c *Client) call(ctx context.Context, service string, req interface{}, out interface{}) error {
+	payload, err := msgpack.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	backoff := c.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		if c.closed {
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var reply []byte
+		reply, err = c.roundTrip(ctx, payload)
+		if err == nil {
+			err = c.decode(service, reply, out)
+			if err == nil {
+				return nil
+			}
+		}
+		// Sem resposta, ou um nó com broker ou disco com problema: a próxima
+		// tentativa vai para outro servidor
+		if retryable(err) {
+			c.failover()
+		}
+
+		if attempt >= c.cfg.Retries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// decode interpreta o envelope, atualiza o relógio e devolve o erro do
+// servidor, se houver.
+func (c *Client) decode(service string, reply []byte, out interface{}) error {
+	var env protocol.Envelope
+	if err := msgpack.Unmarshal(reply, &env); err != nil {
+		return fmt.Errorf("%s: resposta inválida: %w", service, err)
+	}
+
+	var meta struct {
+		Clock      int64   `msgpack:"clock"`
+		RetryAfter float64 `msgpack:"retry_after"`
+	}
+	msgpack.Unmarshal(env.Data, &meta)
+	c.observe(meta.Clock)
+
+	if env.Status == protocol.StatusError || env.Error != nil {
+		serr := &Error{Service: service, Code: protocol.ErrInternal, RequestID: env.RequestID}
+		if env.Error != nil {
+			serr.Code, serr.Message = env.Error.Code, env.Error.Message
+		}
+		serr.RetryAfter = time.Duration(meta.RetryAfter * float64(time.Second))
+		return serr
+	}
+	if out == nil {
+		return nil
+	}
+	return msgpack.Unmarshal(env.Data, out)
+}
+
+// Login registra o usuário (ou confirma um já existente). Servidores antigos
+// respondem user_exists para um usuário já cadastrado; isso também é sucesso.
+func (c *Client) Login(ctx context.Context, user string) error {
+	req := protocol.LoginRequest{Header: c.header("login")}
+	req.Data.User = user
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = c.tick()
+
+	var resp protocol.LoginResponse
+	err := c.call(ctx, "login", &req, &resp.Data)
+	var serr *Error
+	if errors.As(err, &serr) && serr.Code == protocol.ErrUserExists {
+		return nil
+	}
+	return err
+}
+
+// Users lista os usuários cadastrados.
+func (c *Client) Users(ctx context.Context) ([]string, error) {
+	req := protocol.UsersRequest{Header: c.header("users")}
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = c.tick()
+
+	var resp protocol.UsersResponse
+	if err := c.call(ctx, "users", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	return resp.Data.Users, nil
+}
+
+// ChannelOptions são os campos opcionais de CreateChannel.
+type ChannelOptions struct {
+	Owner       string // dono (pode alterar, arquivar e remover o canal)
+	Topic       string
+	Description string
+}
+
+// CreateChannel cria um canal.
+func (c *Client) CreateChannel(ctx context.Context, channel string, opts ChannelOptions) error {
+	req := protocol.ChannelRequest{Header: c.header("channel")}
+	req.Data.Channel = channel
+	req.Data.User = opts.Owner
+	req.Data.Topic = opts.Topic
+	req.Data.Description = opts.Description
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = c.tick()
+
+	var resp protocol.ChannelResponse
+	return c.call(ctx, "channel", &req, &resp.Data)
+}
+
+// Channels lista os canais ativos, ordenados por nome.
+func (c *Client) Channels(ctx context.Context) ([]protocol.ChannelInfo, error) {
+	req := protocol.ChannelsRequest{Header: c.header("channels")}
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = c.tick()
+
+	var resp protocol.ChannelsResponse
+	if err := c.call(ctx, "channels", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	return resp.Data.Info, nil
+}
+
+// Publish publica no canal e devolve o ID da mensagem.
+func (c *Client) Publish(ctx context.Context, user, channel, message string) (string, error) {
+	req := protocol.PublishRequest{Header: c.header("publish")}
+	req.Data.User = user
+	req.Data.Channel = channel
+	req.Data.Message = message
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = c.tick()
+
+	var resp protocol.PublishResponse
+	if err := c.call(ctx, "publish", &req, &resp.Data); err != nil {
+		return "", err
+	}
+	return resp.Data.ID, nil
+}
+
+// SendMessage envia uma mensagem direta de src para dst e devolve o ID.
+func (c *Client) SendMessage(ctx context.Context, src, dst, message string) (string, error) {
+	req := protocol.MessageRequest{Header: c.header("message")}
+	req.Data.Src = src
+	req.Data.Dst = dst
+	req.Data.Message = message
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = c.tick()
+
+	var resp protocol.MessageResponse
+	if err := c.call(ctx, "message", &req, &resp.Data); err != nil {
+		return "", err
+	}
+	return resp.Data.ID, nil
+}
+
+// Estados de Receipt.
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// Pending devolve as mensagens diretas para user ainda não entregues, em
+// ordem de envio, e o servidor as confirma como entregues: cada mensagem vem
+// uma vez só. Deve ser chamado depois do login (e da assinatura, para não
+// perder as que chegarem no meio).
+func (c *Client) Pending(ctx context.Context, user string) ([]protocol.DirectMessage, error) {
+	var resp struct {
+		Messages []protocol.DirectMessage `msgpack:"messages"`
+	}
+	if err := c.Call(ctx, "pending", map[string]interface{}{"user": user}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Messages, nil
+}
+
+// Receipt confirma a entrega (ReceiptDelivered) ou a leitura (ReceiptRead)
+// de uma mensagem direta recebida por user. O remetente é avisado pelo broker.
+func (c *Client) Receipt(ctx context.Context, user, id, status string) error {
+	return c.Call(ctx, "receipt", map[string]interface{}{"user": user, "id": id, "status": status}, nil)
+}
+
+// Call executa um serviço qualquer do protocolo (history, react, search...)
+// com as mesmas garantias das demais chamadas. data é o conteúdo de "data" da
+// requisição; o relógio (clock) é preenchido aqui, assim como o timestamp se
+// data não trouxer um. A resposta é decodificada em out, que pode ser nil.
+func (c *Client) Call(ctx context.Context, service string, data map[string]interface{}, out interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data["timestamp"]; !ok {
+		data["timestamp"] = time.Now().Unix()
+	}
+	data["clock"] = c.tick()
+	h := c.header(service)
+	req := map[string]interface{}{
+		"service":    h.Service,
+		"version":    h.Version,
+		"request_id": h.RequestID,
+		"data":       data,
+	}
+	return c.call(ctx, service, req, out)
+}
+
+// HistoryMessage é uma mensagem do histórico de um canal.
+type HistoryMessage struct {
+	ID             string         `msgpack:"id"`
+	ParentID       string         `msgpack:"parent_id"`
+	User           string         `msgpack:"user"`
+	Message        string         `msgpack:"message"`
+	Attachments    []string       `msgpack:"attachments"`
+	Timestamp      int64          `msgpack:"timestamp"`
+	Clock          int64          `msgpack:"clock"`
+	Deleted        bool           `msgpack:"deleted"`
+	ReplyCount     int            `msgpack:"reply_count"`
+	ReactionCounts map[string]int `msgpack:"reaction_counts"`
+}
+
+// History devolve até limit mensagens do canal (0 = padrão do servidor),
+// na ordem do canal, da mais antiga para a mais recente. Com beforeID, só as
+// anteriores a essa mensagem (página anterior: o ID da primeira da página
+// atual).
+func (c *Client) History(ctx context.Context, channel string, limit int, beforeID string) ([]HistoryMessage, error) {
+	data := map[string]interface{}{"channel": channel}
+	if limit > 0 {
+		data["limit"] = limit
+	}
+	if beforeID != "" {
+		data["before_id"] = beforeID
+	}
+	var resp struct {
+		Messages []HistoryMessage `msgpack:"messages"`
+	}
+	if err := c.Call(ctx, "history", data, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Messages, nil
+}
+
+// DirectHistory devolve as mensagens diretas trocadas entre user e with, na
+// mesma ordem e paginação de History (User é o remetente). O servidor não
+// confere se quem pede é user: a consulta não é autenticada.
+func (c *Client) DirectHistory(ctx context.Context, user, with string, limit int, beforeID string) ([]HistoryMessage, error) {
+	data := map[string]interface{}{"user": user, "with": with}
+	if limit > 0 {
+		data["limit"] = limit
+	}
+	if beforeID != "" {
+		data["before_id"] = beforeID
+	}
+	var resp struct {
+		Messages []HistoryMessage `msgpack:"messages"`
+	}
+	if err := c.Call(ctx, "direct_history", data, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Messages, nil
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/topics"
+)
+
+// Event é uma publicação recebida do broker. Mensagens de canal e diretas
+// têm Event vazio; edições, reações e confirmações trazem o tipo em Event
+// ("edit", "delete", "react", "unreact", "receipt").
+type Event struct {
+	Kind string `msgpack:"-"` // topics.KindChannel ou topics.KindUser
+	Name string `msgpack:"-"` // canal ou usuário de destino
+
+	Event       string         `msgpack:"event"`
+	ID          string         `msgpack:"id"`
+	ParentID    string         `msgpack:"parent_id"`
+	User        string         `msgpack:"user"` // autor; em reações e confirmações, quem agiu
+	From        string         `msgpack:"from"`
+	Message     string         `msgpack:"message"`
+	Attachments []string       `msgpack:"attachments"`
+	Emoji       string         `msgpack:"emoji"`
+	Counts      map[string]int `msgpack:"counts"`
+	Status      string         `msgpack:"status"`
+	Timestamp   int64          `msgpack:"timestamp"`
+	Clock       int64          `msgpack:"clock"`
+
+	Raw []byte `msgpack:"-"` // publicação original (MessagePack)
+}
+
+// Subscription entrega os eventos dos tópicos assinados em C até Close.
+type Subscription struct {
+	C <-chan Event
+
+	dropped int64 // eventos descartados com C cheio (atômico)
+	events  chan Event
+	control chan subscriptionOp
+	done    chan struct{}
+	closing sync.Once
+	wg      sync.WaitGroup
+}
+
+type subscriptionOp struct {
+	topic     string
+	subscribe bool
+}
+
+// ErrNoBroker indica Subscribe sem Config.BrokerURL.
+var ErrNoBroker = errors.New("client: BrokerURL não configurado")
+
+// Subscribe assina, no broker, os canais pedidos e as mensagens diretas de
+// user (vazio para nenhum). Cada evento recebido atualiza o relógio lógico do
+// cliente. Se C não for consumido, eventos novos são descartados e contados
+// em Dropped.
+func (c *Client) Subscribe(user string, channels ...string) (*Subscription, error) {
+	if c.cfg.BrokerURL == "" {
+		return nil, ErrNoBroker
+	}
+	sock, err := zmq.NewSocket(zmq.SUB)
+	if err != nil {
+		return nil, err
+	}
+	sock.SetLinger(0)
+	if err := sock.Connect(c.cfg.BrokerURL); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	if user != "" {
+		sock.SetSubscribe(topics.User(user))
+	}
+	for _, ch := range channels {
+		sock.SetSubscribe(topics.Channel(ch))
+	}
+
+	s := &Subscription{
+		events:  make(chan Event, 256),
+		control: make(chan subscriptionOp, 16),
+		done:    make(chan struct{}),
+	}
+	s.C = s.events
+	s.wg.Add(1)
+	go s.run(c, sock)
+	return s, nil
+}
+
+// Join passa a receber as mensagens do canal.
+func (s *Subscription) Join(channel string) {
+	s.send(subscriptionOp{topic: topics.Channel(channel), subscribe: true})
+}
+
+// Leave deixa de receber as mensagens do canal.
+func (s *Subscription) Leave(channel string) {
+	s.send(subscriptionOp{topic: topics.Channel(channel)})
+}
+
+// JoinUser passa a receber também as mensagens diretas de user.
+func (s *Subscription) JoinUser(user string) {
+	s.send(subscriptionOp{topic: topics.User(user), subscribe: true})
+}
+
+func (s *Subscription) send(op subscriptionOp) {
+	select {
+	case s.control <- op:
+	case <-s.done:
+	}
+}
+
+// Dropped devolve quantos eventos foram descartados porque C estava cheio.
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close encerra a assinatura e fecha C.
+func (s *Subscription) Close() error {
+	s.closing.Do(func() { close(s.done) })
+	s.wg.Wait()
+	return nil
+}
+
+// run é a única goroutine que usa o socket SUB (sockets ZeroMQ não são
+// seguros entre goroutines).
+func (s *Subscription) run(c *Client, sock *zmq.Socket) {
+	defer s.wg.Done()
+	defer close(s.events)
+	defer sock.Close()
+
+	poller := zmq.NewPoller()
+	poller.Add(sock, zmq.POLLIN)
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case op := <-s.control:
+			if op.subscribe {
+				sock.SetSubscribe(op.topic)
+			} else {
+				sock.SetUnsubscribe(op.topic)
+			}
+			continue
+		default:
+		}
+
+		polled, err := poller.Poll(100 * time.Millisecond)
+		if err != nil || len(polled) == 0 {
+			continue
+		}
+		frames, err := sock.RecvMessageBytes(0)
+		if err != nil || len(frames) < 2 {
+			continue
+		}
+		ev, ok := decodeEvent(string(frames[0]), frames[len(frames)-1])
+		if !ok {
+			continue
+		}
+		c.observe(ev.Clock)
+
+		select {
+		case s.events <- ev:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+func decodeEvent(topic string, payload []byte) (Event, bool) {
+	kind, name, ok := topics.Parse(topic)
+	if !ok {
+		return Event{}, false
+	}
+	var ev Event
+	if err := msgpack.Unmarshal(payload, &ev); err != nil {
+		return Event{}, false
+	}
+	ev.Kind, ev.Name, ev.Raw = kind, name, payload
+	if ev.User == "" {
+		ev.User = ev.From
+	}
+	return ev, true
+}
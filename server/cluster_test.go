@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/client"
+)
+
+// ----------------------------
+// Cluster em processo para testes
+// ----------------------------
+
+// Um cluster de teste roda no mesmo processo do `go test`: vários Servers, um
+// servidor de referência e um broker, todos em endpoints inproc. Os cenários
+// derrubam, reiniciam e particionam nós e observam o estado de cada um.
+//
+//	c := newCluster(t, "a", "b", "c")
+//	c.Kill("c")
+//	c.Eventually(5*time.Second, "b assume", func() bool { return c.Coordinator("b") == "b" })
+//
+// As conexões entre servidores e com a referência passam por c.net
+// (faultnet_test.go), que perde, atrasa, duplica ou reordena mensagens. O
+// broker fica fora dela.
+
+// Intervalos curtos para que eleições e heartbeats aconteçam em segundos.
+const (
+	testSettle    = 200 * time.Millisecond
+	testHeartbeat = 100 * time.Millisecond // coordenador checado a cada 300ms
+	testLiveness  = time.Second            // referência esquece quem parou de mandar heartbeat
+	testStartup   = 10 * time.Second
+
+	testClusterToken = "cluster-de-teste"
+)
+
+var clusterSeq int64
+
+type cluster struct {
+	t      *testing.T
+	prefix string
+
+	ref    *fakeReference
+	broker *fakeBroker
+	net    *faultNet
+
+	mu    sync.Mutex
+	nodes map[string]*node
+	names []string
+}
+
+type node struct {
+	name string
+	cfg  Config
+	srv  *Server
+	errc chan error // resultado de run
+}
+
+// newCluster sobe referência, broker e os servidores, em paralelo como no
+// docker-compose. Os ranks seguem a ordem dos nomes (o último é o maior, e
+// portanto o coordenador inicial). Tudo é encerrado ao fim do teste.
+func newCluster(t *testing.T, names ...string) *cluster {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("cluster em processo: omitido com -short")
+	}
+
+	c := &cluster{
+		t:      t,
+		prefix: fmt.Sprintf("inproc://cluster%d", atomic.AddInt64(&clusterSeq, 1)),
+		nodes:  make(map[string]*node),
+		names:  names,
+		net:    newFaultNet(zmqTransport{}),
+	}
+	var err error
+	if c.ref, err = startFakeReference(c.endpoint("reference"), names, testLiveness); err != nil {
+		t.Fatalf("referência: %v", err)
+	}
+	if c.broker, err = startFakeBroker(c.endpoint("broker-xsub"), c.endpoint("broker-xpub")); err != nil {
+		c.ref.stop()
+		t.Fatalf("broker: %v", err)
+	}
+	t.Cleanup(c.shutdown)
+
+	for _, name := range names {
+		c.nodes[name] = &node{name: name, cfg: c.config(name)}
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, n *node) {
+			defer wg.Done()
+			errs[i] = n.start()
+		}(i, c.nodes[name])
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("servidor %s: %v", names[i], err)
+		}
+	}
+	return c
+}
+
+func (c *cluster) endpoint(name string) string {
+	return c.prefix + "-" + name
+}
+
+// config monta a Config de um nó; o diretório de dados sobrevive a Kill e
+// Restart (é removido só no fim do teste).
+func (c *cluster) config(name string) Config {
+	return Config{
+		Name:         name,
+		Bind:         c.endpoint(name),
+		DataDir:      c.t.TempDir(),
+		ReferenceURL: c.endpoint("reference"),
+		AnnounceURL:  c.endpoint("announce"), // a referência não publica anúncios
+		BrokerURL:    c.endpoint("broker-xsub"),
+		BrokerSubURL: c.endpoint("broker-xpub"),
+		HTTPAddr:     "off",
+		GRPCAddr:     "off",
+		ClusterToken: testClusterToken,
+		PeerURL:      c.endpoint,
+		Transport:    c.net.transport(),
+		Settle:       testSettle,
+		Heartbeat:    testHeartbeat,
+		SyncInterval: time.Hour,
+	}
+}
+
+func (n *node) start() error {
+	n.srv = newServer(n.cfg)
+	n.errc = make(chan error, 1)
+	srv := n.srv
+	go func() { n.errc <- srv.run() }()
+
+	select {
+	case <-srv.ready:
+		return nil
+	case err := <-n.errc:
+		return fmt.Errorf("run retornou antes de ficar pronto: %v", err)
+	case <-time.After(testStartup):
+		return fmt.Errorf("não ficou pronto em %s", testStartup)
+	}
+}
+
+func (n *node) alive() bool {
+	return n.srv != nil
+}
+
+func (c *cluster) node(name string) *node {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.nodes[name]
+	if !ok {
+		c.t.Fatalf("servidor desconhecido: %s", name)
+	}
+	return n
+}
+
+// Server devolve a instância atual do nó (nil se estiver derrubado).
+func (c *cluster) Server(name string) *Server {
+	c.t.Helper()
+	return c.node(name).srv
+}
+
+// Kill derruba o nó. Os dados ficam no disco para um Restart.
+func (c *cluster) Kill(name string) {
+	c.t.Helper()
+	n := c.node(name)
+	if !n.alive() {
+		c.t.Fatalf("%s já está fora do ar", name)
+	}
+	n.srv.Stop()
+	if err := <-n.errc; err != nil {
+		c.t.Logf("%s encerrou com erro: %v", name, err)
+	}
+	n.srv = nil
+	c.t.Logf("💥 %s derrubado", name)
+}
+
+// Restart sobe de novo um nó derrubado, com o mesmo nome e diretório de dados.
+func (c *cluster) Restart(name string) {
+	c.t.Helper()
+	n := c.node(name)
+	if n.alive() {
+		c.t.Fatalf("%s ainda está no ar", name)
+	}
+	if err := n.start(); err != nil {
+		c.t.Fatalf("reinício de %s: %v", name, err)
+	}
+	c.t.Logf("🔁 %s reiniciado", name)
+}
+
+// Partition separa os grupos: nós de grupos diferentes deixam de se falar.
+// Nós fora de todos os grupos mantêm a comunicação com todos; a referência
+// entra na partição se for listada como referencePeer.
+func (c *cluster) Partition(groups ...[]string) {
+	c.net.Add(partition(groups...))
+	c.t.Logf("✂️  partição: %v", groups)
+}
+
+// Heal desfaz as partições e as demais falhas da rede.
+func (c *cluster) Heal() {
+	c.net.Clear()
+	c.t.Logf("🩹 rede restaurada")
+}
+
+// Coordinator é o coordenador que o nó conhece ("" se está fora do ar).
+func (c *cluster) Coordinator(name string) string {
+	if srv := c.Server(name); srv != nil {
+		return srv.coordinator()
+	}
+	return ""
+}
+
+// Client abre um cliente do SDK ligado só ao nó (sem failover).
+func (c *cluster) Client(name string) *client.Client {
+	c.t.Helper()
+	cl, err := client.New(client.Config{
+		Endpoints: []string{c.endpoint(name)},
+		BrokerURL: c.endpoint("broker-xpub"),
+		Timeout:   2 * time.Second,
+		Retries:   -1,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+// Eventually espera cond ficar verdadeira, conferindo a cada 50ms.
+func (c *cluster) Eventually(timeout time.Duration, what string, cond func() bool) {
+	c.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("após %s: %s", timeout, what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Consistently confere cond durante todo o intervalo.
+func (c *cluster) Consistently(d time.Duration, what string, cond func() bool) {
+	c.t.Helper()
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if !cond() {
+			c.t.Fatalf("deixou de valer: %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (c *cluster) shutdown() {
+	for _, name := range c.names {
+		if n := c.nodes[name]; n.alive() {
+			n.srv.Stop()
+			<-n.errc
+			n.srv = nil
+		}
+	}
+	c.broker.stop()
+	c.ref.stop()
+}
+
+// ----------------------------
+// Servidor de referência
+// ----------------------------
+
+// fakeReference reproduz reference/main.py: rank, list e heartbeat, com os
+// demais serviços respondidos com erro. Os ranks dos nomes conhecidos são
+// fixados na criação, como um registro já persistido.
+type fakeReference struct {
+	sock     *zmq.Socket
+	liveness time.Duration
+
+	mu       sync.Mutex
+	ranks    map[string]int
+	lastBeat map[string]time.Time
+	nextRank int
+	clock    int64
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func startFakeReference(endpoint string, names []string, liveness time.Duration) (*fakeReference, error) {
+	sock, err := zmq.NewSocket(zmq.REP)
+	if err != nil {
+		return nil, err
+	}
+	sock.SetLinger(0)
+	if err := sock.Bind(endpoint); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	r := &fakeReference{
+		sock:     sock,
+		liveness: liveness,
+		ranks:    make(map[string]int),
+		lastBeat: make(map[string]time.Time),
+		nextRank: 1,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, name := range names {
+		r.ranks[name] = r.nextRank
+		r.nextRank++
+	}
+	go r.serve()
+	return r, nil
+}
+
+func (r *fakeReference) serve() {
+	defer close(r.stopped)
+	defer r.sock.Close()
+
+	poller := zmq.NewPoller()
+	poller.Add(r.sock, zmq.POLLIN)
+	for {
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+		if polled, err := poller.Poll(pollInterval); err != nil || len(polled) == 0 {
+			continue
+		}
+		msg, err := r.sock.RecvBytes(0)
+		if err != nil {
+			continue
+		}
+		reply, _ := msgpack.Marshal(r.handle(msg))
+		r.sock.SendBytes(reply, 0)
+	}
+}
+
+func (r *fakeReference) handle(msg []byte) map[string]interface{} {
+	var req struct {
+		Service string `msgpack:"service"`
+		Data    struct {
+			User  string `msgpack:"user"`
+			Clock int64  `msgpack:"clock"`
+		} `msgpack:"data"`
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := map[string]interface{}{"timestamp": time.Now().Unix()}
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		data["error"] = err.Error()
+		data["clock"] = r.tick(0)
+		return map[string]interface{}{"service": req.Service, "data": data}
+	}
+	data["clock"] = r.tick(req.Data.Clock)
+
+	switch req.Service {
+	case "rank":
+		rank, ok := r.ranks[req.Data.User]
+		if !ok {
+			rank = r.nextRank
+			r.ranks[req.Data.User] = rank
+			r.nextRank++
+		}
+		r.lastBeat[req.Data.User] = time.Now()
+		data["rank"] = rank
+	case "heartbeat":
+		if _, ok := r.ranks[req.Data.User]; ok {
+			r.lastBeat[req.Data.User] = time.Now()
+		}
+		data["status"] = "OK"
+	case "list":
+		list := []map[string]interface{}{}
+		for name, at := range r.lastBeat {
+			if time.Since(at) < r.liveness {
+				list = append(list, map[string]interface{}{"name": name, "rank": r.ranks[name]})
+			}
+		}
+		data["list"] = list
+	default:
+		data["error"] = "Serviço desconhecido: " + req.Service
+	}
+	return map[string]interface{}{"service": req.Service, "data": data}
+}
+
+func (r *fakeReference) tick(received int64) int64 {
+	if received > r.clock {
+		r.clock = received
+	}
+	r.clock++
+	return r.clock
+}
+
+func (r *fakeReference) stop() {
+	close(r.done)
+	<-r.stopped
+}
+
+// ----------------------------
+// Broker
+// ----------------------------
+
+// fakeBroker é o proxy XSUB/XPUB do broker, com parada (zmq.Proxy não para).
+type fakeBroker struct {
+	xsub, xpub *zmq.Socket
+	done       chan struct{}
+	stopped    chan struct{}
+}
+
+func startFakeBroker(xsubURL, xpubURL string) (*fakeBroker, error) {
+	b := &fakeBroker{done: make(chan struct{}), stopped: make(chan struct{})}
+	var err error
+	if b.xsub, err = zmq.NewSocket(zmq.XSUB); err != nil {
+		return nil, err
+	}
+	if b.xpub, err = zmq.NewSocket(zmq.XPUB); err != nil {
+		b.xsub.Close()
+		return nil, err
+	}
+	b.xsub.SetLinger(0)
+	b.xpub.SetLinger(0)
+	if err = b.xsub.Bind(xsubURL); err == nil {
+		err = b.xpub.Bind(xpubURL)
+	}
+	if err != nil {
+		b.xsub.Close()
+		b.xpub.Close()
+		return nil, err
+	}
+	go b.serve()
+	return b, nil
+}
+
+func (b *fakeBroker) serve() {
+	defer close(b.stopped)
+	defer b.xsub.Close()
+	defer b.xpub.Close()
+
+	poller := zmq.NewPoller()
+	poller.Add(b.xsub, zmq.POLLIN)
+	poller.Add(b.xpub, zmq.POLLIN)
+	for {
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		polled, err := poller.Poll(pollInterval)
+		if err != nil {
+			continue
+		}
+		for _, p := range polled {
+			// Publicações seguem para os assinantes; assinaturas, no sentido inverso
+			from, to := b.xsub, b.xpub
+			if p.Socket == b.xpub {
+				from, to = b.xpub, b.xsub
+			}
+			if frames, err := from.RecvMessageBytes(0); err == nil {
+				to.SendMessage(frames)
+			}
+		}
+	}
+}
+
+func (b *fakeBroker) stop() {
+	close(b.done)
+	<-b.stopped
+}
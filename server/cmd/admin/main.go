@@ -0,0 +1,323 @@
+// Comando admin administra o cluster pelos serviços "admin_*" dos servidores.
+// As requisições são assinadas com ADMIN_TOKEN (o mesmo configurado nos
+// servidores); os servidores são descobertos pelo servidor de referência ou
+// informados em -servers.
+//
+//	admin servers                             estado de cada servidor
+//	admin users | channels                    usuários e canais cadastrados
+//	admin -server server2 election            inicia uma eleição em server2
+//	admin -server server1 sync                sincronização Berkeley a partir de server1
+//	admin -server server3 resync server1      server3 copia de server1 o que falta
+//	admin -server server1 dump [arquivo]      salva os dados (stdout sem arquivo)
+//	admin -server server1 restore [-merge] arquivo
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/client"
+	"server/protocol"
+)
+
+var (
+	token     = flag.String("token", os.Getenv("ADMIN_TOKEN"), "token administrativo (ADMIN_TOKEN dos servidores)")
+	reference = flag.String("reference", envOr("REFERENCE_URL", "tcp://localhost:5559"), "servidor de referência, para descobrir os servidores")
+	servers   = flag.String("servers", os.Getenv("SERVER_URL"), "servidores separados por vírgula (nomes ou URLs); vazio = lista da referência")
+	target    = flag.String("server", "", "servidor alvo de election, sync, resync, dump e restore")
+	timeout   = flag.Duration("timeout", 10*time.Second, "tempo limite por requisição")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "uso: admin [opções] servers|users|channels|election|sync|resync <origem>|dump [arquivo]|restore [-merge] <arquivo>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *token == "" {
+		log.Fatal("❌ Informe -token ou ADMIN_TOKEN")
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch cmd {
+	case "servers":
+		err = listServers()
+	case "users":
+		err = listUsers()
+	case "channels":
+		err = listChannels()
+	case "election":
+		err = onTarget(func(ctx context.Context, c *client.Client) error {
+			return c.TriggerElection(ctx, *token)
+		})
+	case "sync":
+		err = onTarget(func(ctx context.Context, c *client.Client) error {
+			return c.TriggerSync(ctx, *token)
+		})
+	case "resync":
+		if len(args) != 1 {
+			log.Fatal("❌ uso: admin -server <destino> resync <origem>")
+		}
+		err = onTarget(func(ctx context.Context, c *client.Client) error {
+			return c.Resync(ctx, *token, args[0])
+		})
+	case "dump":
+		err = dump(args)
+	case "restore":
+		err = restore(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("❌ %s: %v", cmd, err)
+	}
+}
+
+// serverURL aceita um nome de servidor ("server1") ou uma URL completa.
+func serverURL(s string) string {
+	if strings.Contains(s, "://") {
+		return s
+	}
+	return fmt.Sprintf("tcp://%s:5555", s)
+}
+
+// discover devolve as URLs dos servidores: as de -servers ou as registradas
+// no servidor de referência.
+func discover() ([]string, error) {
+	var urls []string
+	if *servers != "" {
+		for _, s := range strings.Split(*servers, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				urls = append(urls, serverURL(s))
+			}
+		}
+		return urls, nil
+	}
+
+	sock, err := zmq.NewSocket(zmq.REQ)
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+	sock.SetLinger(0)
+	sock.SetRcvtimeo(*timeout)
+	if err := sock.Connect(*reference); err != nil {
+		return nil, err
+	}
+
+	req := map[string]interface{}{
+		"service": "list",
+		"data":    map[string]interface{}{"timestamp": time.Now().Unix(), "clock": 0},
+	}
+	payload, _ := msgpack.Marshal(req)
+	if _, err := sock.SendBytes(payload, 0); err != nil {
+		return nil, err
+	}
+	reply, err := sock.RecvBytes(0)
+	if err != nil {
+		return nil, fmt.Errorf("referência %s não respondeu: %v", *reference, err)
+	}
+	var resp struct {
+		Data struct {
+			List []struct {
+				Name string `msgpack:"name"`
+			} `msgpack:"list"`
+		} `msgpack:"data"`
+	}
+	if err := msgpack.Unmarshal(reply, &resp); err != nil {
+		return nil, err
+	}
+	for _, s := range resp.Data.List {
+		urls = append(urls, serverURL(s.Name))
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("nenhum servidor registrado na referência")
+	}
+	return urls, nil
+}
+
+func newClient(endpoints ...string) (*client.Client, error) {
+	return client.New(client.Config{Endpoints: endpoints, Timeout: *timeout, Retries: -1})
+}
+
+// onTarget executa fn no servidor de -server.
+func onTarget(fn func(ctx context.Context, c *client.Client) error) error {
+	if *target == "" {
+		return fmt.Errorf("informe o servidor alvo com -server")
+	}
+	c, err := newClient(serverURL(*target))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := fn(ctx, c); err != nil {
+		return err
+	}
+	log.Printf("✅ %s: %s", *target, flag.Arg(0))
+	return nil
+}
+
+func listServers() error {
+	urls, err := discover()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVIDOR\tRANK\tCOORDENADOR\tRELÓGIO\tHORA\tOFFSET\tÚLTIMA SYNC\tUSUÁRIOS\tCANAIS\tMENSAGENS\tRESSINCRONIZAÇÃO")
+	for _, url := range urls {
+		st, err := status(url)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t-\t-\t-\t%v\n", url, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%+ds\t%s\t%d\t%d\t%d\t%s\n",
+			st.Server, st.Rank, st.Coordinator, st.Clock, formatTime(st.Time), st.Offset,
+			formatTime(st.LastSync), st.Users, st.Channels, st.ChannelMessages+st.UserMessages,
+			formatResync(st.LastResync))
+	}
+	return w.Flush()
+}
+
+func status(url string) (*protocol.AdminStatus, error) {
+	c, err := newClient(url)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return c.AdminStatus(ctx, *token)
+}
+
+func formatTime(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Unix(unix, 0).Format("15:04:05")
+}
+
+func formatResync(r *protocol.ResyncResult) string {
+	switch {
+	case r == nil:
+		return "-"
+	case r.Finished == 0:
+		return fmt.Sprintf("de %s em andamento", r.Peer)
+	case r.Error != "":
+		return fmt.Sprintf("de %s falhou: %s", r.Peer, r.Error)
+	default:
+		return fmt.Sprintf("de %s: %d itens às %s", r.Peer, r.Added, formatTime(r.Finished))
+	}
+}
+
+// cluster abre um cliente comum (com failover) para as consultas de leitura.
+func cluster() (*client.Client, error) {
+	urls, err := discover()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(client.Config{Endpoints: urls, Timeout: *timeout})
+}
+
+func listUsers() error {
+	c, err := cluster()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	users, err := c.Users(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		fmt.Println(u)
+	}
+	return nil
+}
+
+func listChannels() error {
+	c, err := cluster()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	channels, err := c.Channels(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CANAL\tDONO\tMENSAGENS\tÚLTIMA ATIVIDADE\tARQUIVADO\tTÓPICO")
+	for _, ch := range channels {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%v\t%s\n",
+			ch.Name, ch.Owner, ch.MessageCount, formatTime(ch.LastActivity), ch.Archived, ch.Topic)
+	}
+	return w.Flush()
+}
+
+func dump(args []string) error {
+	var out io.Writer = os.Stdout
+	if len(args) > 0 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return onTarget(func(ctx context.Context, c *client.Client) error {
+		data, err := c.Dump(ctx, *token)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	})
+}
+
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	merge := fs.Bool("merge", false, "só acrescenta o que falta, sem apagar os dados atuais")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("uso: admin -server <servidor> restore [-merge] <arquivo>")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return onTarget(func(ctx context.Context, c *client.Client) error {
+		added, err := c.Restore(ctx, *token, data, *merge)
+		if err == nil && *merge {
+			log.Printf("📥 %d itens acrescentados", added)
+		}
+		return err
+	})
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,82 @@
+// Comando chat é o cliente interativo de terminal em Go. Usa o SDK
+// (server/client): requisições pelo socket ZeroMQ dos servidores e eventos
+// ao vivo pela assinatura do broker.
+//
+//	go run ./cmd/chat -user alice -join geral
+//
+// Comandos: /join <canal>, /leave, /msg <usuário> <texto>, /who, /list,
+// /help e /quit. Texto sem barra vai para a conversa aberta.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"server/client"
+	"server/protocol"
+)
+
+func main() {
+	servers := flag.String("servers", envOr("SERVER_URL", "tcp://localhost:5555"), "servidores separados por vírgula (failover na ordem)")
+	broker := flag.String("broker", envOr("BROKER_URL", "tcp://localhost:5558"), "XPUB do broker")
+	user := flag.String("user", os.Getenv("CHAT_USER"), "nome de usuário")
+	join := flag.String("join", "", "canais para entrar ao iniciar, separados por vírgula")
+	flag.Parse()
+
+	if *user == "" {
+		fmt.Print("👤 Usuário: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		*user = strings.TrimSpace(line)
+	}
+	if *user == "" {
+		log.Fatal("❌ Usuário não informado")
+	}
+
+	c, err := client.New(client.Config{
+		Endpoints: strings.Split(*servers, ","),
+		BrokerURL: *broker,
+	})
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	err = c.Login(ctx, *user)
+	cancel()
+	// Usuário já cadastrado é só uma nova sessão
+	var serr *client.Error
+	if err != nil && !(errors.As(err, &serr) && serr.Code == protocol.ErrUserExists) {
+		log.Fatalf("❌ Login falhou: %v", err)
+	}
+
+	sub, err := c.Subscribe(*user)
+	if err != nil {
+		log.Fatalf("❌ Assinatura do broker falhou: %v", err)
+	}
+	defer sub.Close()
+
+	ui := newChatUI(c, sub, *user)
+	for _, ch := range strings.Split(*join, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			ui.joinChannel(ch)
+		}
+	}
+	if err := ui.run(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
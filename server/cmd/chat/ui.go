@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"server/client"
+	"server/protocol"
+	"server/topics"
+)
+
+const (
+	historyPage    = 50
+	requestTimeout = 10 * time.Second
+	systemTarget   = "*"
+)
+
+// conversation guarda as linhas de um canal ("#geral"), de uma conversa
+// direta ("@bob") ou do painel do sistema ("*").
+type conversation struct {
+	lines    []string
+	seen     map[string]bool // IDs já exibidos (histórico e ao vivo)
+	oldest   string          // ID da mensagem mais antiga carregada (cursor da página anterior)
+	loaded   bool            // a primeira página do histórico já chegou
+	complete bool            // não há histórico mais antigo
+	unread   int
+	joined   bool
+}
+
+// chatUI só altera seu estado na goroutine do tview; chamadas de rede rodam
+// em goroutines e voltam por app.QueueUpdateDraw.
+type chatUI struct {
+	c    *client.Client
+	sub  *client.Subscription
+	user string
+
+	app    *tview.Application
+	list   *tview.List
+	view   *tview.TextView
+	input  *tview.InputField
+	status *tview.TextView
+
+	convs    map[string]*conversation
+	channels []string // canais existentes no servidor
+	current  string
+	follow   bool // acompanhar o fim da conversa aberta
+	loading  bool
+}
+
+func newChatUI(c *client.Client, sub *client.Subscription, user string) *chatUI {
+	ui := &chatUI{
+		c:       c,
+		sub:     sub,
+		user:    user,
+		app:     tview.NewApplication(),
+		convs:   map[string]*conversation{systemTarget: {seen: map[string]bool{}, complete: true}},
+		current: systemTarget,
+		follow:  true,
+	}
+
+	ui.list = tview.NewList().ShowSecondaryText(false)
+	ui.list.SetBorder(true).SetTitle(" Canais ")
+	ui.list.SetSelectedFunc(func(_ int, main, _ string, _ rune) {
+		ui.open(strings.Fields(main)[0])
+		ui.app.SetFocus(ui.input)
+	})
+
+	ui.view = tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWordWrap(true)
+	ui.view.SetBorder(true)
+	ui.view.SetInputCapture(ui.scrollKeys)
+
+	ui.input = tview.NewInputField().SetLabel("> ")
+	ui.input.SetDoneFunc(func(key tcell.Key) {
+		if key != tcell.KeyEnter {
+			return
+		}
+		line := strings.TrimSpace(ui.input.GetText())
+		ui.input.SetText("")
+		if line != "" {
+			ui.handleInput(line)
+		}
+	})
+	ui.input.SetInputCapture(ui.scrollKeys)
+
+	ui.status = tview.NewTextView().SetDynamicColors(true)
+
+	ui.system("Bem-vindo, [yellow]%s[-]! Digite /help para ver os comandos.", tview.Escape(user))
+	ui.refreshChannels()
+	return ui
+}
+
+func (ui *chatUI) run() error {
+	right := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ui.view, 0, 1, false).
+		AddItem(ui.input, 1, 0, true).
+		AddItem(ui.status, 1, 0, false)
+	root := tview.NewFlex().
+		AddItem(ui.list, 24, 0, false).
+		AddItem(right, 0, 1, true)
+
+	ui.app.SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		if ev.Key() == tcell.KeyTab {
+			if ui.input.HasFocus() {
+				ui.app.SetFocus(ui.list)
+			} else {
+				ui.app.SetFocus(ui.input)
+			}
+			return nil
+		}
+		return ev
+	})
+
+	go ui.receive()
+	go ui.loadPending()
+	go func() {
+		for range time.Tick(time.Second) {
+			ui.app.QueueUpdateDraw(ui.renderStatus)
+		}
+	}()
+
+	ui.render()
+	return ui.app.SetRoot(root, true).EnableMouse(true).Run()
+}
+
+// receive repassa os eventos do broker para a interface.
+func (ui *chatUI) receive() {
+	for ev := range ui.sub.C {
+		ev := ev
+		ui.app.QueueUpdateDraw(func() { ui.handleEvent(ev) })
+	}
+}
+
+// loadPending mostra as mensagens diretas que chegaram com o usuário
+// offline; o servidor já as confirma como entregues ao devolvê-las.
+func (ui *chatUI) loadPending() {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	msgs, err := ui.c.Pending(ctx, ui.user)
+	ui.app.QueueUpdateDraw(func() {
+		if err != nil {
+			ui.fail(err)
+			return
+		}
+		for _, m := range msgs {
+			target := "@" + m.From
+			if cv := ui.conv(target); !cv.seen[m.ID] {
+				cv.seen[m.ID] = true
+				ui.appendLine(target, formatMessage(m.Timestamp, m.From, m.Message, false))
+			}
+		}
+		if len(msgs) > 0 {
+			ui.system("%d mensagens diretas recebidas enquanto você estava offline", len(msgs))
+		}
+	})
+}
+
+// confirm avisa o servidor que a mensagem direta foi entregue.
+func (ui *chatUI) confirm(id string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		if err := ui.c.Receipt(ctx, ui.user, id, client.ReceiptDelivered); err != nil {
+			ui.app.QueueUpdateDraw(func() { ui.fail(err) })
+		}
+	}()
+}
+
+func (ui *chatUI) conv(target string) *conversation {
+	cv, ok := ui.convs[target]
+	if !ok {
+		cv = &conversation{seen: map[string]bool{}}
+		ui.convs[target] = cv
+	}
+	return cv
+}
+
+// appendLine acrescenta uma linha na conversa e atualiza a tela se ela
+// estiver aberta.
+func (ui *chatUI) appendLine(target, line string) {
+	cv := ui.conv(target)
+	cv.lines = append(cv.lines, line)
+	if target == ui.current {
+		ui.view.Write([]byte(line + "\n"))
+		if ui.follow {
+			ui.view.ScrollToEnd()
+		}
+	} else {
+		cv.unread++
+		ui.renderList()
+	}
+}
+
+func (ui *chatUI) system(format string, args ...interface{}) {
+	ui.appendLine(systemTarget, fmt.Sprintf("[gray]%s[-] %s", time.Now().Format("15:04"), fmt.Sprintf(format, args...)))
+}
+
+// notice mostra uma mensagem do sistema na conversa aberta.
+func (ui *chatUI) notice(format string, args ...interface{}) {
+	ui.appendLine(ui.current, fmt.Sprintf("[gray]%s *[-] %s", time.Now().Format("15:04"), fmt.Sprintf(format, args...)))
+}
+
+func (ui *chatUI) fail(err error) {
+	var serr *client.Error
+	if errors.As(err, &serr) {
+		ui.notice("[red]%s[-] (%s)", tview.Escape(serr.Message), serr.Code)
+		return
+	}
+	ui.notice("[red]%s[-]", tview.Escape(err.Error()))
+}
+
+func formatMessage(ts int64, user, message string, deleted bool) string {
+	when := time.Unix(ts, 0).Format("15:04")
+	if deleted {
+		return fmt.Sprintf("[gray]%s [::d]%s: (mensagem removida)[::-][-]", when, tview.Escape(user))
+	}
+	return fmt.Sprintf("[gray]%s[-] [yellow]%s[-]: %s", when, tview.Escape(user), tview.Escape(message))
+}
+
+func (ui *chatUI) handleEvent(ev client.Event) {
+	target := "#" + ev.Name
+	if ev.Kind == topics.KindUser {
+		if ev.Event == "receipt" {
+			return
+		}
+		target = "@" + ev.User
+	}
+
+	cv := ui.conv(target)
+	switch ev.Event {
+	case "":
+		if cv.seen[ev.ID] {
+			return
+		}
+		cv.seen[ev.ID] = true
+		if ev.Kind == topics.KindUser {
+			ui.confirm(ev.ID)
+		}
+		prefix := ""
+		if ev.ParentID != "" {
+			prefix = "[blue]↳[-] "
+		}
+		ui.appendLine(target, prefix+formatMessage(ev.Timestamp, ev.User, ev.Message, false))
+	case "edit":
+		ui.appendLine(target, fmt.Sprintf("[gray]✏️  %s editou: %s[-]", tview.Escape(ev.User), tview.Escape(ev.Message)))
+	case "delete":
+		ui.appendLine(target, fmt.Sprintf("[gray]🗑️  %s removeu uma mensagem[-]", tview.Escape(ev.User)))
+	case "react":
+		ui.appendLine(target, fmt.Sprintf("[gray]%s reagiu com %s[-]", tview.Escape(ev.User), ev.Emoji))
+	}
+}
+
+func (ui *chatUI) handleInput(line string) {
+	ui.follow = true
+	if !strings.HasPrefix(line, "/") {
+		ui.say(line)
+		return
+	}
+
+	cmd, rest, _ := strings.Cut(line[1:], " ")
+	rest = strings.TrimSpace(rest)
+	switch strings.ToLower(cmd) {
+	case "join", "j":
+		if rest == "" {
+			ui.notice("Uso: /join <canal>")
+			return
+		}
+		ui.joinChannel(strings.TrimPrefix(rest, "#"))
+	case "leave", "part":
+		ui.leaveChannel(strings.TrimPrefix(rest, "#"))
+	case "msg", "m":
+		to, text, _ := strings.Cut(rest, " ")
+		to = strings.TrimPrefix(to, "@")
+		if to == "" {
+			ui.notice("Uso: /msg <usuário> [texto]")
+			return
+		}
+		ui.open("@" + to)
+		if text = strings.TrimSpace(text); text != "" {
+			ui.say(text)
+		}
+	case "who", "users":
+		ui.who()
+	case "list", "channels":
+		ui.refreshChannels()
+		ui.listChannels()
+	case "help", "h":
+		ui.help()
+	case "quit", "exit", "q":
+		ui.app.Stop()
+	default:
+		ui.notice("Comando desconhecido: /%s (veja /help)", tview.Escape(cmd))
+	}
+}
+
+func (ui *chatUI) help() {
+	for _, line := range []string{
+		"/join <canal>        entra no canal (cria se não existir)",
+		"/leave [canal]       sai do canal",
+		"/msg <usuário> [txt] abre a conversa direta e envia o texto",
+		"/who                 lista os usuários",
+		"/list                lista os canais",
+		"/quit                sai",
+		"Tab alterna entre a lista e a digitação; PgUp/PgDn rolam e carregam o histórico; End volta ao fim.",
+	} {
+		ui.notice("%s", tview.Escape(line))
+	}
+}
+
+// say envia o texto para a conversa aberta.
+func (ui *chatUI) say(text string) {
+	target := ui.current
+	switch {
+	case strings.HasPrefix(target, "#"):
+		channel := target[1:]
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			defer cancel()
+			// A mensagem aparece quando voltar pelo broker
+			if _, err := ui.c.Publish(ctx, ui.user, channel, text); err != nil {
+				ui.app.QueueUpdateDraw(func() { ui.fail(err) })
+			}
+		}()
+	case strings.HasPrefix(target, "@"):
+		to := target[1:]
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			defer cancel()
+			id, err := ui.c.SendMessage(ctx, ui.user, to, text)
+			ui.app.QueueUpdateDraw(func() {
+				if err != nil {
+					ui.fail(err)
+					return
+				}
+				// Mensagens diretas só voltam para o destinatário
+				ui.conv(target).seen[id] = true
+				ui.appendLine(target, formatMessage(time.Now().Unix(), ui.user, text, false))
+			})
+		}()
+	default:
+		ui.notice("Abra um canal (/join) ou uma conversa (/msg) para enviar mensagens.")
+	}
+}
+
+func (ui *chatUI) joinChannel(channel string) {
+	target := "#" + channel
+	if cv := ui.conv(target); cv.joined {
+		ui.open(target)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		err := ui.c.CreateChannel(ctx, channel, client.ChannelOptions{Owner: ui.user})
+		var serr *client.Error
+		if err != nil && !(errors.As(err, &serr) && serr.Code == protocol.ErrChannelExists) {
+			ui.app.QueueUpdateDraw(func() { ui.fail(err) })
+			return
+		}
+		ui.sub.Join(channel)
+		ui.app.QueueUpdateDraw(func() {
+			ui.conv(target).joined = true
+			ui.open(target)
+			ui.refreshChannels()
+		})
+	}()
+}
+
+func (ui *chatUI) leaveChannel(channel string) {
+	if channel == "" && strings.HasPrefix(ui.current, "#") {
+		channel = ui.current[1:]
+	}
+	cv, ok := ui.convs["#"+channel]
+	if channel == "" || !ok || !cv.joined {
+		ui.notice("Você não está nesse canal.")
+		return
+	}
+	ui.sub.Leave(channel)
+	cv.joined = false
+	ui.system("Saiu de #%s", tview.Escape(channel))
+	ui.open(systemTarget)
+}
+
+func (ui *chatUI) who() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		users, err := ui.c.Users(ctx)
+		ui.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ui.fail(err)
+				return
+			}
+			sort.Strings(users)
+			ui.notice("Usuários (%d): %s", len(users), tview.Escape(strings.Join(users, ", ")))
+		})
+	}()
+}
+
+func (ui *chatUI) listChannels() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		infos, err := ui.c.Channels(ctx)
+		ui.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ui.fail(err)
+				return
+			}
+			for _, info := range infos {
+				ui.notice("#%s (%d mensagens) %s", tview.Escape(info.Name), info.MessageCount, tview.Escape(info.Topic))
+			}
+		})
+	}()
+}
+
+// refreshChannels busca os canais do servidor para o painel lateral.
+func (ui *chatUI) refreshChannels() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		infos, err := ui.c.Channels(ctx)
+		if err != nil {
+			return
+		}
+		ui.app.QueueUpdateDraw(func() {
+			ui.channels = ui.channels[:0]
+			for _, info := range infos {
+				ui.channels = append(ui.channels, info.Name)
+			}
+			ui.renderList()
+		})
+	}()
+}
+
+// open mostra uma conversa. Canais não assinados são assinados antes.
+func (ui *chatUI) open(target string) {
+	if strings.HasPrefix(target, "#") && !ui.conv(target).joined {
+		ui.joinChannel(target[1:])
+		return
+	}
+	ui.current = target
+	ui.follow = true
+	cv := ui.conv(target)
+	cv.unread = 0
+	ui.render()
+	// Mensagens ao vivo podem chegar antes da primeira página; por isso a
+	// flag, e não len(cv.lines)
+	if !cv.loaded {
+		ui.loadHistory(target)
+	}
+}
+
+// loadHistory carrega a página anterior à mensagem mais antiga já exibida.
+func (ui *chatUI) loadHistory(target string) {
+	cv := ui.conv(target)
+	if ui.loading || cv.complete {
+		return
+	}
+	ui.loading = true
+	before := cv.oldest
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		var msgs []client.HistoryMessage
+		var err error
+		if strings.HasPrefix(target, "@") {
+			msgs, err = ui.c.DirectHistory(ctx, ui.user, target[1:], historyPage, before)
+		} else {
+			msgs, err = ui.c.History(ctx, target[1:], historyPage, before)
+		}
+		ui.app.QueueUpdateDraw(func() {
+			ui.loading = false
+			if err != nil {
+				ui.fail(err)
+				return
+			}
+			ui.prependHistory(target, msgs)
+		})
+	}()
+}
+
+func (ui *chatUI) prependHistory(target string, msgs []client.HistoryMessage) {
+	cv := ui.conv(target)
+	cv.loaded = true
+	if len(msgs) < historyPage {
+		cv.complete = true
+	}
+	lines := []string{}
+	if len(msgs) > 0 {
+		cv.oldest = msgs[0].ID
+	}
+	for _, m := range msgs {
+		if cv.seen[m.ID] {
+			continue
+		}
+		cv.seen[m.ID] = true
+		line := formatMessage(m.Timestamp, m.User, m.Message, m.Deleted)
+		if m.ReplyCount > 0 {
+			line += fmt.Sprintf(" [blue](%d respostas)[-]", m.ReplyCount)
+		}
+		lines = append(lines, line)
+	}
+	if cv.complete {
+		lines = append([]string{"[gray]— início do histórico —[-]"}, lines...)
+	}
+	cv.lines = append(lines, cv.lines...)
+
+	if target == ui.current {
+		row, _ := ui.view.GetScrollOffset()
+		ui.render()
+		if !ui.follow {
+			ui.view.ScrollTo(row+len(lines), 0)
+		}
+	}
+}
+
+// scrollKeys rola a conversa; PgUp no topo carrega o histórico anterior e
+// End volta a acompanhar as mensagens novas.
+func (ui *chatUI) scrollKeys(ev *tcell.EventKey) *tcell.EventKey {
+	_, _, _, height := ui.view.GetInnerRect()
+	row, _ := ui.view.GetScrollOffset()
+	switch ev.Key() {
+	case tcell.KeyPgUp:
+		ui.follow = false
+		if row == 0 {
+			ui.loadHistory(ui.current)
+		}
+		ui.view.ScrollTo(max(row-height, 0), 0)
+		return nil
+	case tcell.KeyPgDn:
+		ui.view.ScrollTo(row+height, 0)
+		return nil
+	case tcell.KeyEnd:
+		if ev.Modifiers()&tcell.ModCtrl != 0 || ui.input.GetText() == "" {
+			ui.follow = true
+			ui.view.ScrollToEnd()
+			return nil
+		}
+	}
+	return ev
+}
+
+func (ui *chatUI) render() {
+	ui.view.SetTitle(" " + tview.Escape(ui.current) + " ")
+	ui.view.SetText(strings.Join(ui.conv(ui.current).lines, "\n") + "\n")
+	if ui.follow {
+		ui.view.ScrollToEnd()
+	}
+	ui.renderList()
+	ui.renderStatus()
+}
+
+// renderList mostra os canais do servidor (● = assinado) e as conversas
+// diretas, com o número de mensagens não lidas.
+func (ui *chatUI) renderList() {
+	targets := []string{systemTarget}
+	known := map[string]bool{}
+	for _, ch := range ui.channels {
+		targets = append(targets, "#"+ch)
+		known["#"+ch] = true
+	}
+	dms := []string{}
+	for target := range ui.convs {
+		if strings.HasPrefix(target, "#") && !known[target] {
+			targets = append(targets, target) // criado depois da última listagem
+		} else if strings.HasPrefix(target, "@") {
+			dms = append(dms, target)
+		}
+	}
+	sort.Strings(dms)
+	targets = append(targets, dms...)
+
+	ui.list.Clear()
+	for i, target := range targets {
+		label := target
+		if cv, ok := ui.convs[target]; ok {
+			if cv.joined {
+				label += " ●"
+			}
+			if cv.unread > 0 {
+				label += fmt.Sprintf(" [red](%d)[-]", cv.unread)
+			}
+		}
+		ui.list.AddItem(label, "", 0, nil)
+		if target == ui.current {
+			ui.list.SetCurrentItem(i)
+		}
+	}
+}
+
+func (ui *chatUI) renderStatus() {
+	ui.status.SetText(fmt.Sprintf("[green]%s[-] @ %s | relógio %d", tview.Escape(ui.user), ui.c.Endpoint(), ui.c.Clock()))
+}
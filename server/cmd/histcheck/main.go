@@ -0,0 +1,57 @@
+// Comando histcheck verifica offline um histórico de operações gravado pelo
+// pacote consistency (uma operação JSON por linha): publicações confirmadas
+// perdidas, mensagens duplicadas, ordem do canal divergente entre réplicas e
+// read-your-writes por sessão.
+//
+//	go test -run TestConsistency -history /tmp/historico.jsonl .
+//	go run ./cmd/histcheck /tmp/historico.jsonl
+//
+// Sem arquivo, lê da entrada padrão. Sai com código 1 se houver violações.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"server/consistency"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "uso: histcheck [arquivo ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var ops []consistency.Op
+	if flag.NArg() == 0 {
+		var err error
+		if ops, err = consistency.Load(os.Stdin); err != nil {
+			log.Fatalf("❌ entrada padrão: %v", err)
+		}
+	}
+	for _, path := range flag.Args() {
+		more, err := load(path)
+		if err != nil {
+			log.Fatalf("❌ %s: %v", path, err)
+		}
+		ops = append(ops, more...)
+	}
+
+	report := consistency.Check(ops)
+	report.Write(os.Stdout)
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func load(path string) ([]consistency.Op, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return consistency.Load(f)
+}
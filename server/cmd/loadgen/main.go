@@ -0,0 +1,250 @@
+// Comando loadgen gera carga no cluster e mede o resultado: N usuários
+// publicam em M canais e trocam mensagens diretas nas taxas pedidas, pelo
+// SDK (server/client), enquanto um observador assina tudo pelo broker.
+//
+//	go run ./cmd/loadgen -users 20 -channels 5 -rate 2 -dm-rate 0.5 -duration 30s
+//
+// Ao final relata vazão, percentis de latência (resposta do servidor e ponta
+// a ponta até o broker), mensagens perdidas, duplicadas e fora de ordem. Os
+// limites de taxa do servidor valem para a carga; para medir além deles,
+// suba os servidores com RATE_LIMITS maiores.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"server/client"
+	"server/protocol"
+)
+
+type config struct {
+	servers  []string
+	broker   string
+	users    int
+	channels int
+	rate     float64 // publicações/s por usuário
+	dmRate   float64 // mensagens diretas/s por usuário
+	duration time.Duration
+	drain    time.Duration
+	size     int
+	run      string
+}
+
+func main() {
+	var cfg config
+	servers := flag.String("servers", envOr("SERVER_URL", "tcp://localhost:5555"), "servidores separados por vírgula; os usuários são distribuídos entre eles")
+	flag.StringVar(&cfg.broker, "broker", envOr("BROKER_URL", "tcp://localhost:5558"), "XPUB do broker")
+	flag.IntVar(&cfg.users, "users", 10, "usuários simulados")
+	flag.IntVar(&cfg.channels, "channels", 3, "canais")
+	flag.Float64Var(&cfg.rate, "rate", 1, "publicações por segundo, por usuário (0 = nenhuma)")
+	flag.Float64Var(&cfg.dmRate, "dm-rate", 0.2, "mensagens diretas por segundo, por usuário (0 = nenhuma)")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "duração da fase de envio")
+	flag.DurationVar(&cfg.drain, "drain", 3*time.Second, "espera pelas últimas publicações após o envio")
+	flag.IntVar(&cfg.size, "size", 32, "bytes de enchimento em cada mensagem")
+	flag.StringVar(&cfg.run, "run", fmt.Sprintf("%x", time.Now().Unix()%0xfffff), "identificador da execução (prefixo dos nomes)")
+	flag.Parse()
+
+	cfg.servers = strings.Split(*servers, ",")
+	if cfg.users < 1 || cfg.channels < 1 {
+		log.Fatal("❌ -users e -channels devem ser ao menos 1")
+	}
+	if cfg.dmRate > 0 && cfg.users < 2 {
+		log.Fatal("❌ mensagens diretas exigem ao menos 2 usuários")
+	}
+
+	st := newStats(cfg.run)
+	if err := run(cfg, st); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+func userName(cfg config, i int) string    { return fmt.Sprintf("lg%s-u%d", cfg.run, i) }
+func channelName(cfg config, j int) string { return fmt.Sprintf("lg%s-c%d", cfg.run, j) }
+
+// newUserClient distribui os usuários entre os servidores: cada um começa
+// num servidor diferente e usa os demais como failover.
+func newUserClient(cfg config, i int) (*client.Client, error) {
+	n := len(cfg.servers)
+	endpoints := make([]string, 0, n)
+	for k := 0; k < n; k++ {
+		endpoints = append(endpoints, cfg.servers[(i+k)%n])
+	}
+	return client.New(client.Config{Endpoints: endpoints, BrokerURL: cfg.broker})
+}
+
+func run(cfg config, st *stats) error {
+	ctx := context.Background()
+
+	clients := make([]*client.Client, cfg.users)
+	for i := range clients {
+		c, err := newUserClient(cfg, i)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	log.Printf("👥 Registrando %d usuários e %d canais (execução %s)...", cfg.users, cfg.channels, cfg.run)
+	for i, c := range clients {
+		err := c.Login(ctx, userName(cfg, i))
+		var serr *client.Error
+		if err != nil && !(errors.As(err, &serr) && serr.Code == protocol.ErrUserExists) {
+			return fmt.Errorf("login de %s: %w", userName(cfg, i), err)
+		}
+	}
+	for j := 0; j < cfg.channels; j++ {
+		err := clients[0].CreateChannel(ctx, channelName(cfg, j), client.ChannelOptions{Owner: userName(cfg, 0)})
+		var serr *client.Error
+		if err != nil && !(errors.As(err, &serr) && serr.Code == protocol.ErrChannelExists) {
+			return fmt.Errorf("canal %s: %w", channelName(cfg, j), err)
+		}
+	}
+
+	// Observador: um único SUB com todos os canais e as caixas de todos os
+	// usuários, para que cada publicação chegue exatamente uma vez
+	channels := make([]string, cfg.channels)
+	for j := range channels {
+		channels[j] = channelName(cfg, j)
+	}
+	sub, err := clients[0].Subscribe(userName(cfg, 0), channels...)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	var observed sync.WaitGroup
+	observed.Add(1)
+	go func() {
+		defer observed.Done()
+		observe(cfg, st, sub)
+	}()
+	for i := 1; i < cfg.users; i++ {
+		sub.JoinUser(userName(cfg, i))
+	}
+	time.Sleep(500 * time.Millisecond) // assinaturas chegarem ao broker
+
+	log.Printf("🚀 Enviando por %s: %.2f publicações/s e %.2f diretas/s por usuário", cfg.duration, cfg.rate, cfg.dmRate)
+	deadline := time.Now().Add(cfg.duration)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			simulateUser(cfg, st, clients[i], i, deadline)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	log.Printf("⏳ Aguardando %s pelas últimas publicações...", cfg.drain)
+	time.Sleep(cfg.drain)
+	sub.Close()
+	observed.Wait()
+	st.dropped(sub.Dropped())
+
+	st.report(os.Stdout, elapsed)
+	return nil
+}
+
+func observe(cfg config, st *stats, sub *client.Subscription) {
+	for ev := range sub.C {
+		if ev.Event != "" {
+			continue
+		}
+		m, ok := parseMarker(ev.Message)
+		if !ok || m.Run != cfg.run {
+			st.unknown()
+			continue
+		}
+		st.received(m, ev.Clock, time.Now())
+	}
+}
+
+// simulateUser envia publicações e mensagens diretas até deadline. Os envios
+// de um usuário são sequenciais (um socket REQ por cliente); se a taxa pedida
+// passar do que o servidor responde, a vazão real fica abaixo dela.
+func simulateUser(cfg config, st *stats, c *client.Client, i int, deadline time.Time) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+	user := userName(cfg, i)
+	padding := strings.Repeat("x", cfg.size)
+	var seq int64
+
+	next := func(rate float64) time.Time {
+		if rate <= 0 {
+			return deadline.Add(time.Hour)
+		}
+		// Intervalos exponenciais: chegadas de Poisson na taxa pedida
+		return time.Now().Add(time.Duration(rng.ExpFloat64() / rate * float64(time.Second)))
+	}
+	nextPub, nextDM := next(cfg.rate), next(cfg.dmRate)
+
+	for {
+		dm := nextDM.Before(nextPub)
+		at := nextPub
+		if dm {
+			at = nextDM
+		}
+		if at.After(deadline) {
+			return
+		}
+		time.Sleep(time.Until(at))
+
+		seq++
+		m := marker{Run: cfg.run, Sender: user, Seq: seq, SentAt: time.Now()}
+		if dm {
+			peer := rng.Intn(cfg.users - 1)
+			if peer >= i {
+				peer++
+			}
+			m.Dest = "@" + userName(cfg, peer)
+			nextDM = next(cfg.dmRate)
+		} else {
+			m.Dest = "#" + channelName(cfg, rng.Intn(cfg.channels))
+			nextPub = next(cfg.rate)
+		}
+		send(c, st, m, dm, m.String()+" "+padding)
+	}
+}
+
+func send(c *client.Client, st *stats, m marker, dm bool, text string) {
+	st.sent(m, dm)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var err error
+	dest := m.Dest[1:]
+	if dm {
+		_, err = c.SendMessage(ctx, m.Sender, dest, text)
+	} else {
+		_, err = c.Publish(ctx, m.Sender, dest, text)
+	}
+	if err != nil {
+		code := "transporte"
+		var serr *client.Error
+		if errors.As(err, &serr) {
+			code = serr.Code
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			code = "timeout"
+		}
+		st.failed(m, code)
+		return
+	}
+	st.acked(m, time.Now())
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
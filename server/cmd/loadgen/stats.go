@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cada mensagem gerada carrega no texto a própria identificação:
+//
+//	lg/<execução>/<remetente>/<destino>/<seq>/<envio em ns> <enchimento>
+//
+// destino é "#canal" ou "@usuário"; seq é crescente por remetente. Assim o
+// observador casa a publicação recebida do broker com o envio, mesmo que ela
+// chegue antes da resposta do servidor.
+const markerPrefix = "lg/"
+
+type marker struct {
+	Run    string
+	Sender string
+	Dest   string
+	Seq    int64
+	SentAt time.Time
+}
+
+func (m marker) String() string {
+	return fmt.Sprintf("%s%s/%s/%s/%d/%d", markerPrefix, m.Run, m.Sender, m.Dest, m.Seq, m.SentAt.UnixNano())
+}
+
+func (m marker) key() string {
+	return m.Sender + "/" + strconv.FormatInt(m.Seq, 10)
+}
+
+func parseMarker(message string) (marker, bool) {
+	head, _, _ := strings.Cut(message, " ")
+	if !strings.HasPrefix(head, markerPrefix) {
+		return marker{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(head, markerPrefix), "/")
+	if len(parts) != 5 {
+		return marker{}, false
+	}
+	seq, err1 := strconv.ParseInt(parts[3], 10, 64)
+	nanos, err2 := strconv.ParseInt(parts[4], 10, 64)
+	if err1 != nil || err2 != nil {
+		return marker{}, false
+	}
+	return marker{Run: parts[0], Sender: parts[1], Dest: parts[2], Seq: seq, SentAt: time.Unix(0, nanos)}, true
+}
+
+// record acompanha uma mensagem enviada.
+type record struct {
+	marker
+	acked    bool
+	received int   // vezes em que chegou pelo broker
+	clock    int64 // relógio lógico da publicação recebida
+}
+
+// stats reúne envios, respostas e recebimentos. É usado por todas as
+// goroutines de usuário e pelo observador.
+type stats struct {
+	mu sync.Mutex
+
+	run      string
+	records  map[string]*record
+	errors   map[string]int // código de erro -> ocorrências
+	ackLat   []time.Duration
+	e2eLat   []time.Duration
+	lastSeq  map[string]int64 // remetente/destino -> maior seq recebido
+	outOrder int              // recebidas com seq menor que uma já recebida no mesmo fluxo
+	foreign  int              // recebidas sem envio correspondente nesta execução
+	discard  int64            // descartadas pelo SDK com o canal de eventos cheio
+	sentPub  int
+	sentDM   int
+}
+
+func newStats(run string) *stats {
+	return &stats{
+		run:     run,
+		records: make(map[string]*record),
+		errors:  make(map[string]int),
+		lastSeq: make(map[string]int64),
+	}
+}
+
+func (s *stats) sent(m marker, dm bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[m.key()]; !ok {
+		s.records[m.key()] = &record{marker: m}
+	}
+	if dm {
+		s.sentDM++
+	} else {
+		s.sentPub++
+	}
+}
+
+func (s *stats) acked(m marker, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[m.key()].acked = true
+	s.ackLat = append(s.ackLat, at.Sub(m.SentAt))
+}
+
+func (s *stats) failed(m marker, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[code]++
+}
+
+// received registra uma publicação vinda do broker.
+func (s *stats) received(m marker, clock int64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[m.key()]
+	if !ok {
+		// Mesma execução sem envio registrado (ex.: -run repetido)
+		r = &record{marker: m}
+		s.records[m.key()] = r
+	}
+	r.received++
+	if r.received > 1 {
+		return
+	}
+	r.clock = clock
+	s.e2eLat = append(s.e2eLat, at.Sub(m.SentAt))
+
+	stream := m.Sender + "/" + m.Dest
+	if last, ok := s.lastSeq[stream]; ok && m.Seq < last {
+		s.outOrder++
+	} else {
+		s.lastSeq[stream] = m.Seq
+	}
+}
+
+func (s *stats) unknown() {
+	s.mu.Lock()
+	s.foreign++
+	s.mu.Unlock()
+}
+
+// dropped registra os eventos que a assinatura descartou por falta de espaço.
+func (s *stats) dropped(n int64) {
+	s.mu.Lock()
+	s.discard = n
+	s.mu.Unlock()
+}
+
+// report escreve o resumo da execução. elapsed é a duração da fase de envio.
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var acked, lost, unacked, dups, delivered, clockInv int
+	bySender := make(map[string][]*record)
+	for _, r := range s.records {
+		if r.received > 0 {
+			delivered++
+		}
+		if r.received > 1 {
+			dups += r.received - 1
+		}
+		switch {
+		case r.acked && r.received == 0:
+			lost++
+		case !r.acked && r.received > 0:
+			unacked++
+		}
+		if r.acked {
+			acked++
+		}
+		if r.received > 0 {
+			bySender[r.Sender] = append(bySender[r.Sender], r)
+		}
+	}
+	// O relógio de Lamport de um remetente deve crescer com o seq: cada
+	// envio parte de um relógio que já viu a resposta do anterior
+	for _, rs := range bySender {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].Seq < rs[j].Seq })
+		for i := 1; i < len(rs); i++ {
+			if rs[i-1].acked && rs[i].clock <= rs[i-1].clock {
+				clockInv++
+			}
+		}
+	}
+
+	secs := elapsed.Seconds()
+	fmt.Fprintf(w, "\n📊 Resultado (%s, execução %s)\n", elapsed.Round(time.Millisecond), s.run)
+	fmt.Fprintf(w, "   Enviadas:          %d (%d publicações, %d diretas)\n", s.sentPub+s.sentDM, s.sentPub, s.sentDM)
+	fmt.Fprintf(w, "   Confirmadas:       %d (%.1f/s)\n", acked, float64(acked)/secs)
+	fmt.Fprintf(w, "   Recebidas:         %d (%.1f/s)\n", delivered, float64(delivered)/secs)
+	if len(s.errors) > 0 {
+		codes := make([]string, 0, len(s.errors))
+		for code := range s.errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		fmt.Fprintf(w, "   Erros:            ")
+		for _, code := range codes {
+			fmt.Fprintf(w, " %s=%d", code, s.errors[code])
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "   Latência resposta: %s\n", percentiles(s.ackLat))
+	fmt.Fprintf(w, "   Latência e2e:      %s\n", percentiles(s.e2eLat))
+	fmt.Fprintf(w, "   Perdidas:          %d (confirmadas e nunca recebidas)\n", lost)
+	fmt.Fprintf(w, "   Sem confirmação:   %d (recebidas, mas sem resposta de sucesso)\n", unacked)
+	if s.discard > 0 {
+		fmt.Fprintf(w, "   Descartadas:       %d (chegaram, mas o observador não acompanhou; aparecem como perdidas)\n", s.discard)
+	}
+	fmt.Fprintf(w, "   Duplicadas:        %d\n", dups)
+	fmt.Fprintf(w, "   Fora de ordem:     %d (no mesmo remetente e destino)\n", s.outOrder)
+	fmt.Fprintf(w, "   Relógio invertido: %d (clock não cresce com o envio)\n", clockInv)
+	if s.foreign > 0 {
+		fmt.Fprintf(w, "   Ignoradas:         %d (de outras execuções)\n", s.foreign)
+	}
+}
+
+func percentiles(lat []time.Duration) string {
+	if len(lat) == 0 {
+		return "-"
+	}
+	sorted := append([]time.Duration(nil), lat...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))].Round(10 * time.Microsecond)
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s máx=%s", at(0.50), at(0.90), at(0.99), sorted[len(sorted)-1].Round(10*time.Microsecond))
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ----------------------------
+// Codecs (MessagePack, JSON, Protobuf)
+// ----------------------------
+
+// Internamente tudo continua em MessagePack: os handlers recebem e devolvem
+// msgpack. O codec só traduz na borda, no loop principal, usando as mesmas
+// structs (e as tags msgpack) para os três formatos.
+//
+// O formato de cada requisição é escolhido, nesta ordem, por:
+//  1. um byte inicial (formatMsgpack, formatJSON, formatProtobuf), que é
+//     repetido na resposta;
+//  2. o codec negociado no hello da conexão;
+//  3. detecção: "{" indica JSON, qualquer outra coisa é MessagePack.
+const (
+	formatMsgpack  byte = 0x01
+	formatJSON     byte = 0x02
+	formatProtobuf byte = 0x03
+)
+
+// Codec converte entre o formato de fio e uma árvore genérica
+// (map[string]interface{}, []interface{}, números, strings, []byte).
+type Codec interface {
+	Name() string
+	Decode(payload []byte) (interface{}, error)
+	Encode(tree interface{}) ([]byte, error)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Decode(payload []byte) (interface{}, error) {
+	var tree interface{}
+	err := msgpack.Unmarshal(payload, &tree)
+	return tree, err
+}
+
+func (msgpackCodec) Encode(tree interface{}) ([]byte, error) {
+	return msgpack.Marshal(tree)
+}
+
+// jsonCodec: campos []byte (pedaços de upload/download) vão em base64.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Decode(payload []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var tree interface{}
+	err := dec.Decode(&tree)
+	return tree, err
+}
+
+func (jsonCodec) Encode(tree interface{}) ([]byte, error) {
+	return json.Marshal(tree)
+}
+
+// protobufCodec usa a mensagem bem conhecida google.protobuf.Struct, então
+// qualquer biblioteca Protobuf lê as mensagens sem um .proto próprio.
+// Números viram double e []byte vira string base64.
+//
+// A codificação genérica é intencional: o protocolo tem dezenas de serviços
+// definidos só pelas structs Go, e mensagens tipadas exigiriam manter um
+// .proto paralelo a elas e rodar o protoc no build. O que o esquema daria é
+// feito aqui de outra forma: conformTree devolve cada campo ao tipo da struct
+// de destino (requestTypes) e validateRequest confere conteúdo e tamanhos.
+// O custo é o tamanho (nomes de campo em toda mensagem, base64) e números
+// como double, sem perda porque nenhum inteiro do protocolo passa de 2^53.
+// Quem quer um esquema tipado usa o gateway gRPC (proto/chat.proto).
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Decode(payload []byte) (interface{}, error) {
+	var s structpb.Struct
+	if err := proto.Unmarshal(payload, &s); err != nil {
+		return nil, err
+	}
+	return s.AsMap(), nil
+}
+
+func (protobufCodec) Encode(tree interface{}) ([]byte, error) {
+	m, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("protobuf: mensagem deve ser um mapa, não %T", tree)
+	}
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(s)
+}
+
+var codecs = map[byte]Codec{
+	formatMsgpack:  msgpackCodec{},
+	formatJSON:     jsonCodec{},
+	formatProtobuf: protobufCodec{},
+}
+
+// codecByName devolve o byte de formato de um codec ("msgpack", "json", "protobuf").
+func codecByName(name string) (byte, bool) {
+	for format, c := range codecs {
+		if c.Name() == name {
+			return format, true
+		}
+	}
+	return 0, false
+}
+
+// frameFormat identifica o formato da requisição e devolve o payload sem o
+// byte inicial. prefixed informa se a resposta deve levar o byte.
+func (srv *Server) frameFormat(conn string, frame []byte) (format byte, payload []byte, prefixed bool) {
+	if len(frame) > 0 {
+		if _, ok := codecs[frame[0]]; ok {
+			return frame[0], frame[1:], true
+		}
+	}
+
+	if format, ok := srv.sessionCodec(conn); ok {
+		return format, frame, false
+	}
+
+	if trimmed := bytes.TrimLeft(frame, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '{' {
+		return formatJSON, frame, false
+	}
+	return formatMsgpack, frame, false
+}
+
+// decodeFrame converte a requisição recebida para MessagePack.
+func decodeFrame(format byte, payload []byte) ([]byte, error) {
+	if format == formatMsgpack {
+		return payload, nil
+	}
+	tree, err := codecs[format].Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := tree.(map[string]interface{}); ok {
+		if service, _ := m["service"].(string); requestTypes[service] != nil {
+			tree = conformTree(requestTypes[service], tree)
+		} else {
+			tree = conformTree(nil, tree)
+		}
+	}
+	return msgpack.Marshal(tree)
+}
+
+// encodeFrame converte a resposta (MessagePack) para o formato do cliente.
+func encodeFrame(format byte, prefixed bool, response []byte) ([]byte, error) {
+	out := response
+	if format != formatMsgpack {
+		tree, err := msgpackCodec{}.Decode(response)
+		if err != nil {
+			return nil, err
+		}
+		if out, err = codecs[format].Encode(normalizeTree(tree)); err != nil {
+			return nil, err
+		}
+	}
+	if prefixed {
+		out = append([]byte{format}, out...)
+	}
+	return out, nil
+}
+
+// Tipos das requisições de clientes, usados para converter números e
+// base64 nos formatos textuais. Serviços sem entrada recebem só a conversão
+// genérica de números.
+var requestTypes = map[string]reflect.Type{
+	"hello":           reflect.TypeOf(HelloRequest{}),
+	"login":           reflect.TypeOf(LoginRequest{}),
+	"users":           reflect.TypeOf(UsersRequest{}),
+	"channel":         reflect.TypeOf(ChannelRequest{}),
+	"channels":        reflect.TypeOf(ChannelsRequest{}),
+	"channel_update":  reflect.TypeOf(ChannelUpdateRequest{}),
+	"channel_rename":  reflect.TypeOf(ChannelRenameRequest{}),
+	"channel_archive": reflect.TypeOf(ChannelArchiveRequest{}),
+	"channel_delete":  reflect.TypeOf(ChannelDeleteRequest{}),
+	"publish":         reflect.TypeOf(PublishRequest{}),
+	"message":         reflect.TypeOf(MessageRequest{}),
+	"edit_message":    reflect.TypeOf(EditMessageRequest{}),
+	"delete_message":  reflect.TypeOf(DeleteMessageRequest{}),
+	"react":           reflect.TypeOf(ReactRequest{}),
+	"unreact":         reflect.TypeOf(ReactRequest{}),
+	"receipt":         reflect.TypeOf(ReceiptRequest{}),
+	"pending":         reflect.TypeOf(PendingRequest{}),
+	"upload":          reflect.TypeOf(UploadRequest{}),
+	"download":        reflect.TypeOf(DownloadRequest{}),
+	"search":          reflect.TypeOf(SearchRequest{}),
+	"rate_limits":     reflect.TypeOf(RateLimitsRequest{}),
+	"history":         reflect.TypeOf(HistoryRequest{}),
+	"direct_history":  reflect.TypeOf(DirectHistoryRequest{}),
+	"thread":          reflect.TypeOf(ThreadRequest{}),
+}
+
+// conformTree ajusta uma árvore vinda de JSON/Protobuf ao tipo Go de destino:
+// números viram inteiros onde o campo é inteiro e strings base64 viram bytes
+// onde o campo é []byte. Com t nil, números inteiros viram int64.
+func conformTree(t reflect.Type, v interface{}) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		fields := map[string]reflect.Type{}
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = structFields(t)
+		} else if t != nil && t.Kind() == reflect.Map {
+			elem = t.Elem()
+		}
+		for k, item := range val {
+			ft := elem
+			if t != nil && t.Kind() == reflect.Struct {
+				ft = fields[k]
+			}
+			val[k] = conformTree(ft, item)
+		}
+		return val
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for i, item := range val {
+			val[i] = conformTree(elem, item)
+		}
+		return val
+	case string:
+		if t != nil && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			if b, err := base64.StdEncoding.DecodeString(val); err == nil {
+				return b
+			}
+		}
+		if t != nil && t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64 {
+			// Parâmetros de URL chegam como texto mesmo quando o campo é numérico
+			if f, err := strconv.ParseFloat(val, 64); err == nil {
+				return conformNumber(t, f)
+			}
+		}
+		return val
+	case json.Number:
+		if t != nil && t.Kind() == reflect.String {
+			return val.String()
+		}
+		if f, err := val.Float64(); err == nil {
+			return conformNumber(t, f)
+		}
+		return val.String()
+	case float64:
+		return conformNumber(t, val)
+	}
+	return v
+}
+
+func conformNumber(t reflect.Type, f float64) interface{} {
+	if t != nil {
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return f
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return uint64(f)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return int64(f)
+		}
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f)
+	}
+	return f
+}
+
+// structFields mapeia o nome msgpack de cada campo ao seu tipo, incluindo
+// campos ",inline" e structs embutidas.
+func structFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("msgpack")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if strings.Contains(opts, "inline") || (f.Anonymous && name == "") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			for k, v := range structFields(ft) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// normalizeTree converte a árvore decodificada de MessagePack para tipos que
+// JSON e structpb aceitam (int64/uint64/float64, mapas com chave string).
+func normalizeTree(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeTree(item)
+		}
+		return val
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[fmt.Sprint(k)] = normalizeTree(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeTree(item)
+		}
+		return val
+	case int8:
+		return int64(val)
+	case int16:
+		return int64(val)
+	case int32:
+		return int64(val)
+	case int:
+		return int64(val)
+	case uint8:
+		return uint64(val)
+	case uint16:
+		return uint64(val)
+	case uint32:
+		return uint64(val)
+	case uint:
+		return uint64(val)
+	case float32:
+		return float64(val)
+	}
+	return v
+}
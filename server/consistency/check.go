@@ -0,0 +1,296 @@
+package consistency
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Property é uma propriedade verificada por Check.
+type Property string
+
+const (
+	// Lost: uma publicação confirmada antes de uma leitura final não aparece
+	// nela.
+	Lost Property = "lost"
+	// Duplicate: uma leitura mostra a mesma mensagem mais de uma vez, ou duas
+	// publicações receberam o mesmo ID.
+	Duplicate Property = "duplicate"
+	// Order: duas leituras do mesmo canal (em qualquer réplica, em qualquer
+	// momento) mostram duas mensagens em ordens opostas.
+	Order Property = "order"
+	// ReadYourWrites: uma leitura não mostra uma publicação da mesma sessão
+	// confirmada antes de ela começar.
+	ReadYourWrites Property = "read-your-writes"
+)
+
+// Violation é uma quebra de propriedade, com as operações envolvidas
+// (índices na lista passada a Check).
+type Violation struct {
+	Property Property
+	Channel  string
+	IDs      []string // mensagens envolvidas
+	Ops      []int
+	Detail   string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("[%s] #%s: %s", v.Property, v.Channel, v.Detail)
+}
+
+// Report é o resultado de Check.
+type Report struct {
+	Ops        int
+	Publishes  int
+	Acked      int // publicações confirmadas
+	Reads      int // leituras que deram certo
+	FinalReads int
+	Violations []Violation
+}
+
+// OK informa se nenhuma propriedade foi quebrada.
+func (r Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Count devolve quantas violações de p foram encontradas.
+func (r Report) Count(p Property) int {
+	n := 0
+	for _, v := range r.Violations {
+		if v.Property == p {
+			n++
+		}
+	}
+	return n
+}
+
+// Write escreve o relatório em w.
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "📊 %d operações: %d publicações (%d confirmadas), %d leituras (%d finais)\n",
+		r.Ops, r.Publishes, r.Acked, r.Reads, r.FinalReads)
+	if r.FinalReads == 0 {
+		fmt.Fprintln(w, "⚠️  Sem leituras finais: perdas de publicações não foram verificadas")
+	}
+	for _, p := range []Property{Lost, Duplicate, Order, ReadYourWrites} {
+		fmt.Fprintf(w, "   %-17s %d\n", p+":", r.Count(p))
+	}
+	for _, v := range r.Violations {
+		fmt.Fprintf(w, "❌ %s\n", v)
+	}
+	if r.OK() {
+		fmt.Fprintln(w, "✅ Nenhuma violação")
+	}
+}
+
+// Check verifica um histórico gravado por Recorder. Operações com erro não
+// contam como confirmadas, mas as mensagens delas podem aparecer nas
+// leituras sem que isso seja uma violação. As leituras devem trazer o canal
+// inteiro (limite maior que o número de mensagens).
+func Check(ops []Op) Report {
+	var r Report
+	r.Ops = len(ops)
+	for _, op := range ops {
+		switch {
+		case op.Kind == Publish:
+			r.Publishes++
+			if op.OK() {
+				r.Acked++
+			}
+		case op.Kind == Read && op.OK():
+			r.Reads++
+			if op.Final {
+				r.FinalReads++
+			}
+		}
+	}
+	r.Violations = append(r.Violations, checkDuplicates(ops)...)
+	r.Violations = append(r.Violations, checkLost(ops)...)
+	r.Violations = append(r.Violations, checkOrder(ops)...)
+	r.Violations = append(r.Violations, checkReadYourWrites(ops)...)
+	return r
+}
+
+func acked(op Op) bool { return op.Kind == Publish && op.OK() && op.ID != "" }
+func read(op Op) bool  { return op.Kind == Read && op.OK() }
+
+func checkDuplicates(ops []Op) []Violation {
+	var out []Violation
+	byID := make(map[string]int)
+	for i, op := range ops {
+		if !acked(op) {
+			continue
+		}
+		if j, dup := byID[op.ID]; dup {
+			out = append(out, Violation{
+				Property: Duplicate, Channel: op.Channel, IDs: []string{op.ID}, Ops: []int{j, i},
+				Detail: fmt.Sprintf("ID %s devolvido para as publicações %d e %d", op.ID, j, i),
+			})
+			continue
+		}
+		byID[op.ID] = i
+	}
+	for i, op := range ops {
+		if !read(op) {
+			continue
+		}
+		seen := make(map[string]bool, len(op.IDs))
+		var dups []string
+		for _, id := range op.IDs {
+			if seen[id] {
+				dups = append(dups, id)
+			}
+			seen[id] = true
+		}
+		if len(dups) > 0 {
+			out = append(out, Violation{
+				Property: Duplicate, Channel: op.Channel, IDs: dups, Ops: []int{i},
+				Detail: fmt.Sprintf("%s leu %s repetida(s)", describe(op), listIDs(dups)),
+			})
+		}
+	}
+	return out
+}
+
+// checkLost procura, em cada leitura final, as publicações do canal
+// confirmadas antes de ela começar.
+func checkLost(ops []Op) []Violation {
+	var out []Violation
+	for i, op := range ops {
+		if !read(op) || !op.Final {
+			continue
+		}
+		missing, involved := missingWrites(ops, op, func(p Op) bool { return true })
+		if len(missing) > 0 {
+			out = append(out, Violation{
+				Property: Lost, Channel: op.Channel, IDs: missing, Ops: append(involved, i),
+				Detail: fmt.Sprintf("%s não tem %d publicação(ões) confirmada(s): %s", describe(op), len(missing), listIDs(missing)),
+			})
+		}
+	}
+	return out
+}
+
+func checkReadYourWrites(ops []Op) []Violation {
+	var out []Violation
+	for i, op := range ops {
+		if !read(op) || op.Final {
+			continue
+		}
+		missing, involved := missingWrites(ops, op, func(p Op) bool { return p.Session == op.Session })
+		if len(missing) > 0 {
+			out = append(out, Violation{
+				Property: ReadYourWrites, Channel: op.Channel, IDs: missing, Ops: append(involved, i),
+				Detail: fmt.Sprintf("%s não mostra as próprias publicações %s", describe(op), listIDs(missing)),
+			})
+		}
+	}
+	return out
+}
+
+// missingWrites devolve as publicações confirmadas no canal de rd, antes de
+// rd começar e aceitas por match, que rd não mostra.
+func missingWrites(ops []Op, rd Op, match func(Op) bool) (ids []string, idx []int) {
+	got := make(map[string]bool, len(rd.IDs))
+	for _, id := range rd.IDs {
+		got[id] = true
+	}
+	for j, p := range ops {
+		if acked(p) && p.Channel == rd.Channel && p.End.Before(rd.Start) && match(p) && !got[p.ID] {
+			ids = append(ids, p.ID)
+			idx = append(idx, j)
+		}
+	}
+	return ids, idx
+}
+
+// checkOrder compara a ordem relativa de cada par de mensagens em todas as
+// leituras do mesmo canal. Conflitos entre as mesmas duas leituras viram uma
+// só violação.
+func checkOrder(ops []Op) []Violation {
+	type pair [2]string
+	type conflict struct {
+		v     *Violation
+		count int
+	}
+	var out []Violation
+	var conflicts []*conflict
+	byReads := make(map[[2]int]*conflict)
+	first := make(map[string]map[pair]int) // canal -> (antes, depois) -> leitura onde foi visto
+
+	for i, op := range ops {
+		if !read(op) {
+			continue
+		}
+		seen := first[op.Channel]
+		if seen == nil {
+			seen = make(map[pair]int)
+			first[op.Channel] = seen
+		}
+		ids := uniq(op.IDs)
+		for a := 0; a < len(ids); a++ {
+			for b := a + 1; b < len(ids); b++ {
+				p := pair{ids[a], ids[b]}
+				j, inverted := seen[pair{p[1], p[0]}]
+				if !inverted {
+					if _, ok := seen[p]; !ok {
+						seen[p] = i
+					}
+					continue
+				}
+				key := [2]int{j, i}
+				c := byReads[key]
+				if c == nil {
+					c = &conflict{v: &Violation{
+						Property: Order, Channel: op.Channel, IDs: []string{p[1], p[0]}, Ops: []int{j, i},
+					}}
+					byReads[key] = c
+					conflicts = append(conflicts, c)
+				}
+				c.count++
+			}
+		}
+	}
+	for _, c := range conflicts {
+		v := *c.v
+		earlier, later := ops[v.Ops[0]], ops[v.Ops[1]]
+		v.Detail = fmt.Sprintf("%s mostra %s antes de %s, %s mostra o contrário (%d par(es) em conflito)",
+			describe(earlier), v.IDs[0], v.IDs[1], describe(later), c.count)
+		out = append(out, v)
+	}
+	return out
+}
+
+func uniq(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// describe identifica uma operação nas mensagens do relatório.
+func describe(op Op) string {
+	what := "leitura"
+	if op.Final {
+		what = "leitura final"
+	}
+	node := op.Node
+	if node == "" {
+		node = "?"
+	}
+	return fmt.Sprintf("%s de %s em %s (%s)", what, op.Session, node, op.Start.Format(time.StampMilli))
+}
+
+// listIDs abrevia listas longas de IDs.
+func listIDs(ids []string) string {
+	const max = 5
+	if len(ids) <= max {
+		return strings.Join(ids, ", ")
+	}
+	return fmt.Sprintf("%s e mais %d", strings.Join(ids[:max], ", "), len(ids)-max)
+}
@@ -0,0 +1,146 @@
+package consistency
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Históricos sintéticos: cada operação ocupa o intervalo [at, at+1) em
+// segundos a partir de t0.
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func pub(session, node, channel, id string, at int) Op {
+	return Op{Session: session, Node: node, Kind: Publish, Channel: channel, ID: id,
+		Start: t0.Add(time.Duration(at) * time.Second), End: t0.Add(time.Duration(at+1) * time.Second)}
+}
+
+func rd(session, node, channel string, at int, ids ...string) Op {
+	return Op{Session: session, Node: node, Kind: Read, Channel: channel, IDs: ids,
+		Start: t0.Add(time.Duration(at) * time.Second), End: t0.Add(time.Duration(at+1) * time.Second)}
+}
+
+func final(op Op) Op {
+	op.Final = true
+	return op
+}
+
+func failed(op Op) Op {
+	op.Err = "tempo de resposta esgotado"
+	op.ID = ""
+	return op
+}
+
+func TestCheckCleanHistory(t *testing.T) {
+	ops := []Op{
+		pub("alice", "a", "geral", "m1", 0),
+		pub("bob", "b", "geral", "m2", 0), // concorrente com m1
+		rd("alice", "a", "geral", 2, "m1", "m2"),
+		rd("bob", "b", "geral", 2, "m1", "m2"),
+		failed(pub("bob", "b", "geral", "", 3)),
+		rd("carol", "c", "geral", 5, "m1", "m2", "m3"), // m3 veio da publicação sem resposta
+		final(rd("final", "a", "geral", 9, "m1", "m2", "m3")),
+		final(rd("final", "b", "geral", 9, "m1", "m2")),
+	}
+	r := Check(ops)
+	if !r.OK() {
+		t.Fatalf("violações inesperadas: %v", r.Violations)
+	}
+	if r.Publishes != 3 || r.Acked != 2 || r.Reads != 5 || r.FinalReads != 2 {
+		t.Errorf("contagens: %+v", r)
+	}
+}
+
+func TestCheckLost(t *testing.T) {
+	ops := []Op{
+		pub("alice", "a", "geral", "m1", 0),
+		pub("alice", "a", "geral", "m2", 2),
+		pub("alice", "a", "geral", "m3", 8), // termina depois do início das leituras finais
+		final(rd("final", "a", "geral", 8, "m1", "m2")),
+		final(rd("final", "b", "geral", 8, "m1")),
+	}
+	r := Check(ops)
+	if r.Count(Lost) != 1 {
+		t.Fatalf("esperada 1 perda, veio %v", r.Violations)
+	}
+	v := r.Violations[0]
+	if !reflect.DeepEqual(v.IDs, []string{"m2"}) || !reflect.DeepEqual(v.Ops, []int{1, 4}) {
+		t.Errorf("violação: %+v", v)
+	}
+}
+
+func TestCheckDuplicates(t *testing.T) {
+	ops := []Op{
+		pub("alice", "a", "geral", "m1", 0),
+		pub("bob", "b", "geral", "m1", 0),
+		rd("carol", "c", "geral", 2, "m1", "m1"),
+	}
+	r := Check(ops)
+	if r.Count(Duplicate) != 2 {
+		t.Fatalf("esperadas 2 duplicações, veio %v", r.Violations)
+	}
+}
+
+func TestCheckOrderAcrossReplicas(t *testing.T) {
+	ops := []Op{
+		pub("alice", "a", "geral", "m1", 0),
+		pub("bob", "b", "geral", "m2", 0),
+		pub("bob", "b", "geral", "m3", 1),
+		rd("alice", "a", "geral", 3, "m1", "m2", "m3"),
+		rd("bob", "b", "geral", 3, "m2", "m3", "m1"),
+		rd("bob", "b", "outro", 3, "m3", "m1"), // canais diferentes não se comparam
+	}
+	r := Check(ops)
+	if r.Count(Order) != 1 {
+		t.Fatalf("esperado 1 conflito de ordem, veio %v", r.Violations)
+	}
+	v := r.Violations[0]
+	if !reflect.DeepEqual(v.IDs, []string{"m1", "m2"}) || !reflect.DeepEqual(v.Ops, []int{3, 4}) {
+		t.Errorf("violação: %+v", v)
+	}
+}
+
+func TestCheckReadYourWrites(t *testing.T) {
+	ops := []Op{
+		pub("alice", "a", "geral", "m1", 0),
+		pub("bob", "b", "geral", "m2", 0),
+		rd("alice", "b", "geral", 2, "m2"), // alice passou para b e não vê m1
+		rd("bob", "b", "geral", 2, "m2"),   // bob não precisa ver m1
+	}
+	r := Check(ops)
+	if r.Count(ReadYourWrites) != 1 || len(r.Violations) != 1 {
+		t.Fatalf("esperada 1 violação de read-your-writes, veio %v", r.Violations)
+	}
+	if v := r.Violations[0]; !reflect.DeepEqual(v.IDs, []string{"m1"}) {
+		t.Errorf("violação: %+v", v)
+	}
+}
+
+func TestRecorderSaveLoad(t *testing.T) {
+	rec := NewRecorder()
+	rec.Record(Op{Session: "alice", Kind: Publish, Channel: "geral"}, func(op *Op) error {
+		op.ID, op.Node = "m1", "a"
+		return nil
+	})
+	rec.Record(Op{Session: "alice", Kind: Read, Channel: "geral", Final: true}, func(op *Op) error {
+		op.IDs = []string{"m1"}
+		return nil
+	})
+
+	var buf bytes.Buffer
+	if err := rec.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	ops, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops[0].ID != "m1" || !ops[1].Final || ops[0].End.Before(ops[0].Start) {
+		t.Fatalf("histórico carregado: %+v", ops)
+	}
+	if r := Check(ops); !r.OK() || r.FinalReads != 1 {
+		t.Errorf("relatório: %+v", r)
+	}
+}
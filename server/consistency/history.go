@@ -0,0 +1,123 @@
+// Package consistency grava as operações dos clientes durante um teste e
+// verifica offline se o cluster se comportou como um chat deve: nenhuma
+// publicação confirmada perdida, nenhuma mensagem duplicada, a mesma ordem
+// do canal em todas as réplicas e cada sessão lendo o que ela mesma escreveu.
+//
+//	rec := consistency.NewRecorder()
+//	rec.Record(consistency.Op{Session: "alice@a", Kind: consistency.Publish, Channel: "geral"},
+//		func(op *consistency.Op) (err error) {
+//			op.ID, err = c.Publish(ctx, "alice", "geral", "Olá!")
+//			return err
+//		})
+//	...
+//	report := consistency.Check(rec.Ops())
+//
+// O histórico também pode ser salvo (Save, uma operação JSON por linha) e
+// verificado depois com go run ./cmd/histcheck.
+package consistency
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kind é o tipo de uma operação.
+type Kind string
+
+const (
+	// Publish publica em Channel; ID é o identificador devolvido pelo servidor.
+	Publish Kind = "publish"
+	// Read lê o histórico de Channel; IDs são as mensagens, da mais antiga
+	// para a mais recente.
+	Read Kind = "read"
+)
+
+// Op é uma operação de cliente, com o resultado e os instantes de início e
+// fim vistos pelo cliente.
+type Op struct {
+	Session string    `json:"session"`         // quem fez a operação, em sequência (um usuário num cliente)
+	Node    string    `json:"node,omitempty"`  // servidor que respondeu
+	Kind    Kind      `json:"kind"`            //
+	Channel string    `json:"channel"`         //
+	ID      string    `json:"id,omitempty"`    // Publish confirmado
+	IDs     []string  `json:"ids,omitempty"`   // Read
+	Final   bool      `json:"final,omitempty"` // Read do estado final, depois que o cluster se estabilizou
+	Err     string    `json:"error,omitempty"` // vazio se a operação deu certo
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+// OK informa se a operação foi confirmada pelo servidor. Uma operação com
+// erro (inclusive timeout) pode ou não ter sido aplicada.
+func (op Op) OK() bool {
+	return op.Err == ""
+}
+
+// Recorder acumula as operações de um teste. Pode ser usado por várias
+// goroutines ao mesmo tempo.
+type Recorder struct {
+	mu  sync.Mutex
+	ops []Op
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Record executa call marcando o início e o fim de op. call preenche o
+// resultado (ID, IDs, Node); o erro devolvido fica em op.Err e é repassado.
+func (r *Recorder) Record(op Op, call func(op *Op) error) error {
+	op.Start = time.Now()
+	err := call(&op)
+	op.End = time.Now()
+	if err != nil {
+		op.Err = err.Error()
+	}
+	r.mu.Lock()
+	r.ops = append(r.ops, op)
+	r.mu.Unlock()
+	return err
+}
+
+// Ops devolve uma cópia das operações gravadas, ordenadas pelo início.
+func (r *Recorder) Ops() []Op {
+	r.mu.Lock()
+	ops := append([]Op(nil), r.ops...)
+	r.mu.Unlock()
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Start.Before(ops[j].Start) })
+	return ops
+}
+
+// Save grava as operações em w, uma por linha.
+func (r *Recorder) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, op := range r.Ops() {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load lê um histórico gravado por Save.
+func Load(rd io.Reader) ([]Op, error) {
+	var ops []Op
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var op Op
+		if err := json.Unmarshal(sc.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("linha %d: %v", line, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, sc.Err()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"server/client"
+	"server/consistency"
+)
+
+// Histórico de operações dos clientes sob falhas, verificado pelo pacote
+// consistency. Para guardar o histórico e verificá-lo de novo offline:
+//
+//	go test -run TestConsistency -history /tmp/historico.jsonl .
+//	go run ./cmd/histcheck /tmp/historico.jsonl
+
+var historyFile = flag.String("history", "", "salva o histórico de TestConsistency* neste arquivo (para cmd/histcheck)")
+
+// recordedHistoryLimit é maior que qualquer canal dos testes: as leituras
+// gravadas precisam trazer o canal inteiro.
+const recordedHistoryLimit = 1000
+
+// session é um usuário num cliente ligado a um nó, com as operações gravadas.
+type session struct {
+	c    *cluster
+	rec  *consistency.Recorder
+	name string
+	user string
+	cl   *client.Client
+}
+
+func (c *cluster) Session(rec *consistency.Recorder, node, user string) *session {
+	return &session{c: c, rec: rec, name: user + "@" + node, user: user, cl: c.Client(node)}
+}
+
+func (s *session) node() string {
+	return strings.TrimPrefix(s.cl.Endpoint(), s.c.prefix+"-")
+}
+
+func (s *session) Publish(ctx context.Context, channel, text string) (string, error) {
+	var id string
+	err := s.rec.Record(consistency.Op{Session: s.name, Kind: consistency.Publish, Channel: channel},
+		func(op *consistency.Op) (err error) {
+			id, err = s.cl.Publish(ctx, s.user, channel, text)
+			op.ID, op.Node = id, s.node()
+			return err
+		})
+	return id, err
+}
+
+func (s *session) Read(ctx context.Context, channel string) ([]string, error) {
+	return s.read(ctx, channel, false)
+}
+
+// ReadFinal lê o estado final do canal, depois que o cluster se estabilizou.
+func (s *session) ReadFinal(ctx context.Context, channel string) ([]string, error) {
+	return s.read(ctx, channel, true)
+}
+
+func (s *session) read(ctx context.Context, channel string, final bool) ([]string, error) {
+	var ids []string
+	err := s.rec.Record(consistency.Op{Session: s.name, Kind: consistency.Read, Channel: channel, Final: final},
+		func(op *consistency.Op) error {
+			msgs, err := s.cl.History(ctx, channel, recordedHistoryLimit, "")
+			for _, m := range msgs {
+				ids = append(ids, m.ID)
+			}
+			op.IDs, op.Node = ids, s.node()
+			return err
+		})
+	return ids, err
+}
+
+// readAllFinal grava uma leitura final do canal em cada nó do cluster.
+func readAllFinal(t *testing.T, c *cluster, rec *consistency.Recorder, channel string) {
+	t.Helper()
+	ctx := testContext(t)
+	for _, name := range c.names {
+		if _, err := c.Session(rec, name, "final").ReadFinal(ctx, channel); err != nil {
+			t.Fatalf("leitura final em %s: %v", name, err)
+		}
+	}
+}
+
+// workload faz cada sessão alternar publicação e leitura do canal por d.
+// Publicações recusadas (limite de taxa, timeout) ficam no histórico como
+// não confirmadas.
+func workload(ctx context.Context, sessions []*session, channel string, d time.Duration) {
+	deadline := time.Now().Add(d)
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *session) {
+			defer wg.Done()
+			for i := 0; time.Now().Before(deadline); i++ {
+				s.Publish(ctx, channel, fmt.Sprintf("%s %d", s.name, i))
+				s.Read(ctx, channel)
+				time.Sleep(250 * time.Millisecond) // abaixo do limite de publish.user
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+// ackedIDs devolve os IDs das publicações confirmadas no canal.
+func ackedIDs(ops []consistency.Op, channel string) []string {
+	var ids []string
+	for _, op := range ops {
+		if op.Kind == consistency.Publish && op.OK() && op.Channel == channel {
+			ids = append(ids, op.ID)
+		}
+	}
+	return ids
+}
+
+// checkHistory verifica ops, registrando o relatório no log do teste.
+func checkHistory(t *testing.T, ops []consistency.Op) consistency.Report {
+	t.Helper()
+	report := consistency.Check(ops)
+	var buf bytes.Buffer
+	report.Write(&buf)
+	t.Log("\n" + buf.String())
+	return report
+}
+
+func saveHistory(t *testing.T, rec *consistency.Recorder) {
+	t.Helper()
+	if *historyFile == "" {
+		return
+	}
+	f, err := os.Create(*historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := rec.Save(f); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("📝 histórico salvo em %s", *historyFile)
+}
+
+func TestConsistencyUnderPartition(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", testAdminToken) // resyncFrom usa admin_dump
+	c := newCluster(t, "a", "b", "c")
+	ctx := testContext(t)
+	setupChannel(t, c, "a", "alice", "geral")
+	for node, user := range map[string]string{"b": "bob", "c": "carol"} {
+		if err := c.Client(node).Login(ctx, user); err != nil {
+			t.Fatalf("login de %s: %v", user, err)
+		}
+	}
+
+	rec := consistency.NewRecorder()
+	sessions := []*session{
+		c.Session(rec, "a", "alice"),
+		c.Session(rec, "b", "bob"),
+		c.Session(rec, "c", "carol"),
+	}
+
+	// a e b deixam de se falar; c continua alcançando os dois
+	workload(ctx, sessions, "geral", time.Second)
+	c.Partition([]string{"a"}, []string{"b"})
+	workload(ctx, sessions, "geral", time.Second)
+	c.Heal()
+	workload(ctx, sessions, "geral", time.Second)
+
+	// c recebeu todas as réplicas, a e b não as que a partição perdeu
+	want := ackedIDs(rec.Ops(), "geral")
+	waitHistory(t, c, "c", "geral", len(want))
+	probe := consistency.NewRecorder()
+	readAllFinal(t, c, probe, "geral")
+	report := checkHistory(t, append(rec.Ops(), probe.Ops()...))
+	if report.Count(consistency.Lost) == 0 {
+		t.Error("as publicações perdidas na partição não foram detectadas")
+	}
+	for _, p := range []consistency.Property{consistency.Duplicate, consistency.Order, consistency.ReadYourWrites} {
+		if n := report.Count(p); n > 0 {
+			t.Errorf("%d violação(ões) de %s antes do resync", n, p)
+		}
+	}
+
+	// Cada réplica copia das outras o que falta
+	for _, name := range c.names {
+		for _, peer := range c.names {
+			if peer == name {
+				continue
+			}
+			srv := c.Server(name)
+			srv.resyncFrom(peer)
+			srv.resyncMutex.Lock()
+			result := *srv.lastResync
+			srv.resyncMutex.Unlock()
+			if result.Error != "" {
+				t.Fatalf("resync de %s a partir de %s: %s", name, peer, result.Error)
+			}
+		}
+	}
+	readAllFinal(t, c, rec, "geral")
+	saveHistory(t, rec)
+	if report := checkHistory(t, rec.Ops()); !report.OK() {
+		for _, v := range report.Violations {
+			t.Error(v)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/topics"
+)
+
+// ----------------------------
+// Edição e remoção de mensagens
+// ----------------------------
+
+type EditMessageRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		ID        string `msgpack:"id"`
+		Message   string `msgpack:"message"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type DeleteMessageRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		ID        string `msgpack:"id"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Versão anterior de uma mensagem, guardada para auditoria
+type MessageVersion struct {
+	Message  string `msgpack:"message"`
+	EditedAt int64  `msgpack:"edited_at"`
+	EditedBy string `msgpack:"edited_by"`
+}
+
+// Alteração replicada entre servidores ("message_edit")
+type MessageEdit struct {
+	ID      string `msgpack:"id"`
+	Message string `msgpack:"message"`
+	Deleted bool   `msgpack:"deleted"`
+	By      string `msgpack:"by"`
+	At      int64  `msgpack:"at"`
+}
+
+// Evento publicado no tópico original da mensagem
+type MessageEvent struct {
+	Event     string `msgpack:"event"` // "edit" ou "delete"
+	ID        string `msgpack:"id"`
+	User      string `msgpack:"user"`
+	Message   string `msgpack:"message"`
+	Timestamp int64  `msgpack:"timestamp"`
+	Clock     int64  `msgpack:"clock"`
+}
+
+// messageRef descreve onde uma mensagem foi publicada e quem é o autor.
+type messageRef struct {
+	Author  string
+	Target  string // canal ou usuário de destino
+	Channel bool
+	Deleted bool
+}
+
+// topic devolve o tópico do broker em que os eventos da mensagem são publicados.
+func (r messageRef) topic() string {
+	if r.Channel {
+		return topics.Channel(r.Target)
+	}
+	return topics.User(r.Target)
+}
+
+// lookupMessage procura uma mensagem de canal ou direta pelo ID.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) lookupMessage(id string) (messageRef, bool) {
+	if id == "" {
+		return messageRef{}, false
+	}
+	for _, cm := range srv.data.ChannelMessages {
+		if cm.ID == id {
+			return messageRef{Author: cm.User, Target: cm.Channel, Channel: true, Deleted: cm.Deleted}, true
+		}
+	}
+	for _, um := range srv.data.UserMessages {
+		if um.ID == id {
+			return messageRef{Author: um.Src, Target: um.Dst, Deleted: um.Deleted}, true
+		}
+	}
+	return messageRef{}, false
+}
+
+// applyMessageEdit aplica uma edição/remoção, guardando a versão anterior.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) applyMessageEdit(me MessageEdit) bool {
+	apply := func(message *string, deleted *bool, history *[]MessageVersion) {
+		*history = append(*history, MessageVersion{Message: *message, EditedAt: me.At, EditedBy: me.By})
+		if me.Deleted {
+			*message = ""
+			*deleted = true
+		} else {
+			*message = me.Message
+		}
+		if me.Deleted {
+			srv.unindexMessage(me.ID)
+		} else {
+			srv.indexMessage(me.ID, me.Message)
+		}
+	}
+
+	for i := range srv.data.ChannelMessages {
+		cm := &srv.data.ChannelMessages[i]
+		if cm.ID == me.ID {
+			apply(&cm.Message, &cm.Deleted, &cm.History)
+			return true
+		}
+	}
+	for i := range srv.data.UserMessages {
+		um := &srv.data.UserMessages[i]
+		if um.ID == me.ID {
+			apply(&um.Message, &um.Deleted, &um.History)
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *Server) handleEditMessage(msg []byte) ([]byte, error) {
+	var req EditMessageRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	if req.Data.Message == "" {
+		resp := MessageResponse{Service: "edit_message"}
+		resp.Data.Timestamp = time.Now().Unix()
+		resp.Data.Clock = srv.incrementClock()
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrEmptyMessage
+		resp.Data.Message = "Mensagem não pode ser vazia"
+		return msgpack.Marshal(resp)
+	}
+
+	return srv.changeMessage("edit_message", MessageEdit{
+		ID:      req.Data.ID,
+		Message: req.Data.Message,
+		By:      req.Data.User,
+		At:      req.Data.Timestamp,
+	})
+}
+
+func (srv *Server) handleDeleteMessage(msg []byte) ([]byte, error) {
+	var req DeleteMessageRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	return srv.changeMessage("delete_message", MessageEdit{
+		ID:      req.Data.ID,
+		Deleted: true,
+		By:      req.Data.User,
+		At:      req.Data.Timestamp,
+	})
+}
+
+// changeMessage valida permissões, aplica a alteração, avisa os inscritos
+// no tópico original e replica para os demais servidores.
+func (srv *Server) changeMessage(service string, edit MessageEdit) ([]byte, error) {
+	resp := MessageResponse{Service: service}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.ID = edit.ID
+
+	srv.dataMutex.Lock()
+	ref, ok := srv.lookupMessage(edit.ID)
+	switch {
+	case !ok:
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageNotFound
+		resp.Data.Message = "Mensagem não encontrada"
+	case ref.Author != edit.By && !isModerator(edit.By):
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrForbidden
+		resp.Data.Message = "Apenas o autor ou um moderador pode alterar a mensagem"
+	case ref.Deleted:
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageDeleted
+		resp.Data.Message = "Mensagem já foi removida"
+	case ref.Channel && srv.isArchived(ref.Target):
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelArchived
+		resp.Data.Message = "Canal arquivado (somente leitura)"
+	default:
+		srv.applyMessageEdit(edit)
+	}
+	srv.dataMutex.Unlock()
+
+	if resp.Data.Status != "" {
+		return msgpack.Marshal(resp)
+	}
+
+	if err := srv.saveData(); err != nil {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrStorage
+		resp.Data.Message = "Erro ao salvar alteração: " + err.Error()
+		return msgpack.Marshal(resp)
+	}
+
+	event := MessageEvent{
+		Event:     "edit",
+		ID:        edit.ID,
+		User:      edit.By,
+		Message:   edit.Message,
+		Timestamp: edit.At,
+		Clock:     srv.incrementClock(),
+	}
+	if edit.Deleted {
+		event.Event = "delete"
+	}
+	if eventData, err := msgpack.Marshal(event); err == nil {
+		if _, err := srv.pubSocket.SendMessage(ref.topic(), eventData); err != nil {
+			log.Printf("❌ Erro ao publicar evento de %s em %s: %v", event.Event, ref.Target, err)
+		}
+	}
+
+	resp.Data.Status = "OK"
+	log.Printf("✏️  Mensagem %s alterada (%s) por %s (clock: %d)", edit.ID, event.Event, edit.By, event.Clock)
+	srv.replicateAsync("message_edit", edit)
+
+	return msgpack.Marshal(resp)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/protocol"
+)
+
+// ----------------------------
+// Envelope de resposta e códigos de erro
+// ----------------------------
+
+// ProtocolVersion é a versão mais recente do protocolo cliente-servidor
+// (ver handshake.go para as versões suportadas).
+const ProtocolVersion = protocol.Version
+
+// Códigos de erro e status do envelope (definidos em server/protocol)
+const (
+	ErrInvalidPayload     = protocol.ErrInvalidPayload
+	ErrPayloadTooLarge    = protocol.ErrPayloadTooLarge
+	ErrUnknownService     = protocol.ErrUnknownService
+	ErrUnsupportedVersion = protocol.ErrUnsupportedVersion
+	ErrInvalidName        = protocol.ErrInvalidName
+	ErrNameConflict       = protocol.ErrNameConflict
+	ErrEmptyMessage       = protocol.ErrEmptyMessage
+	ErrMessageTooLarge    = protocol.ErrMessageTooLarge
+	ErrFieldTooLarge      = protocol.ErrFieldTooLarge
+	ErrInvalidArgument    = protocol.ErrInvalidArgument
+	ErrRateLimited        = protocol.ErrRateLimited
+	ErrForbidden          = protocol.ErrForbidden
+	ErrUserNotFound       = protocol.ErrUserNotFound
+	ErrUserExists         = protocol.ErrUserExists
+	ErrChannelNotFound    = protocol.ErrChannelNotFound
+	ErrChannelExists      = protocol.ErrChannelExists
+	ErrChannelArchived    = protocol.ErrChannelArchived
+	ErrMessageNotFound    = protocol.ErrMessageNotFound
+	ErrMessageDeleted     = protocol.ErrMessageDeleted
+	ErrAttachmentNotFound = protocol.ErrAttachmentNotFound
+	ErrBlobNotFound       = protocol.ErrBlobNotFound
+	ErrBlobTooLarge       = protocol.ErrBlobTooLarge
+	ErrUploadNotFound     = protocol.ErrUploadNotFound
+	ErrInvalidOffset      = protocol.ErrInvalidOffset
+	ErrBrokerUnavailable  = protocol.ErrBrokerUnavailable
+	ErrStorage            = protocol.ErrStorage
+	ErrInternal           = protocol.ErrInternal
+
+	StatusOK    = protocol.StatusOK
+	StatusError = protocol.StatusError
+)
+
+type (
+	Envelope  = protocol.Envelope
+	ErrorInfo = protocol.ErrorInfo
+)
+
+// Resposta de erro para falhas anteriores ao handler (formato, validação,
+// limite de taxa, serviço desconhecido)
+type ErrorResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status      string  `msgpack:"status"`          // sempre "erro"
+		Error       string  `msgpack:"error"`           // código legível por máquina
+		Field       string  `msgpack:"field,omitempty"` // campo inválido, se houver
+		Message     string  `msgpack:"message"`
+		Description string  `msgpack:"description"`           // igual a message (clientes antigos)
+		RetryAfter  float64 `msgpack:"retry_after,omitempty"` // segundos (rate_limited)
+		Timestamp   int64   `msgpack:"timestamp"`
+		Clock       int64   `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ValidationError struct {
+	Code    string
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func (srv *Server) errorResponse(service string, verr *ValidationError) ([]byte, error) {
+	resp := ErrorResponse{Service: service}
+	resp.Data.Status = "erro"
+	resp.Data.Error = verr.Code
+	resp.Data.Field = verr.Field
+	resp.Data.Message = verr.Message
+	resp.Data.Description = verr.Message
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	return msgpack.Marshal(resp)
+}
+
+// errorPayload monta uma resposta de erro sem campo associado (ver errorResponse).
+func (srv *Server) errorPayload(service, code, message string) []byte {
+	response, _ := srv.errorResponse(service, &ValidationError{Code: code, Message: message})
+	return response
+}
+
+var requestCounter int64
+
+// requestID devolve o request_id enviado pelo cliente ou gera um novo.
+func (srv *Server) requestID(msg []byte) string {
+	var req struct {
+		RequestID string `msgpack:"request_id"`
+	}
+	msgpack.Unmarshal(msg, &req)
+	if req.RequestID != "" {
+		return req.RequestID
+	}
+	return fmt.Sprintf("%s-%d", srv.serverName, atomic.AddInt64(&requestCounter, 1))
+}
+
+// wrapResponse coloca a resposta de um handler no envelope. O status e o
+// erro são derivados de data.status ("erro"), data.error (código) e
+// data.message/data.description (texto).
+func wrapResponse(requestID string, payload []byte) ([]byte, error) {
+	var resp struct {
+		Service string             `msgpack:"service"`
+		Data    msgpack.RawMessage `msgpack:"data"`
+	}
+	if err := msgpack.Unmarshal(payload, &resp); err != nil {
+		return nil, err
+	}
+	var data struct {
+		Status      string `msgpack:"status"`
+		Error       string `msgpack:"error"`
+		Message     string `msgpack:"message"`
+		Description string `msgpack:"description"`
+	}
+	msgpack.Unmarshal(resp.Data, &data)
+
+	env := Envelope{
+		Service:   resp.Service,
+		Version:   ProtocolVersion,
+		RequestID: requestID,
+		Status:    StatusOK,
+		Data:      resp.Data,
+	}
+	if data.Status == "erro" {
+		env.Status = StatusError
+		env.Error = &ErrorInfo{Code: data.Error, Message: data.Message}
+		if env.Error.Code == "" {
+			env.Error.Code = ErrInternal
+		}
+		if env.Error.Message == "" {
+			env.Error.Message = data.Description
+		}
+	}
+	return msgpack.Marshal(env)
+}
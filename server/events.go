@@ -0,0 +1,231 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/topics"
+)
+
+// ----------------------------
+// Eventos do broker para os gateways (WebSocket, gRPC)
+// ----------------------------
+
+// Event é uma publicação recebida do broker, já decodificada.
+type Event struct {
+	Topic string                 // tópico completo ("ch/geral\x00")
+	Kind  string                 // topics.KindChannel ou topics.KindUser
+	Name  string                 // canal ou usuário
+	ID    string                 // ID da mensagem, se houver
+	Clock int64                  // relógio lógico da publicação
+	Data  map[string]interface{} // conteúdo publicado
+}
+
+// eventSubscriber recebe eventos do hub. deliver não pode bloquear; retornar
+// false indica que o assinante não acompanhou e deve ser desconectado.
+type eventSubscriber interface {
+	wants(topic string) bool
+	deliver(ev Event) bool
+	drop()
+}
+
+func (srv *Server) addEventSubscriber(s eventSubscriber) {
+	srv.eventsOnce.Do(srv.startEventRelay)
+	srv.eventsMutex.Lock()
+	srv.eventSubscribers[s] = struct{}{}
+	srv.eventsMutex.Unlock()
+}
+
+func (srv *Server) removeEventSubscriber(s eventSubscriber) {
+	srv.eventsMutex.Lock()
+	delete(srv.eventSubscribers, s)
+	srv.eventsMutex.Unlock()
+}
+
+// broadcastEvent entrega o evento a quem assina o tópico. Assinantes lentos
+// são removidos e desconectados (backpressure), para não travar os demais.
+func (srv *Server) broadcastEvent(ev Event) {
+	srv.eventsMutex.Lock()
+	slow := []eventSubscriber{}
+	for s := range srv.eventSubscribers {
+		if s.wants(ev.Topic) && !s.deliver(ev) {
+			slow = append(slow, s)
+			delete(srv.eventSubscribers, s)
+		}
+	}
+	srv.eventsMutex.Unlock()
+
+	for _, s := range slow {
+		s.drop()
+	}
+}
+
+// decodeEvent interpreta uma publicação do broker.
+func decodeEvent(topic string, payload []byte) (Event, bool) {
+	kind, name, ok := topics.Parse(topic)
+	if !ok {
+		return Event{}, false
+	}
+	var tree interface{}
+	if err := msgpack.Unmarshal(payload, &tree); err != nil {
+		return Event{}, false
+	}
+	content, ok := normalizeTree(tree).(map[string]interface{})
+	if !ok {
+		return Event{}, false
+	}
+
+	ev := Event{Topic: topic, Kind: kind, Name: name, Data: content}
+	ev.ID, _ = content["id"].(string)
+	switch c := content["clock"].(type) {
+	case int64:
+		ev.Clock = c
+	case uint64:
+		ev.Clock = int64(c)
+	}
+	return ev, true
+}
+
+// startEventRelay assina o broker (BROKER_SUB_URL, padrão tcp://broker:5558)
+// e repassa as publicações ao hub. Roda só quando algum gateway precisa.
+func (srv *Server) startEventRelay() {
+	brokerSubURL := srv.cfg.BrokerSubURL
+
+	srv.spawn(func() {
+		for srv.running() {
+			if err := srv.relayEvents(brokerSubURL); err != nil {
+				log.Printf("❌ Assinatura do broker interrompida: %v", err)
+			}
+			srv.sleep(time.Second)
+		}
+	})
+}
+
+func (srv *Server) relayEvents(brokerSubURL string) error {
+	sub, err := zmq.NewSocket(zmq.SUB)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	if err := sub.Connect(brokerSubURL); err != nil {
+		return err
+	}
+	sub.SetSubscribe(topics.ChannelPrefix)
+	sub.SetSubscribe(topics.UserPrefix)
+	log.Printf("📡 Gateways assinando o broker em %s", brokerSubURL)
+
+	poller := zmq.NewPoller()
+	poller.Add(sub, zmq.POLLIN)
+
+	for srv.running() {
+		polled, err := poller.Poll(pollInterval)
+		if err != nil {
+			return err
+		}
+		if len(polled) == 0 {
+			continue
+		}
+		frames, err := sub.RecvMessageBytes(0)
+		if err != nil {
+			return err
+		}
+		if len(frames) < 2 {
+			continue
+		}
+		if ev, ok := decodeEvent(string(frames[0]), frames[len(frames)-1]); ok {
+			srv.broadcastEvent(ev)
+		}
+	}
+	return nil
+}
+
+// replayEvents monta, a partir do histórico persistido, as mensagens com
+// relógio maior que cursor nos canais pedidos e as diretas para user.
+// Ordena por relógio e devolve no máximo limit eventos (truncated indica corte).
+func (srv *Server) replayEvents(user string, channels []string, cursor int64, limit int) (events []Event, truncated bool) {
+	wanted := make(map[string]bool, len(channels))
+	for _, c := range channels {
+		wanted[c] = true
+	}
+
+	srv.dataMutex.Lock()
+	for _, cm := range srv.data.ChannelMessages {
+		if wanted[cm.Channel] && cm.Clock > cursor && !cm.Deleted {
+			events = appendStoredEvent(events, topics.Channel(cm.Channel), Publication{
+				ID: cm.ID, ParentID: cm.ParentID, User: cm.User, Message: cm.Message,
+				Attachments: cm.Attachments, Timestamp: cm.Timestamp, Clock: cm.Clock,
+			})
+		}
+	}
+	if user != "" {
+		for _, um := range srv.data.UserMessages {
+			if um.Dst == user && um.Clock > cursor && !um.Deleted {
+				events = appendStoredEvent(events, topics.User(user), DirectMessage{
+					ID: um.ID, From: um.Src, Message: um.Message,
+					Attachments: um.Attachments, Timestamp: um.Timestamp, Clock: um.Clock,
+				})
+			}
+		}
+	}
+	srv.dataMutex.Unlock()
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Clock < events[j].Clock })
+	if len(events) > limit {
+		// Ficam os mais recentes; o cliente usa history para o restante
+		events, truncated = events[len(events)-limit:], true
+	}
+	return events, truncated
+}
+
+// replayKey identifica um evento reenviado. Só mensagens são reenviadas, mas
+// edições, reações e confirmações levam o ID da mensagem alvo; por isso a
+// chave inclui o tipo do evento (vazio numa mensagem).
+type replayKey struct {
+	Event string
+	ID    string
+}
+
+func replayKeyOf(data map[string]interface{}) replayKey {
+	event, _ := data["event"].(string)
+	id, _ := data["id"].(string)
+	return replayKey{event, id}
+}
+
+// replaySet guarda os eventos já reenviados, para descartar a cópia que
+// chegar também ao vivo.
+type replaySet map[replayKey]bool
+
+func (s replaySet) add(data map[string]interface{}) {
+	if k := replayKeyOf(data); k.ID != "" {
+		s[k] = true
+	}
+}
+
+// seen informa se o evento já foi reenviado. Cada um chega ao vivo no
+// máximo uma vez, então a entrada é esquecida.
+func (s replaySet) seen(data map[string]interface{}) bool {
+	k := replayKeyOf(data)
+	if k.ID == "" || !s[k] {
+		return false
+	}
+	delete(s, k)
+	return true
+}
+
+// appendStoredEvent monta o evento de uma mensagem guardada passando pelo
+// mesmo caminho das publicações ao vivo, para que os dois tenham o mesmo formato.
+func appendStoredEvent(events []Event, topic string, publication interface{}) []Event {
+	payload, err := msgpack.Marshal(publication)
+	if err != nil {
+		return events
+	}
+	if ev, ok := decodeEvent(topic, payload); ok {
+		events = append(events, ev)
+	}
+	return events
+}
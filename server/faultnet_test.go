@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Rede com injeção de falhas
+// ----------------------------
+
+// faultNet embrulha as conexões entre servidores (e com a referência) do
+// cluster de teste. Cada mensagem, requisição ou resposta, passa pelas
+// políticas na ordem em que foram adicionadas; a primeira que devolver uma
+// falha decide o que acontece com ela:
+//
+//	c.net.Add(onLink("a", "b", "replicate", fault{Drop: true}))
+//	c.net.Add(between("a", "c", fault{Delay: 300 * time.Millisecond}))
+//	c.net.Add(randomly(0.2, 1, everywhere(fault{Duplicate: true})))
+//
+// Uma requisição perdida (ou cuja resposta se perdeu) faz o RecvBytes do
+// remetente expirar, como numa queda de rede.
+
+// faultMsg descreve uma mensagem em trânsito. Numa resposta, From é quem
+// responde e To quem fez a requisição.
+type faultMsg struct {
+	From, To string
+	Service  string
+	Reply    bool
+}
+
+// fault é o que acontece com uma mensagem; o valor zero a entrega normalmente.
+type fault struct {
+	Drop      bool
+	Delay     time.Duration
+	Duplicate bool // entrega uma cópia extra da requisição (a resposta da cópia é descartada)
+	Reorder   bool // segura a mensagem até a próxima do mesmo sentido passar (no máximo holdMax)
+}
+
+func (f fault) zero() bool {
+	return f == fault{}
+}
+
+// faultPolicy decide o destino de cada mensagem.
+type faultPolicy func(m faultMsg) fault
+
+// holdMax limita quanto uma mensagem reordenada espera pela seguinte.
+const holdMax = 2 * time.Second
+
+var errFaultTimeout = errors.New("faultnet: tempo de resposta esgotado")
+
+type faultNet struct {
+	inner Transport
+
+	mu       sync.Mutex
+	policies []faultPolicy
+	held     map[[2]string][]chan struct{} // mensagens reordenadas por sentido (from, to)
+	counts   map[[2]string]int             // {tipo de falha, serviço} -> ocorrências
+}
+
+func newFaultNet(inner Transport) *faultNet {
+	return &faultNet{
+		inner:  inner,
+		held:   make(map[[2]string][]chan struct{}),
+		counts: make(map[[2]string]int),
+	}
+}
+
+// Add acrescenta uma política.
+func (n *faultNet) Add(p faultPolicy) {
+	n.mu.Lock()
+	n.policies = append(n.policies, p)
+	n.mu.Unlock()
+}
+
+// Clear remove todas as políticas e libera as mensagens seguradas.
+func (n *faultNet) Clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.policies = nil
+	for link, waiting := range n.held {
+		for _, release := range waiting {
+			close(release)
+		}
+		delete(n.held, link)
+	}
+}
+
+// Count devolve quantas vezes a falha kind ("drop", "delay", "duplicate",
+// "reorder") atingiu service ("" = qualquer um).
+func (n *faultNet) Count(kind, service string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	total := 0
+	for key, v := range n.counts {
+		if key[0] == kind && (service == "" || key[1] == service) {
+			total += v
+		}
+	}
+	return total
+}
+
+func (n *faultNet) decide(m faultMsg) fault {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, p := range n.policies {
+		f := p(m)
+		if f.zero() {
+			continue
+		}
+		for kind, on := range map[string]bool{"drop": f.Drop, "delay": f.Delay > 0, "duplicate": f.Duplicate && !m.Reply, "reorder": f.Reorder} {
+			if on {
+				n.counts[[2]string{kind, m.Service}]++
+			}
+		}
+		return f
+	}
+	return fault{}
+}
+
+// hold devolve um canal fechado quando a próxima mensagem de from para to
+// passar.
+func (n *faultNet) hold(from, to string) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	release := make(chan struct{})
+	link := [2]string{from, to}
+	n.held[link] = append(n.held[link], release)
+	return release
+}
+
+// passed libera as mensagens seguradas de from para to.
+func (n *faultNet) passed(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	link := [2]string{from, to}
+	for _, release := range n.held[link] {
+		close(release)
+	}
+	delete(n.held, link)
+}
+
+// wait aplica a reordenação e o atraso de f antes da entrega.
+func (n *faultNet) wait(f fault, m faultMsg) {
+	if f.Reorder {
+		select {
+		case <-n.hold(m.From, m.To):
+		case <-time.After(holdMax):
+		}
+	}
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+}
+
+// delivered registra a entrega de m; se ela não foi reordenada, libera as que
+// esperavam por ela.
+func (n *faultNet) delivered(f fault, m faultMsg) {
+	if !f.Reorder {
+		n.passed(m.From, m.To)
+	}
+}
+
+// transport devolve o Transport de um servidor do cluster.
+func (n *faultNet) transport() Transport {
+	return faultTransport{n}
+}
+
+type faultTransport struct{ net *faultNet }
+
+func (t faultTransport) Dial(from, to, url string) (PeerConn, error) {
+	inner, err := t.net.inner.Dial(from, to, url)
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{
+		net:     t.net,
+		from:    from,
+		to:      to,
+		dial:    func() (PeerConn, error) { return t.net.inner.Dial(from, to, url) },
+		inner:   inner,
+		timeout: peerTimeout,
+	}, nil
+}
+
+// faultConn entrega cada requisição numa goroutine, que aplica as falhas dos
+// dois sentidos e deixa a resposta (se houver) em reply. O socket de baixo
+// só é usado por uma entrega por vez.
+type faultConn struct {
+	net      *faultNet
+	from, to string
+	dial     func() (PeerConn, error)
+
+	mu      sync.Mutex // guarda inner
+	inner   PeerConn
+	timeout time.Duration
+	reply   chan []byte
+}
+
+func (c *faultConn) SendBytes(data []byte, flags zmq.Flag) (int, error) {
+	req := faultMsg{From: c.from, To: c.to, Service: serviceOf(data)}
+	f := c.net.decide(req)
+
+	reply := make(chan []byte, 1)
+	c.reply = reply
+	if f.Drop {
+		return len(data), nil
+	}
+	if f.Duplicate {
+		go c.deliverCopy(data)
+	}
+	go func() {
+		c.net.wait(f, req)
+
+		c.mu.Lock()
+		_, err := c.inner.SendBytes(data, 0)
+		c.net.delivered(f, req)
+		var resp []byte
+		if err == nil {
+			resp, err = c.inner.RecvBytes(0)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+
+		back := faultMsg{From: c.to, To: c.from, Service: req.Service, Reply: true}
+		rf := c.net.decide(back)
+		if rf.Drop {
+			return
+		}
+		c.net.wait(rf, back)
+		reply <- resp
+		c.net.delivered(rf, back)
+	}()
+	return len(data), nil
+}
+
+// deliverCopy entrega data por uma conexão à parte, como uma retransmissão
+// que o destino não consegue distinguir da original.
+func (c *faultConn) deliverCopy(data []byte) {
+	dup, err := c.dial()
+	if err != nil {
+		return
+	}
+	defer dup.Close()
+	if _, err := dup.SendBytes(data, 0); err == nil {
+		dup.RecvBytes(0)
+	}
+}
+
+func (c *faultConn) RecvBytes(flags zmq.Flag) ([]byte, error) {
+	if c.reply == nil {
+		return nil, errors.New("faultnet: RecvBytes sem SendBytes")
+	}
+	select {
+	case resp := <-c.reply:
+		return resp, nil
+	case <-time.After(c.timeout):
+		return nil, errFaultTimeout
+	}
+}
+
+func (c *faultConn) SetRcvtimeo(timeout time.Duration) error {
+	c.timeout = timeout
+	return nil
+}
+
+// Close fecha o socket de baixo depois da entrega em andamento (limitada por
+// peerTimeout).
+func (c *faultConn) Close() error {
+	go func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.inner.Close()
+	}()
+	return nil
+}
+
+func serviceOf(data []byte) string {
+	var m struct {
+		Service string `msgpack:"service"`
+	}
+	msgpack.Unmarshal(data, &m)
+	return m.Service
+}
+
+// ----------------------------
+// Políticas
+// ----------------------------
+
+// everywhere aplica f a todas as mensagens.
+func everywhere(f fault) faultPolicy {
+	return func(faultMsg) fault { return f }
+}
+
+// onLink aplica f às requisições de from para to ("" = qualquer nó) do
+// serviço indicado ("" = qualquer um), e às respostas delas.
+func onLink(from, to, service string, f fault) faultPolicy {
+	return func(m faultMsg) fault {
+		src, dst := m.From, m.To
+		if m.Reply {
+			src, dst = dst, src
+		}
+		if (from == "" || from == src) && (to == "" || to == dst) && (service == "" || service == m.Service) {
+			return f
+		}
+		return fault{}
+	}
+}
+
+// between aplica f nos dois sentidos entre a e b.
+func between(a, b string, f fault) faultPolicy {
+	ab, ba := onLink(a, b, "", f), onLink(b, a, "", f)
+	return func(m faultMsg) fault {
+		if r := ab(m); !r.zero() {
+			return r
+		}
+		return ba(m)
+	}
+}
+
+// partition perde tudo entre nós de grupos diferentes. Nós fora dos grupos
+// (inclusive a referência, se não for listada) falam com todos.
+func partition(groups ...[]string) faultPolicy {
+	group := make(map[string]int)
+	for i, g := range groups {
+		for _, name := range g {
+			group[name] = i + 1
+		}
+	}
+	return func(m faultMsg) fault {
+		gf, gt := group[m.From], group[m.To]
+		if gf != 0 && gt != 0 && gf != gt {
+			return fault{Drop: true}
+		}
+		return fault{}
+	}
+}
+
+// randomly aplica p a uma fração das mensagens, com sorteio reprodutível.
+func randomly(fraction float64, seed int64, p faultPolicy) faultPolicy {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(seed))
+	return func(m faultMsg) fault {
+		mu.Lock()
+		hit := rng.Float64() < fraction
+		mu.Unlock()
+		if !hit {
+			return fault{}
+		}
+		return p(m)
+	}
+}
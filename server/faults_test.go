@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"server/client"
+)
+
+// Eleição e replicação com falhas injetadas pela rede de teste
+// (faultnet_test.go).
+
+const testAdminToken = "token-de-teste"
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// historyIDs devolve os IDs do histórico do canal, em ordem; ok é false se
+// o servidor ainda não conhece o canal.
+func historyIDs(ctx context.Context, cl *client.Client, channel string) (ids []string, ok bool) {
+	msgs, err := cl.History(ctx, channel, 100, "")
+	if err != nil {
+		return nil, false
+	}
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	return ids, true
+}
+
+// setupChannel cria o usuário e o canal em node e espera todos os servidores
+// conhecerem o canal.
+func setupChannel(t *testing.T, c *cluster, node, user, channel string) {
+	t.Helper()
+	ctx := testContext(t)
+	cl := c.Client(node)
+	if err := cl.Login(ctx, user); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if err := cl.CreateChannel(ctx, channel, client.ChannelOptions{Owner: user}); err != nil {
+		t.Fatalf("canal: %v", err)
+	}
+	for _, name := range c.names {
+		replica := c.Client(name)
+		c.Eventually(10*time.Second, name+" deveria conhecer o canal "+channel, func() bool {
+			_, ok := historyIDs(ctx, replica, channel)
+			return ok
+		})
+	}
+}
+
+// publishN publica n mensagens em node e devolve os IDs.
+func publishN(t *testing.T, c *cluster, node, user, channel string, n int) []string {
+	t.Helper()
+	ctx := testContext(t)
+	cl := c.Client(node)
+	ids := make([]string, n)
+	for i := range ids {
+		id, err := cl.Publish(ctx, user, channel, fmt.Sprintf("mensagem %d", i))
+		if err != nil {
+			t.Fatalf("publicação %d: %v", i, err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// waitHistory espera o histórico do canal em node ter n mensagens.
+func waitHistory(t *testing.T, c *cluster, node, channel string, n int) []string {
+	t.Helper()
+	ctx := testContext(t)
+	cl := c.Client(node)
+	var ids []string
+	c.Eventually(10*time.Second, fmt.Sprintf("%s deveria ter %d mensagens em %s", node, n, channel), func() bool {
+		ids, _ = historyIDs(ctx, cl, channel)
+		return len(ids) >= n
+	})
+	return ids
+}
+
+func TestElectionMajorityReplacesUnreachableCoordinator(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+
+	// c continua no ar e na lista da referência, mas a e b não o alcançam
+	c.Partition([]string{"a", "b"}, []string{"c"})
+	c.Eventually(10*time.Second, "a e b deveriam eleger b", func() bool {
+		return c.Coordinator("a") == "b" && c.Coordinator("b") == "b"
+	})
+	c.Consistently(3*time.Second, "a e b deveriam continuar com b", func() bool {
+		return c.Coordinator("a") == "b" && c.Coordinator("b") == "b"
+	})
+	if c.net.Count("drop", "election") == 0 {
+		t.Error("nenhum pedido de eleição para c foi perdido")
+	}
+
+	// Curada a partição, c volta a ser adotado pela checagem feita a cada 10
+	// requisições
+	c.Heal()
+	ctx := testContext(t)
+	ca, cb := c.Client("a"), c.Client("b")
+	c.Eventually(15*time.Second, "a e b deveriam voltar a c", func() bool {
+		ca.Users(ctx)
+		cb.Users(ctx)
+		return c.Coordinator("a") == "c" && c.Coordinator("b") == "c"
+	})
+}
+
+func TestElectionToleratesSlowCoordinator(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+
+	// Atraso abaixo do timeout da checagem de saúde (500ms ida e volta)
+	c.net.Add(between("a", "c", fault{Delay: 150 * time.Millisecond}))
+	c.net.Add(between("b", "c", fault{Delay: 150 * time.Millisecond}))
+	c.Consistently(3*time.Second, "ninguém deveria substituir c", func() bool {
+		return c.Coordinator("a") == "c" && c.Coordinator("b") == "c"
+	})
+	if c.net.Count("delay", "heartbeat") == 0 {
+		t.Error("nenhuma checagem de saúde foi atrasada")
+	}
+}
+
+func TestElectionAfterCoordinatorCrashReachesAll(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+
+	c.Kill("c")
+	c.Eventually(10*time.Second, "a e b deveriam concordar em b", func() bool {
+		return c.Coordinator("a") == "b" && c.Coordinator("b") == "b"
+	})
+}
+
+func TestReplicationReachesAllReplicas(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+
+	setupChannel(t, c, "a", "alice", "geral")
+	ids := publishN(t, c, "a", "alice", "geral", 3)
+	for _, node := range []string{"b", "c"} {
+		if got := waitHistory(t, c, node, "geral", len(ids)); len(got) != len(ids) {
+			t.Errorf("%s: %d mensagens, esperadas %d", node, len(got), len(ids))
+		}
+	}
+}
+
+func TestReplicationUnderPartitionNeedsResync(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", testAdminToken)
+	c := newCluster(t, "a", "b", "c")
+	setupChannel(t, c, "a", "alice", "geral")
+
+	c.Partition([]string{"a"}, []string{"b"})
+	before := publishN(t, c, "a", "alice", "geral", 2)
+	waitHistory(t, c, "c", "geral", len(before))
+	c.Eventually(10*time.Second, "a replicação para b deveria ser perdida", func() bool {
+		return c.net.Count("drop", "replicate") >= len(before)
+	})
+	ctx := testContext(t)
+	if got, _ := historyIDs(ctx, c.Client("b"), "geral"); len(got) != 0 {
+		t.Fatalf("b recebeu %v através da partição", got)
+	}
+
+	// Depois da cura, só as escritas novas chegam a b
+	c.Heal()
+	after := publishN(t, c, "a", "alice", "geral", 1)
+	if got := waitHistory(t, c, "b", "geral", 1); len(got) != 1 || got[0] != after[0] {
+		t.Fatalf("b deveria ter só a mensagem nova, tem %v", got)
+	}
+
+	// admin_resync a partir de c traz o que b perdeu
+	if err := c.Client("b").Resync(ctx, testAdminToken, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitHistory(t, c, "b", "geral", 3); len(got) != 3 {
+		t.Errorf("b após o resync: %v", got)
+	}
+}
+
+func TestReplicationIgnoresDuplicates(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+	setupChannel(t, c, "a", "alice", "geral")
+
+	c.net.Add(onLink("a", "", "replicate", fault{Duplicate: true}))
+	ids := publishN(t, c, "a", "alice", "geral", 5)
+
+	for _, node := range []string{"b", "c"} {
+		waitHistory(t, c, node, "geral", len(ids))
+		// As cópias chegam por conexões próprias, logo depois das originais
+		time.Sleep(300 * time.Millisecond)
+		got, _ := historyIDs(testContext(t), c.Client(node), "geral")
+		seen := make(map[string]bool)
+		for _, id := range got {
+			if seen[id] {
+				t.Errorf("%s: mensagem %s duplicada", node, id)
+			}
+			seen[id] = true
+		}
+		if len(got) != len(ids) {
+			t.Errorf("%s: %d mensagens, esperadas %d", node, len(got), len(ids))
+		}
+	}
+	if c.net.Count("duplicate", "replicate") == 0 {
+		t.Error("nenhuma réplica foi duplicada")
+	}
+}
+
+func TestReplicationSurvivesReordering(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+	setupChannel(t, c, "a", "alice", "geral")
+
+	c.net.Add(randomly(0.5, 7, onLink("a", "b", "replicate", fault{Reorder: true})))
+	ids := publishN(t, c, "a", "alice", "geral", 6)
+
+	// A réplica reordenada entra na posição do canal, não na de chegada: b
+	// mostra as mensagens na mesma ordem que a
+	got := waitHistory(t, c, "b", "geral", len(ids))
+	want, ok := historyIDs(testContext(t), c.Client("a"), "geral")
+	if !ok {
+		t.Fatal("histórico de a indisponível")
+	}
+	if !reflect.DeepEqual(want, ids) {
+		t.Errorf("a: ordem %v, publicadas %v", want, ids)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("b: ordem %v, a: %v", got, want)
+	}
+	if c.net.Count("reorder", "replicate") == 0 {
+		t.Error("nenhuma réplica foi reordenada")
+	}
+}
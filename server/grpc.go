@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"server/chatpb"
+	"server/topics"
+)
+
+// ----------------------------
+// API gRPC (proto/chat.proto)
+// ----------------------------
+
+// Cada RPC monta a mesma requisição do socket ZeroMQ e passa por
+// processRequest, como o gateway REST. Subscribe usa o hub de eventos do
+// gateway WebSocket.
+
+const (
+	defaultGRPCAddr  = ":50051"
+	grpcStreamBuffer = 256 // eventos em fila por Subscribe antes de encerrar
+)
+
+// grpcCodes traduz os códigos de erro do envelope em status gRPC.
+var grpcCodes = map[string]codes.Code{
+	ErrInvalidPayload:     codes.InvalidArgument,
+	ErrUnknownService:     codes.Unimplemented,
+	ErrUnsupportedVersion: codes.InvalidArgument,
+	ErrInvalidName:        codes.InvalidArgument,
+	ErrEmptyMessage:       codes.InvalidArgument,
+	ErrInvalidArgument:    codes.InvalidArgument,
+	ErrMessageTooLarge:    codes.InvalidArgument,
+	ErrFieldTooLarge:      codes.InvalidArgument,
+	ErrPayloadTooLarge:    codes.InvalidArgument,
+	ErrForbidden:          codes.PermissionDenied,
+	ErrUserNotFound:       codes.NotFound,
+	ErrChannelNotFound:    codes.NotFound,
+	ErrMessageNotFound:    codes.NotFound,
+	ErrAttachmentNotFound: codes.FailedPrecondition,
+	ErrUserExists:         codes.AlreadyExists,
+	ErrChannelExists:      codes.AlreadyExists,
+	ErrNameConflict:       codes.AlreadyExists,
+	ErrChannelArchived:    codes.FailedPrecondition,
+	ErrMessageDeleted:     codes.FailedPrecondition,
+	ErrRateLimited:        codes.ResourceExhausted,
+	ErrBrokerUnavailable:  codes.Unavailable,
+	ErrStorage:            codes.Internal,
+	ErrInternal:           codes.Internal,
+}
+
+// grpcError monta o status gRPC de um erro do envelope, com o código em
+// ErrorInfo.Reason e, para rate_limited, o tempo de espera em RetryInfo.
+func grpcError(info *ErrorInfo, retryAfter float64) error {
+	code, ok := grpcCodes[info.Code]
+	if !ok {
+		code = codes.Internal
+	}
+	st := status.New(code, info.Message)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: info.Code, Domain: "chat"}}
+	if retryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(time.Duration(retryAfter * float64(time.Second))),
+		})
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// grpcCall executa um serviço e decodifica o data da resposta em out.
+func (srv *Server) grpcCall(ctx context.Context, service string, req interface{}, out interface{}) error {
+	msg, err := msgpack.Marshal(req)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	conn := "grpc"
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, _ := net.SplitHostPort(p.Addr.String())
+		conn = "grpc:" + host
+	}
+	response := srv.processRequest(service, conn, msg)
+
+	envelope, err := wrapResponse(srv.requestID(msg), response)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	var env Envelope
+	if err := msgpack.Unmarshal(envelope, &env); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if env.Error != nil {
+		var data struct {
+			RetryAfter float64 `msgpack:"retry_after"`
+		}
+		msgpack.Unmarshal(env.Data, &data)
+		return grpcError(env.Error, data.RetryAfter)
+	}
+	if err := msgpack.Unmarshal(env.Data, out); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+type chatServer struct {
+	chatpb.UnimplementedChatServer
+	srv *Server
+}
+
+func (c chatServer) Login(ctx context.Context, in *chatpb.LoginRequest) (*chatpb.LoginReply, error) {
+	var req LoginRequest
+	req.Service = "login"
+	req.Data.User = in.User
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = in.Clock
+
+	var resp LoginResponse
+	if err := c.srv.grpcCall(ctx, "login", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	return &chatpb.LoginReply{Timestamp: resp.Data.Timestamp, Clock: resp.Data.Clock}, nil
+}
+
+func (c chatServer) Users(ctx context.Context, in *chatpb.UsersRequest) (*chatpb.UsersReply, error) {
+	var req UsersRequest
+	req.Service = "users"
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = in.Clock
+
+	var resp UsersResponse
+	if err := c.srv.grpcCall(ctx, "users", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	return &chatpb.UsersReply{Users: resp.Data.Users, Timestamp: resp.Data.Timestamp, Clock: resp.Data.Clock}, nil
+}
+
+func (c chatServer) CreateChannel(ctx context.Context, in *chatpb.ChannelRequest) (*chatpb.ChannelReply, error) {
+	var req ChannelRequest
+	req.Service = "channel"
+	req.Data.Channel = in.Channel
+	req.Data.User = in.User
+	req.Data.Topic = in.Topic
+	req.Data.Description = in.Description
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = in.Clock
+
+	var resp ChannelResponse
+	if err := c.srv.grpcCall(ctx, "channel", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	return &chatpb.ChannelReply{Timestamp: resp.Data.Timestamp, Clock: resp.Data.Clock}, nil
+}
+
+func (c chatServer) Channels(ctx context.Context, in *chatpb.ChannelsRequest) (*chatpb.ChannelsReply, error) {
+	var req ChannelsRequest
+	req.Service = "channels"
+	req.Data.Filter = in.Filter
+	req.Data.Sort = in.Sort
+	req.Data.IncludeArchived = in.IncludeArchived
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = in.Clock
+
+	var resp ChannelsResponse
+	if err := c.srv.grpcCall(ctx, "channels", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	reply := &chatpb.ChannelsReply{Timestamp: resp.Data.Timestamp, Clock: resp.Data.Clock}
+	for _, info := range resp.Data.Info {
+		reply.Channels = append(reply.Channels, &chatpb.ChannelInfo{
+			Name:         info.Name,
+			Topic:        info.Topic,
+			Description:  info.Description,
+			Owner:        info.Owner,
+			CreatedAt:    info.CreatedAt,
+			Archived:     info.Archived,
+			MessageCount: int64(info.MessageCount),
+			LastActivity: info.LastActivity,
+		})
+	}
+	return reply, nil
+}
+
+func (c chatServer) Publish(ctx context.Context, in *chatpb.PublishRequest) (*chatpb.PublishReply, error) {
+	var req PublishRequest
+	req.Service = "publish"
+	req.Data.User = in.User
+	req.Data.Channel = in.Channel
+	req.Data.Message = in.Message
+	req.Data.Attachments = in.Attachments
+	req.Data.ParentID = in.ParentId
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = in.Clock
+
+	var resp PublishResponse
+	if err := c.srv.grpcCall(ctx, "publish", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	return &chatpb.PublishReply{Id: resp.Data.ID, Timestamp: resp.Data.Timestamp, Clock: resp.Data.Clock}, nil
+}
+
+func (c chatServer) SendMessage(ctx context.Context, in *chatpb.MessageRequest) (*chatpb.MessageReply, error) {
+	var req MessageRequest
+	req.Service = "message"
+	req.Data.Src = in.Src
+	req.Data.Dst = in.Dst
+	req.Data.Message = in.Message
+	req.Data.Attachments = in.Attachments
+	req.Data.Timestamp = time.Now().Unix()
+	req.Data.Clock = in.Clock
+
+	var resp MessageResponse
+	if err := c.srv.grpcCall(ctx, "message", &req, &resp.Data); err != nil {
+		return nil, err
+	}
+	return &chatpb.MessageReply{Id: resp.Data.ID, Timestamp: resp.Data.Timestamp, Clock: resp.Data.Clock}, nil
+}
+
+// grpcSubscriber liga um stream Subscribe ao hub de eventos.
+type grpcSubscriber struct {
+	topics  map[string]bool // fixo durante o stream
+	events  chan Event
+	slow    chan struct{}
+	closing sync.Once
+}
+
+func (s *grpcSubscriber) wants(topic string) bool { return s.topics[topic] }
+
+func (s *grpcSubscriber) deliver(ev Event) bool {
+	select {
+	case s.events <- ev:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *grpcSubscriber) drop() {
+	s.closing.Do(func() { close(s.slow) })
+}
+
+// eventProto converte um evento do hub na mensagem do stream.
+func eventProto(ev Event, replay bool) *chatpb.Event {
+	out := &chatpb.Event{Kind: ev.Kind, Name: ev.Name, Replay: replay, Id: ev.ID, Clock: ev.Clock}
+	out.User, _ = ev.Data["user"].(string)
+	if out.User == "" {
+		out.User, _ = ev.Data["from"].(string)
+	}
+	out.Message, _ = ev.Data["message"].(string)
+	out.ParentId, _ = ev.Data["parent_id"].(string)
+	if ts, ok := ev.Data["timestamp"].(int64); ok {
+		out.Timestamp = ts
+	}
+	if list, ok := ev.Data["attachments"].([]interface{}); ok {
+		for _, item := range list {
+			if hash, ok := item.(string); ok {
+				out.Attachments = append(out.Attachments, hash)
+			}
+		}
+	}
+	if data, err := structpb.NewStruct(ev.Data); err == nil {
+		out.Data = data
+	}
+	return out
+}
+
+// truncatedReplayEvent avisa que o replay foi cortado em wsReplayLimit
+// (ficaram os mais recentes); o restante vem por history.
+func truncatedReplayEvent() *chatpb.Event {
+	data, _ := structpb.NewStruct(map[string]interface{}{
+		"event":   "replay_truncated",
+		"message": "Cursor antigo demais; use history para o restante",
+	})
+	return &chatpb.Event{Replay: true, Data: data}
+}
+
+func (c chatServer) Subscribe(in *chatpb.SubscribeRequest, stream chatpb.Chat_SubscribeServer) error {
+	c.srv.dataMutex.Lock()
+	exists := c.srv.userExists(in.User)
+	missing := ""
+	for _, name := range in.Channels {
+		if !c.srv.channelExists(name) {
+			missing = name
+			break
+		}
+	}
+	c.srv.dataMutex.Unlock()
+	if !exists {
+		return grpcError(&ErrorInfo{Code: ErrUserNotFound, Message: "Usuário não existe"}, 0)
+	}
+	if missing != "" {
+		return grpcError(&ErrorInfo{Code: ErrChannelNotFound, Message: "Canal não existe: " + missing}, 0)
+	}
+
+	s := &grpcSubscriber{
+		topics: map[string]bool{topics.User(in.User): true},
+		events: make(chan Event, grpcStreamBuffer),
+		slow:   make(chan struct{}),
+	}
+	for _, name := range in.Channels {
+		s.topics[topics.Channel(name)] = true
+	}
+
+	// Assinar antes do replay; eventos repetidos são descartados pelo tipo e ID
+	c.srv.addEventSubscriber(s)
+	defer c.srv.removeEventSubscriber(s)
+
+	replayed := replaySet{}
+	if in.Cursor > 0 {
+		events, truncated := c.srv.replayEvents(in.User, in.Channels, in.Cursor, wsReplayLimit)
+		for _, ev := range events {
+			if err := stream.Send(eventProto(ev, true)); err != nil {
+				return err
+			}
+			replayed.add(ev.Data)
+		}
+		if truncated {
+			if err := stream.Send(truncatedReplayEvent()); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("🔌 Subscribe gRPC de %s (canais: %v, cursor: %d)", in.User, in.Channels, in.Cursor)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.slow:
+			log.Printf("🐢 Subscribe gRPC de %s encerrado: fila cheia", in.User)
+			return status.Error(codes.ResourceExhausted, "slow consumer: reconecte com cursor")
+		case ev := <-s.events:
+			if replayed.seen(ev.Data) {
+				continue
+			}
+			if err := stream.Send(eventProto(ev, false)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// startGRPCServer sobe a API gRPC em GRPC_ADDR (padrão ":50051"; "off"
+// desativa).
+func (srv *Server) startGRPCServer() {
+	addr := srv.cfg.GRPCAddr
+	if addr == "" {
+		addr = defaultGRPCAddr
+	}
+	if addr == "off" {
+		return
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("❌ Erro ao abrir porta gRPC %s: %v", addr, err)
+		return
+	}
+	gs := grpc.NewServer()
+	chatpb.RegisterChatServer(gs, chatServer{srv: srv})
+	srv.grpcServer = gs
+
+	go func() {
+		log.Printf("🛰️  API gRPC escutando em %s", addr)
+		if err := gs.Serve(lis); err != nil {
+			log.Printf("❌ API gRPC encerrada: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Versão do protocolo e handshake ("hello")
+// ----------------------------
+
+// Versões suportadas ao mesmo tempo:
+//
+//	1 - formato original: respostas {service, data}, sem envelope
+//	2 - respostas no envelope (version, request_id, status, error, data)
+//
+// Clientes que não fazem "hello" nem mandam "version" são tratados como v1.
+const MinProtocolVersion = 1
+
+const sessionIdleTimeout = time.Hour
+
+// Funcionalidades anunciadas no hello
+var serverFeatures = []string{
+	"attachments", "channel_admin", "codecs", "envelope", "history", "message_edits",
+	"pending", "rate_limits", "reactions", "receipts", "request_id",
+	"retention", "search", "threads", "topic_namespaces",
+}
+
+type HelloRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Client    string   `msgpack:"client,omitempty"`   // nome/versão do cliente (log)
+		Version   int      `msgpack:"version"`            // versão preferida
+		Versions  []int    `msgpack:"versions,omitempty"` // todas as versões que o cliente entende
+		Features  []string `msgpack:"features,omitempty"` // vazio: aceita todas
+		Codec     string   `msgpack:"codec,omitempty"`    // codec das requisições sem byte de formato
+		Timestamp int64    `msgpack:"timestamp"`
+		Clock     int64    `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type HelloResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string   `msgpack:"status"`
+		Error     string   `msgpack:"error,omitempty"`
+		Message   string   `msgpack:"message,omitempty"`
+		Version   int      `msgpack:"version"`  // versão escolhida (0 se incompatível)
+		Versions  []int    `msgpack:"versions"` // versões que o servidor suporta
+		Features  []string `msgpack:"features"`
+		Codec     string   `msgpack:"codec"`
+		Codecs    []string `msgpack:"codecs"` // msgpack, json, protobuf
+		Server    string   `msgpack:"server"`
+		Timestamp int64    `msgpack:"timestamp"`
+		Clock     int64    `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Sessão negociada por conexão (identidade do ROUTER)
+type clientSession struct {
+	version  int
+	features []string
+	codec    byte // formato das requisições sem byte inicial
+	lastSeen time.Time
+}
+
+func supportedVersions() []int {
+	versions := []int{}
+	for v := ProtocolVersion; v >= MinProtocolVersion; v-- {
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+func versionSupported(v int) bool {
+	return v >= MinProtocolVersion && v <= ProtocolVersion
+}
+
+// negotiateVersion escolhe a maior versão comum; 0 se não houver.
+func negotiateVersion(preferred int, accepted []int) int {
+	if len(accepted) == 0 {
+		accepted = []int{preferred}
+	}
+	best := 0
+	for _, v := range accepted {
+		if versionSupported(v) && v > best {
+			best = v
+		}
+	}
+	return best
+}
+
+// negotiateFeatures devolve as funcionalidades pedidas que o servidor oferece.
+func negotiateFeatures(requested []string) []string {
+	if len(requested) == 0 {
+		return serverFeatures
+	}
+	offered := make(map[string]bool, len(serverFeatures))
+	for _, f := range serverFeatures {
+		offered[f] = true
+	}
+	features := []string{}
+	for _, f := range requested {
+		if offered[f] {
+			features = append(features, f)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// clientVersion determina a versão de uma requisição: o campo "version" da
+// própria requisição, senão a versão negociada na conexão, senão 1.
+// ok é false quando a versão pedida não é suportada.
+func (srv *Server) clientVersion(conn string, msg []byte) (version int, ok bool) {
+	var req struct {
+		Version int `msgpack:"version"`
+	}
+	msgpack.Unmarshal(msg, &req)
+	if req.Version != 0 {
+		return req.Version, versionSupported(req.Version)
+	}
+
+	srv.sessionsMutex.Lock()
+	defer srv.sessionsMutex.Unlock()
+	if s, found := srv.sessions[conn]; found {
+		s.lastSeen = time.Now()
+		return s.version, true
+	}
+	return MinProtocolVersion, true
+}
+
+// sessionCodec devolve o codec negociado no hello, se não for o padrão.
+func (srv *Server) sessionCodec(conn string) (byte, bool) {
+	srv.sessionsMutex.Lock()
+	defer srv.sessionsMutex.Unlock()
+	if s, found := srv.sessions[conn]; found && s.codec != formatMsgpack {
+		return s.codec, true
+	}
+	return 0, false
+}
+
+// expireSessions descarta sessões de conexões inativas.
+func (srv *Server) expireSessions() {
+	srv.sessionsMutex.Lock()
+	defer srv.sessionsMutex.Unlock()
+	for conn, s := range srv.sessions {
+		if time.Since(s.lastSeen) > sessionIdleTimeout {
+			delete(srv.sessions, conn)
+		}
+	}
+}
+
+// encodeResponse aplica o formato da versão do cliente à resposta do handler.
+func encodeResponse(version int, requestID string, payload []byte) ([]byte, error) {
+	if version < 2 {
+		return payload, nil
+	}
+	return wrapResponse(requestID, payload)
+}
+
+// unsupportedVersionResponse responde a uma requisição com versão desconhecida.
+// Vai sempre no envelope, que é um superconjunto do formato v1.
+func (srv *Server) unsupportedVersionResponse(service string, version int) []byte {
+	return srv.errorPayload(service, ErrUnsupportedVersion,
+		fmt.Sprintf("Versão de protocolo %d não suportada (suportadas: %d a %d)", version, MinProtocolVersion, ProtocolVersion))
+}
+
+func (srv *Server) handleHello(conn string, msg []byte) ([]byte, error) {
+	var req HelloRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := HelloResponse{Service: "hello"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Versions = supportedVersions()
+	resp.Data.Server = srv.serverName
+	resp.Data.Features = []string{}
+	resp.Data.Codecs = []string{"msgpack", "json", "protobuf"}
+
+	version := negotiateVersion(req.Data.Version, req.Data.Versions)
+	if version == 0 {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrUnsupportedVersion
+		resp.Data.Message = fmt.Sprintf("Nenhuma versão de protocolo em comum (servidor: %d a %d)", MinProtocolVersion, ProtocolVersion)
+		log.Printf("🤝 Hello rejeitado de %s: versões %v / %d", req.Data.Client, req.Data.Versions, req.Data.Version)
+		return msgpack.Marshal(resp)
+	}
+
+	codec := req.Data.Codec
+	if codec == "" {
+		codec = "msgpack"
+	}
+	format, ok := codecByName(codec)
+	if !ok {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidArgument
+		resp.Data.Message = fmt.Sprintf("Codec desconhecido: %s", codec)
+		return msgpack.Marshal(resp)
+	}
+
+	srv.expireSessions()
+	features := negotiateFeatures(req.Data.Features)
+	srv.sessionsMutex.Lock()
+	srv.sessions[conn] = &clientSession{version: version, features: features, codec: format, lastSeen: time.Now()}
+	srv.sessionsMutex.Unlock()
+
+	resp.Data.Status = "OK"
+	resp.Data.Codec = codec
+	resp.Data.Version = version
+	resp.Data.Features = features
+	log.Printf("🤝 Hello de %s: protocolo v%d, codec %s, %d funcionalidades", req.Data.Client, version, codec, len(features))
+
+	return msgpack.Marshal(resp)
+}
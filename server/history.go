@@ -0,0 +1,346 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Histórico de canais e threads
+// ----------------------------
+
+const defaultHistoryLimit = 50
+
+type HistoryRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Channel   string `msgpack:"channel"`
+		Limit     int    `msgpack:"limit,omitempty"`     // padrão: 50
+		BeforeID  string `msgpack:"before_id,omitempty"` // apenas mensagens anteriores a esta na ordem do canal (paginação)
+		Before    int64  `msgpack:"before,omitempty"`    // apenas mensagens com timestamp menor (paginação antiga)
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Mensagem de canal acompanhada do número de respostas na thread e do
+// total de reações por emoji
+type HistoryEntry struct {
+	ChannelMessage `msgpack:",inline"`
+	ReplyCount     int            `msgpack:"reply_count"`
+	ReactionCounts map[string]int `msgpack:"reaction_counts,omitempty"`
+}
+
+type HistoryResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string         `msgpack:"status"`
+		Error     string         `msgpack:"error,omitempty"`
+		Message   string         `msgpack:"message,omitempty"`
+		Channel   string         `msgpack:"channel"`
+		Messages  []HistoryEntry `msgpack:"messages"`
+		Timestamp int64          `msgpack:"timestamp"`
+		Clock     int64          `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ThreadRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		ID        string `msgpack:"id"` // qualquer mensagem da thread
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ThreadResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string           `msgpack:"status"`
+		Error     string           `msgpack:"error,omitempty"`
+		Message   string           `msgpack:"message,omitempty"`
+		Root      *ChannelMessage  `msgpack:"root,omitempty"`
+		Replies   []ChannelMessage `msgpack:"replies"`
+		Timestamp int64            `msgpack:"timestamp"`
+		Clock     int64            `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// threadRoot retorna o ID da raiz da thread a que a mensagem pertence,
+// ou "" se ela não existir no canal. Deve ser chamada com dataMutex travado.
+func (srv *Server) threadRoot(id, channel string) string {
+	for _, cm := range srv.data.ChannelMessages {
+		if cm.ID == id && cm.Channel == channel {
+			if cm.ParentID != "" {
+				return cm.ParentID
+			}
+			return cm.ID
+		}
+	}
+	return ""
+}
+
+// channelHistory retorna as mensagens de primeiro nível do canal, na ordem
+// mantida por insertChannelMessage, com a contagem de respostas. Com
+// beforeID, só as anteriores a essa mensagem; found é false se ela não
+// estiver no canal. Deve ser chamada com dataMutex travado.
+func (srv *Server) channelHistory(channel string, limit int, before int64, beforeID string) (entries []HistoryEntry, found bool) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	replies := make(map[string]int)
+	entries = []HistoryEntry{}
+	cut := len(entries)
+	found = beforeID == ""
+	for _, cm := range srv.data.ChannelMessages {
+		if cm.Channel != channel {
+			continue
+		}
+		if cm.ID == beforeID && !found {
+			found = true
+			cut = len(entries)
+		}
+		if cm.ParentID != "" {
+			replies[cm.ParentID]++
+			continue
+		}
+		if before > 0 && cm.Timestamp >= before {
+			continue
+		}
+		entries = append(entries, HistoryEntry{ChannelMessage: cm})
+	}
+	if beforeID != "" {
+		entries = entries[:cut]
+	}
+
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	for i := range entries {
+		entries[i].ReplyCount = replies[entries[i].ID]
+		if len(entries[i].Reactions) > 0 {
+			entries[i].ReactionCounts = reactionCounts(entries[i].Reactions)
+		}
+	}
+	return entries, found
+}
+
+// insertChannelMessage guarda uma mensagem na posição dada por
+// channelOrderLess, para que todas as réplicas mostrem o canal na mesma
+// ordem, qualquer que seja a ordem de chegada das réplicas e do resync.
+// Publicações locais têm o maior relógio e vão direto para o fim. Supõe a
+// lista já ordenada (normalize, ao carregar). Deve ser chamada com
+// dataMutex travado.
+func (srv *Server) insertChannelMessage(cm ChannelMessage) {
+	msgs := srv.data.ChannelMessages
+	i := len(msgs)
+	for i > 0 && channelOrderLess(cm, msgs[i-1]) {
+		i--
+	}
+	msgs = append(msgs, ChannelMessage{})
+	copy(msgs[i+1:], msgs[i:])
+	msgs[i] = cm
+	srv.data.ChannelMessages = msgs
+}
+
+// sortChannelMessages põe na ordem do canal dados gravados por versões que
+// guardavam as mensagens na ordem de chegada.
+func (d *PersistentData) sortChannelMessages() {
+	sort.SliceStable(d.ChannelMessages, func(i, j int) bool {
+		return channelOrderLess(d.ChannelMessages[i], d.ChannelMessages[j])
+	})
+}
+
+// channelOrderLess é a ordem do canal: relógio lógico, depois timestamp
+// (mensagens antigas têm relógio zero) e ID para desempatar.
+func channelOrderLess(a, b ChannelMessage) bool {
+	if a.Clock != b.Clock {
+		return a.Clock < b.Clock
+	}
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.ID < b.ID
+}
+
+func (srv *Server) handleHistory(msg []byte) ([]byte, error) {
+	var req HistoryRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := HistoryResponse{Service: "history"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Channel = req.Data.Channel
+
+	srv.dataMutex.Lock()
+	defer srv.dataMutex.Unlock()
+
+	if !srv.channelExists(req.Data.Channel) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelNotFound
+		resp.Data.Message = "Canal não existe"
+		return msgpack.Marshal(resp)
+	}
+
+	messages, found := srv.channelHistory(req.Data.Channel, req.Data.Limit, req.Data.Before, req.Data.BeforeID)
+	if !found {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageNotFound
+		resp.Data.Message = "Mensagem de referência da página não está no canal"
+		return msgpack.Marshal(resp)
+	}
+	resp.Data.Status = "OK"
+	resp.Data.Messages = messages
+
+	return msgpack.Marshal(resp)
+}
+
+type DirectHistoryRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"` // um dos lados da conversa
+		With      string `msgpack:"with"` // o outro lado
+		Limit     int    `msgpack:"limit,omitempty"`
+		BeforeID  string `msgpack:"before_id,omitempty"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// directHistory retorna as mensagens diretas entre user e with, na mesma
+// ordem, formato e paginação do histórico de canal (o remetente vai em
+// user). Deve ser chamada com dataMutex travado.
+func (srv *Server) directHistory(user, with string, limit int, beforeID string) (entries []HistoryEntry, found bool) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	entries = []HistoryEntry{}
+	for _, um := range srv.data.UserMessages {
+		if (um.Src == user && um.Dst == with) || (um.Src == with && um.Dst == user) {
+			entries = append(entries, HistoryEntry{ChannelMessage: ChannelMessage{
+				ID: um.ID, User: um.Src, Message: um.Message, Attachments: um.Attachments,
+				Timestamp: um.Timestamp, Clock: um.Clock, Deleted: um.Deleted,
+				History: um.History, Reactions: um.Reactions,
+			}})
+		}
+	}
+	// As diretas ficam na ordem de chegada; a ordenação é feita aqui
+	sort.SliceStable(entries, func(i, j int) bool {
+		return channelOrderLess(entries[i].ChannelMessage, entries[j].ChannelMessage)
+	})
+
+	if beforeID != "" {
+		cut := -1
+		for i := range entries {
+			if entries[i].ID == beforeID {
+				cut = i
+				break
+			}
+		}
+		if cut < 0 {
+			return nil, false
+		}
+		entries = entries[:cut]
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	for i := range entries {
+		if len(entries[i].Reactions) > 0 {
+			entries[i].ReactionCounts = reactionCounts(entries[i].Reactions)
+		}
+	}
+	return entries, true
+}
+
+// handleDirectHistory devolve a conversa entre user e with. Não há
+// autenticação de usuário: user é só o que o cliente declara (o hello não
+// carrega identidade e o login não tem senha), então qualquer conexão lê a
+// conversa de quaisquer dois usuários. Amarrar a consulta à sessão não
+// mudaria isso enquanto a própria sessão não provar quem é o usuário.
+func (srv *Server) handleDirectHistory(msg []byte) ([]byte, error) {
+	var req DirectHistoryRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := HistoryResponse{Service: "direct_history"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+
+	srv.dataMutex.Lock()
+	defer srv.dataMutex.Unlock()
+
+	if !srv.userExists(req.Data.User) || !srv.userExists(req.Data.With) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrUserNotFound
+		resp.Data.Message = "Usuário não existe"
+		return msgpack.Marshal(resp)
+	}
+
+	messages, found := srv.directHistory(req.Data.User, req.Data.With, req.Data.Limit, req.Data.BeforeID)
+	if !found {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageNotFound
+		resp.Data.Message = "Mensagem de referência da página não está na conversa"
+		return msgpack.Marshal(resp)
+	}
+	resp.Data.Status = "OK"
+	resp.Data.Messages = messages
+
+	return msgpack.Marshal(resp)
+}
+
+func (srv *Server) handleThread(msg []byte) ([]byte, error) {
+	var req ThreadRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := ThreadResponse{Service: "thread"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Replies = []ChannelMessage{}
+
+	srv.dataMutex.Lock()
+	defer srv.dataMutex.Unlock()
+
+	rootID := ""
+	for _, cm := range srv.data.ChannelMessages {
+		if cm.ID == req.Data.ID {
+			rootID = srv.threadRoot(cm.ID, cm.Channel)
+			break
+		}
+	}
+	if rootID == "" {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageNotFound
+		resp.Data.Message = "Mensagem não encontrada"
+		return msgpack.Marshal(resp)
+	}
+
+	for _, cm := range srv.data.ChannelMessages {
+		switch {
+		case cm.ID == rootID:
+			root := cm
+			resp.Data.Root = &root
+		case cm.ParentID == rootID:
+			resp.Data.Replies = append(resp.Data.Replies, cm)
+		}
+	}
+
+	resp.Data.Status = "OK"
+	return msgpack.Marshal(resp)
+}
@@ -1,188 +1,115 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
 	zmq "github.com/pebbe/zmq4"
 	"github.com/vmihailenco/msgpack/v5"
-)
 
-// Relógio lógico global
-var (
-	logicalClock int64
-	clockMutex   sync.Mutex
+	"server/protocol"
+	"server/topics"
 )
 
 // Funções do relógio lógico
-func incrementClock() int64 {
-	clockMutex.Lock()
-	defer clockMutex.Unlock()
-	logicalClock++
-	return logicalClock
-}
-
-func updateClock(receivedClock int64) int64 {
-	clockMutex.Lock()
-	defer clockMutex.Unlock()
-	if receivedClock > logicalClock {
-		logicalClock = receivedClock
-	}
-	logicalClock++
-	return logicalClock
-}
-
-func getClock() int64 {
-	clockMutex.Lock()
-	defer clockMutex.Unlock()
-	return logicalClock
-}
-
-// Funções para relógio físico ajustado
-func getAdjustedTime() int64 {
-	return time.Now().Unix() + timeOffset
-}
-
-func adjustTime(adjustment int64) {
-	timeOffset += adjustment
-	log.Printf("⏰ Relógio ajustado em %ds (offset total: %ds)", adjustment, timeOffset)
-}
-
-// Estruturas de dados
-type LoginRequest struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		User      string `msgpack:"user"`
-		Timestamp int64  `msgpack:"timestamp"`
-		Clock     int64  `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-type LoginResponse struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Status      string `msgpack:"status"`
-		Timestamp   int64  `msgpack:"timestamp"`
-		Clock       int64  `msgpack:"clock"`
-		Description string `msgpack:"description,omitempty"`
-	} `msgpack:"data"`
-}
-
-type UsersRequest struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Timestamp int64 `msgpack:"timestamp"`
-		Clock     int64 `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-type UsersResponse struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Timestamp int64    `msgpack:"timestamp"`
-		Clock     int64    `msgpack:"clock"`
-		Users     []string `msgpack:"users"`
-	} `msgpack:"data"`
-}
-
-type ChannelRequest struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Channel   string `msgpack:"channel"`
-		Timestamp int64  `msgpack:"timestamp"`
-		Clock     int64  `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-type ChannelResponse struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Status      string `msgpack:"status"`
-		Timestamp   int64  `msgpack:"timestamp"`
-		Clock       int64  `msgpack:"clock"`
-		Description string `msgpack:"description,omitempty"`
-	} `msgpack:"data"`
-}
-
-type ChannelsRequest struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Timestamp int64 `msgpack:"timestamp"`
-		Clock     int64 `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-type ChannelsResponse struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Timestamp int64    `msgpack:"timestamp"`
-		Clock     int64    `msgpack:"clock"`
-		Channels  []string `msgpack:"channels"`
-	} `msgpack:"data"`
-}
-
-// Novas estruturas para Parte 2
-type PublishRequest struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		User      string `msgpack:"user"`
-		Channel   string `msgpack:"channel"`
-		Message   string `msgpack:"message"`
-		Timestamp int64  `msgpack:"timestamp"`
-		Clock     int64  `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-type PublishResponse struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Status    string `msgpack:"status"`
-		Message   string `msgpack:"message,omitempty"`
-		Timestamp int64  `msgpack:"timestamp"`
-		Clock     int64  `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-type MessageRequest struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Src       string `msgpack:"src"`
-		Dst       string `msgpack:"dst"`
-		Message   string `msgpack:"message"`
-		Timestamp int64  `msgpack:"timestamp"`
-		Clock     int64  `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-type MessageResponse struct {
-	Service string `msgpack:"service"`
-	Data    struct {
-		Status    string `msgpack:"status"`
-		Message   string `msgpack:"message,omitempty"`
-		Timestamp int64  `msgpack:"timestamp"`
-		Clock     int64  `msgpack:"clock"`
-	} `msgpack:"data"`
-}
-
-// Estrutura para publicação no broker
-type Publication struct {
-	User      string `msgpack:"user"`
-	Message   string `msgpack:"message"`
-	Timestamp int64  `msgpack:"timestamp"`
-	Clock     int64  `msgpack:"clock"`
-}
-
-type DirectMessage struct {
-	From      string `msgpack:"from"`
-	Message   string `msgpack:"message"`
-	Timestamp int64  `msgpack:"timestamp"`
-	Clock     int64  `msgpack:"clock"`
-}
+func (srv *Server) incrementClock() int64 {
+	srv.clockMutex.Lock()
+	defer srv.clockMutex.Unlock()
+	srv.logicalClock++
+	return srv.logicalClock
+}
+
+func (srv *Server) updateClock(receivedClock int64) int64 {
+	srv.clockMutex.Lock()
+	defer srv.clockMutex.Unlock()
+	if receivedClock > srv.logicalClock {
+		srv.logicalClock = receivedClock
+	}
+	srv.logicalClock++
+	return srv.logicalClock
+}
+
+func (srv *Server) getClock() int64 {
+	srv.clockMutex.Lock()
+	defer srv.clockMutex.Unlock()
+	return srv.logicalClock
+}
+
+// Coordenador atual. É lido e escrito pelas rotinas de fundo, pelos handlers
+// de eleição e pelo assinante de anúncios, em goroutines diferentes.
+func (srv *Server) coordinator() string {
+	srv.coordinatorMutex.Lock()
+	defer srv.coordinatorMutex.Unlock()
+	return srv.coordinatorName
+}
+
+func (srv *Server) setCoordinator(name string) {
+	srv.coordinatorMutex.Lock()
+	srv.coordinatorName = name
+	srv.coordinatorMutex.Unlock()
+}
+
+// rank é o rank dado pela referência no registro (sob coordinatorMutex,
+// como o coordenador).
+func (srv *Server) rank() int {
+	srv.coordinatorMutex.Lock()
+	defer srv.coordinatorMutex.Unlock()
+	return srv.serverRank
+}
+
+func (srv *Server) isCoordinator() bool {
+	return srv.coordinator() == srv.serverName
+}
+
+// Funções para relógio físico ajustado (timeOffset e lastSyncTime também
+// ficam sob clockMutex)
+func (srv *Server) getAdjustedTime() int64 {
+	srv.clockMutex.Lock()
+	defer srv.clockMutex.Unlock()
+	return time.Now().Unix() + srv.timeOffset
+}
+
+func (srv *Server) adjustTime(adjustment int64) {
+	srv.clockMutex.Lock()
+	srv.timeOffset += adjustment
+	offset := srv.timeOffset
+	srv.clockMutex.Unlock()
+	log.Printf("⏰ Relógio ajustado em %ds (offset total: %ds)", adjustment, offset)
+}
+
+// markSynced registra o fim de uma sincronização Berkeley.
+func (srv *Server) markSynced() {
+	now := srv.getAdjustedTime()
+	srv.clockMutex.Lock()
+	srv.lastSyncTime = now
+	srv.clockMutex.Unlock()
+}
+
+// Estruturas de dados (server/protocol, compartilhadas com o SDK)
+type (
+	Header           = protocol.Header
+	LoginRequest     = protocol.LoginRequest
+	LoginResponse    = protocol.LoginResponse
+	UsersRequest     = protocol.UsersRequest
+	UsersResponse    = protocol.UsersResponse
+	ChannelRequest   = protocol.ChannelRequest
+	ChannelResponse  = protocol.ChannelResponse
+	ChannelsRequest  = protocol.ChannelsRequest
+	ChannelsResponse = protocol.ChannelsResponse
+	ChannelInfo      = protocol.ChannelInfo
+	PublishRequest   = protocol.PublishRequest
+	PublishResponse  = protocol.PublishResponse
+	MessageRequest   = protocol.MessageRequest
+	MessageResponse  = protocol.MessageResponse
+	Publication      = protocol.Publication
+	DirectMessage    = protocol.DirectMessage
+)
 
 // Estruturas de persistência
 type UserLogin struct {
@@ -190,25 +117,53 @@ type UserLogin struct {
 	Timestamp int64  `msgpack:"timestamp"`
 }
 
+// Registro de canal com metadados
+type Channel struct {
+	Name        string `msgpack:"name"`
+	Topic       string `msgpack:"topic"`
+	Description string `msgpack:"description"`
+	Owner       string `msgpack:"owner"`
+	CreatedAt   int64  `msgpack:"created_at"`
+	Archived    bool   `msgpack:"archived"`
+
+	Retention *RetentionPolicy `msgpack:"retention,omitempty"` // sobrepõe a política global
+}
+
 type ChannelMessage struct {
-	User      string `msgpack:"user"`
-	Channel   string `msgpack:"channel"`
-	Message   string `msgpack:"message"`
-	Timestamp int64  `msgpack:"timestamp"`
+	ID          string              `msgpack:"id"`
+	ParentID    string              `msgpack:"parent_id,omitempty"` // raiz da thread
+	User        string              `msgpack:"user"`
+	Channel     string              `msgpack:"channel"`
+	Message     string              `msgpack:"message"`
+	Attachments []string            `msgpack:"attachments,omitempty"` // hashes de blobs
+	Timestamp   int64               `msgpack:"timestamp"`
+	Clock       int64               `msgpack:"clock,omitempty"` // relógio lógico da publicação
+	Deleted     bool                `msgpack:"deleted,omitempty"`
+	History     []MessageVersion    `msgpack:"history,omitempty"`   // versões anteriores (auditoria)
+	Reactions   map[string][]string `msgpack:"reactions,omitempty"` // emoji -> usuários
 }
 
 type UserMessage struct {
-	Src       string `msgpack:"src"`
-	Dst       string `msgpack:"dst"`
-	Message   string `msgpack:"message"`
-	Timestamp int64  `msgpack:"timestamp"`
+	ID          string              `msgpack:"id"`
+	Src         string              `msgpack:"src"`
+	Dst         string              `msgpack:"dst"`
+	Message     string              `msgpack:"message"`
+	Attachments []string            `msgpack:"attachments,omitempty"` // hashes de blobs
+	Timestamp   int64               `msgpack:"timestamp"`
+	Clock       int64               `msgpack:"clock,omitempty"` // relógio lógico da publicação
+	Deleted     bool                `msgpack:"deleted,omitempty"`
+	History     []MessageVersion    `msgpack:"history,omitempty"`      // versões anteriores (auditoria)
+	Reactions   map[string][]string `msgpack:"reactions,omitempty"`    // emoji -> usuários
+	DeliveredAt int64               `msgpack:"delivered_at,omitempty"` // confirmação de entrega
+	ReadAt      int64               `msgpack:"read_at,omitempty"`      // confirmação de leitura
 }
 
 type PersistentData struct {
 	Logins          []UserLogin      `msgpack:"logins"`
-	Channels        []string         `msgpack:"channels"`
+	Channels        []Channel        `msgpack:"channels"`
 	ChannelMessages []ChannelMessage `msgpack:"channel_messages"`
 	UserMessages    []UserMessage    `msgpack:"user_messages"`
+	Tombstones      map[string]int64 `msgpack:"tombstones,omitempty"` // ID -> quando foi expurgada (retenção)
 }
 
 // Estruturas para comunicação com o servidor de referência
@@ -316,11 +271,20 @@ type CoordinatorAnnouncement struct {
 	} `msgpack:"data"`
 }
 
+type CoordinatorAck struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string `msgpack:"status"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
 // Estruturas para replicação de dados (Parte 5)
 type ReplicationRequest struct {
 	Service string `msgpack:"service"`
 	Data    struct {
-		Type      string      `msgpack:"type"` // "login", "channel", "channel_message", "user_message"
+		Type      string      `msgpack:"type"` // "login", "channel", "channel_update", "channel_rename", "channel_delete", "channel_message", "user_message", "message_edit", "reaction", "receipt", "blob", "purge"
 		Content   interface{} `msgpack:"content"`
 		Timestamp int64       `msgpack:"timestamp"`
 		Clock     int64       `msgpack:"clock"`
@@ -349,7 +313,7 @@ type SyncResponse struct {
 	Service string `msgpack:"service"`
 	Data    struct {
 		Logins          []UserLogin      `msgpack:"logins"`
-		Channels        []string         `msgpack:"channels"`
+		Channels        []Channel        `msgpack:"channels"`
 		ChannelMessages []ChannelMessage `msgpack:"channel_messages"`
 		UserMessages    []UserMessage    `msgpack:"user_messages"`
 		Timestamp       int64            `msgpack:"timestamp"`
@@ -357,49 +321,110 @@ type SyncResponse struct {
 	} `msgpack:"data"`
 }
 
-const dataFile = "/data/server_data.json"
-
-var data PersistentData
-var pubSocket *zmq.Socket
-var serverName string
-var serverRank int
-var coordinatorName string
-var messageCounter int
-var timeOffset int64     // Ajuste do relógio físico (Berkeley)
-var lastSyncTime int64   // Última sincronização (Parte 5)
-var dataMutex sync.Mutex // Proteger acesso aos dados
-
 // Funções de persistência
-func loadData() error {
-	file, err := os.ReadFile(dataFile)
+func (srv *Server) loadData() error {
+	file, err := os.ReadFile(srv.dataFile())
 	if err != nil {
 		if os.IsNotExist(err) {
-			data = PersistentData{
+			srv.data = PersistentData{
 				Logins:          []UserLogin{},
-				Channels:        []string{},
+				Channels:        []Channel{},
 				ChannelMessages: []ChannelMessage{},
 				UserMessages:    []UserMessage{},
 			}
-			return saveData()
+			return srv.saveData()
 		}
 		return err
 	}
-	return json.Unmarshal(file, &data)
+	if err := json.Unmarshal(file, &srv.data); err != nil {
+		return err
+	}
+	if n := srv.data.normalize(); n > 0 {
+		log.Printf("🆔 %d mensagens antigas receberam ID", n)
+		return srv.writeData()
+	}
+	return nil
+}
+
+// normalize prepara dados lidos do disco ou de um dump: listas e mapas nulos
+// viram vazios, mensagens antigas recebem ID e as mensagens de canal ficam na
+// ordem do canal (invariante de insertChannelMessage). Devolve quantas
+// mensagens receberam ID.
+func (d *PersistentData) normalize() int {
+	if d.Logins == nil {
+		d.Logins = []UserLogin{}
+	}
+	if d.Channels == nil {
+		d.Channels = []Channel{}
+	}
+	if d.ChannelMessages == nil {
+		d.ChannelMessages = []ChannelMessage{}
+	}
+	if d.UserMessages == nil {
+		d.UserMessages = []UserMessage{}
+	}
+	if d.Tombstones == nil {
+		d.Tombstones = make(map[string]int64)
+	}
+	n := d.assignLegacyIDs()
+	d.sortChannelMessages()
+	return n
+}
+
+// assignLegacyIDs dá ID às mensagens gravadas antes dos IDs existirem, para
+// que possam ser editadas, reagidas, buscadas e expurgadas. O ID é derivado
+// do conteúdo, então réplicas com os mesmos dados antigos chegam aos mesmos
+// IDs sem se comunicar. Devolve quantas mensagens foram alteradas.
+func (d *PersistentData) assignLegacyIDs() int {
+	seen := make(map[string]int)
+	id := func(parts ...interface{}) string {
+		sum := sha256.Sum256([]byte(fmt.Sprintln(parts...)))
+		base := "legacy-" + hex.EncodeToString(sum[:8])
+		seen[base]++
+		if n := seen[base]; n > 1 {
+			return fmt.Sprintf("%s-%d", base, n)
+		}
+		return base
+	}
+
+	n := 0
+	for i := range d.ChannelMessages {
+		cm := &d.ChannelMessages[i]
+		if cm.ID == "" {
+			cm.ID = id("channel", cm.Channel, cm.User, cm.Message, cm.Timestamp, cm.Clock)
+			n++
+		}
+	}
+	for i := range d.UserMessages {
+		um := &d.UserMessages[i]
+		if um.ID == "" {
+			um.ID = id("user", um.Src, um.Dst, um.Message, um.Timestamp, um.Clock)
+			n++
+		}
+	}
+	return n
+}
+
+func (srv *Server) saveData() error {
+	srv.dataMutex.Lock()
+	defer srv.dataMutex.Unlock()
+	return srv.writeData()
 }
 
-func saveData() error {
-	file, err := json.MarshalIndent(data, "", "  ")
+// writeData grava os dados no disco. Deve ser chamada com dataMutex travado.
+func (srv *Server) writeData() error {
+	file, err := json.MarshalIndent(srv.data, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	os.MkdirAll("/data", 0755)
+	os.MkdirAll(srv.cfg.DataDir, 0755)
 
-	return os.WriteFile(dataFile, file, 0644)
+	return os.WriteFile(srv.dataFile(), file, 0644)
 }
 
-func userExists(username string) bool {
-	for _, login := range data.Logins {
+func (srv *Server) userExists(username string) bool {
+	for _, login := range srv.data.Logins {
 		if login.Username == username {
 			return true
 		}
@@ -407,18 +432,18 @@ func userExists(username string) bool {
 	return false
 }
 
-func channelExists(channel string) bool {
-	for _, ch := range data.Channels {
-		if ch == channel {
-			return true
-		}
-	}
-	return false
+func (srv *Server) channelExists(channel string) bool {
+	return srv.findChannel(channel) >= 0
+}
+
+// newMessageID gera um identificador único no cluster para uma mensagem.
+func (srv *Server) newMessageID() string {
+	return fmt.Sprintf("%s-%d-%d", srv.serverName, time.Now().UnixNano(), srv.getClock())
 }
 
-func getUniqueUsers() []string {
+func (srv *Server) getUniqueUsers() []string {
 	userMap := make(map[string]bool)
-	for _, login := range data.Logins {
+	for _, login := range srv.data.Logins {
 		userMap[login.Username] = true
 	}
 
@@ -430,11 +455,14 @@ func getUniqueUsers() []string {
 }
 
 // Funções para comunicação com o servidor de referência
-func registerWithReference(refSocket *zmq.Socket) error {
+func (srv *Server) registerWithReference(refSocket PeerConn) error {
+	srv.refMutex.Lock()
+	defer srv.refMutex.Unlock()
+
 	req := RankRequest{Service: "rank"}
-	req.Data.User = serverName
+	req.Data.User = srv.serverName
 	req.Data.Timestamp = time.Now().Unix()
-	req.Data.Clock = incrementClock()
+	req.Data.Clock = srv.incrementClock()
 
 	reqData, err := msgpack.Marshal(req)
 	if err != nil {
@@ -455,18 +483,23 @@ func registerWithReference(refSocket *zmq.Socket) error {
 		return fmt.Errorf("erro ao deserializar resposta: %v", err)
 	}
 
-	updateClock(resp.Data.Clock)
-	serverRank = resp.Data.Rank
-	log.Printf("✅ Servidor registrado com rank: %d", serverRank)
+	srv.updateClock(resp.Data.Clock)
+	srv.coordinatorMutex.Lock()
+	srv.serverRank = resp.Data.Rank
+	srv.coordinatorMutex.Unlock()
+	log.Printf("✅ Servidor registrado com rank: %d", resp.Data.Rank)
 
 	return nil
 }
 
-func sendHeartbeat(refSocket *zmq.Socket) error {
+func (srv *Server) sendHeartbeat(refSocket PeerConn) error {
+	srv.refMutex.Lock()
+	defer srv.refMutex.Unlock()
+
 	req := HeartbeatRequest{Service: "heartbeat"}
-	req.Data.User = serverName
+	req.Data.User = srv.serverName
 	req.Data.Timestamp = time.Now().Unix()
-	req.Data.Clock = incrementClock()
+	req.Data.Clock = srv.incrementClock()
 
 	reqData, err := msgpack.Marshal(req)
 	if err != nil {
@@ -495,17 +528,20 @@ func sendHeartbeat(refSocket *zmq.Socket) error {
 		return err
 	}
 
-	updateClock(resp.Data.Clock)
-	log.Printf("💓 Heartbeat enviado (rank: %d, clock: %d)", serverRank, resp.Data.Clock)
+	srv.updateClock(resp.Data.Clock)
+	log.Printf("💓 Heartbeat enviado (rank: %d, clock: %d)", srv.rank(), resp.Data.Clock)
 
 	return nil
 }
 
 // Funções para obter lista de servidores do reference
-func getServerList(refSocket *zmq.Socket) ([]ServerInfo, error) {
+func (srv *Server) getServerList(refSocket PeerConn) ([]ServerInfo, error) {
+	srv.refMutex.Lock()
+	defer srv.refMutex.Unlock()
+
 	req := ListRequest{Service: "list"}
-	req.Data.Timestamp = getAdjustedTime()
-	req.Data.Clock = incrementClock()
+	req.Data.Timestamp = srv.getAdjustedTime()
+	req.Data.Clock = srv.incrementClock()
 
 	reqData, err := msgpack.Marshal(req)
 	if err != nil {
@@ -526,31 +562,16 @@ func getServerList(refSocket *zmq.Socket) ([]ServerInfo, error) {
 		return nil, err
 	}
 
-	updateClock(resp.Data.Clock)
+	srv.updateClock(resp.Data.Clock)
 	return resp.Data.List, nil
 }
 
-// Função para criar socket REQ temporário para comunicação entre servidores
-func createServerSocket(serverURL string) (*zmq.Socket, error) {
-	socket, err := zmq.NewSocket(zmq.REQ)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := socket.Connect(serverURL); err != nil {
-		socket.Close()
-		return nil, err
-	}
-
-	return socket, nil
-}
-
 // Sincronização Berkeley - Coordenador coleta timestamps
-func berkeleyCoordinator(refSocket *zmq.Socket) error {
+func (srv *Server) berkeleyCoordinator(refSocket PeerConn) error {
 	log.Printf("🎯 Iniciando sincronização Berkeley como COORDENADOR")
 
 	// Obter lista de servidores
-	servers, err := getServerList(refSocket)
+	servers, err := srv.getServerList(refSocket)
 	if err != nil {
 		return fmt.Errorf("erro ao obter lista de servidores: %v", err)
 	}
@@ -562,17 +583,16 @@ func berkeleyCoordinator(refSocket *zmq.Socket) error {
 
 	// Coletar timestamps de todos os servidores
 	timestamps := make(map[string]int64)
-	timestamps[serverName] = getAdjustedTime()
+	timestamps[srv.serverName] = srv.getAdjustedTime()
 
 	log.Printf("📊 Coletando timestamps de %d servidores...", len(servers))
 
 	for _, server := range servers {
-		if server.Name == serverName {
+		if server.Name == srv.serverName {
 			continue // Skip self
 		}
 
-		serverURL := fmt.Sprintf("tcp://%s:5555", server.Name)
-		socket, err := createServerSocket(serverURL)
+		socket, err := srv.dial(server.Name)
 		if err != nil {
 			log.Printf("⚠️  Erro ao conectar a %s: %v", server.Name, err)
 			continue
@@ -580,10 +600,10 @@ func berkeleyCoordinator(refSocket *zmq.Socket) error {
 
 		// Enviar requisição de clock
 		req := ClockRequest{Service: "clock"}
-		req.Data.Timestamp = getAdjustedTime()
-		req.Data.Clock = incrementClock()
+		req.Data.Timestamp = srv.getAdjustedTime()
+		req.Data.Clock = srv.incrementClock()
 
-		reqData, _ := msgpack.Marshal(req)
+		reqData, _ := srv.signPeer(req)
 		socket.SendBytes(reqData, 0)
 
 		// Receber resposta
@@ -601,7 +621,7 @@ func berkeleyCoordinator(refSocket *zmq.Socket) error {
 			continue
 		}
 
-		updateClock(resp.Data.Clock)
+		srv.updateClock(resp.Data.Clock)
 		timestamps[server.Name] = resp.Data.Time
 		log.Printf("   📥 %s: %d", server.Name, resp.Data.Time)
 	}
@@ -616,17 +636,16 @@ func berkeleyCoordinator(refSocket *zmq.Socket) error {
 
 	// Distribuir ajustes
 	for _, server := range servers {
-		if server.Name == serverName {
+		if server.Name == srv.serverName {
 			// Ajustar próprio relógio
-			adjustment := avgTime - timestamps[serverName]
+			adjustment := avgTime - timestamps[srv.serverName]
 			if adjustment != 0 {
-				adjustTime(adjustment)
+				srv.adjustTime(adjustment)
 			}
 			continue
 		}
 
-		serverURL := fmt.Sprintf("tcp://%s:5555", server.Name)
-		socket, err := createServerSocket(serverURL)
+		socket, err := srv.dial(server.Name)
 		if err != nil {
 			log.Printf("⚠️  Erro ao conectar a %s para ajuste: %v", server.Name, err)
 			continue
@@ -637,10 +656,10 @@ func berkeleyCoordinator(refSocket *zmq.Socket) error {
 
 		adj := ClockAdjustment{Service: "adjust"}
 		adj.Data.Adjustment = adjustment
-		adj.Data.Timestamp = getAdjustedTime()
-		adj.Data.Clock = incrementClock()
+		adj.Data.Timestamp = srv.getAdjustedTime()
+		adj.Data.Clock = srv.incrementClock()
 
-		adjData, _ := msgpack.Marshal(adj)
+		adjData, _ := srv.signPeer(adj)
 		socket.SendBytes(adjData, 0)
 
 		// Aguardar confirmação
@@ -650,38 +669,40 @@ func berkeleyCoordinator(refSocket *zmq.Socket) error {
 		log.Printf("   📤 Enviado ajuste de %ds para %s", adjustment, server.Name)
 	}
 
+	srv.markSynced()
 	log.Printf("✅ Sincronização Berkeley concluída")
 	return nil
 }
 
 // Handler para requisição de clock (coordenador pedindo meu tempo)
-func handleClockRequest(msg []byte) ([]byte, error) {
+func (srv *Server) handleClockRequest(msg []byte) ([]byte, error) {
 	var req ClockRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	resp := ClockResponse{Service: "clock"}
-	resp.Data.Time = getAdjustedTime()
-	resp.Data.Timestamp = getAdjustedTime()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Time = srv.getAdjustedTime()
+	resp.Data.Timestamp = srv.getAdjustedTime()
+	resp.Data.Clock = srv.incrementClock()
 
 	return msgpack.Marshal(resp)
 }
 
 // Handler para ajuste de relógio (coordenador mandando ajuste)
-func handleClockAdjustment(msg []byte) ([]byte, error) {
+func (srv *Server) handleClockAdjustment(msg []byte) ([]byte, error) {
 	var req ClockAdjustment
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	// Aplicar ajuste
-	adjustTime(req.Data.Adjustment)
+	srv.adjustTime(req.Data.Adjustment)
+	srv.markSynced()
 
 	// Responder OK
 	resp := struct {
@@ -694,21 +715,21 @@ func handleClockAdjustment(msg []byte) ([]byte, error) {
 	}{Service: "adjust"}
 
 	resp.Data.Status = "OK"
-	resp.Data.Timestamp = getAdjustedTime()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Timestamp = srv.getAdjustedTime()
+	resp.Data.Clock = srv.incrementClock()
 
 	return msgpack.Marshal(resp)
 }
 
 // Determinar coordenador (servidor com maior rank)
-func determineCoordinator(refSocket *zmq.Socket) (string, error) {
-	servers, err := getServerList(refSocket)
+func (srv *Server) determineCoordinator(refSocket PeerConn) (string, error) {
+	servers, err := srv.getServerList(refSocket)
 	if err != nil {
 		return "", err
 	}
 
 	if len(servers) == 0 {
-		return serverName, nil
+		return srv.serverName, nil
 	}
 
 	maxRank := -1
@@ -726,61 +747,65 @@ func determineCoordinator(refSocket *zmq.Socket) (string, error) {
 }
 
 // Verificar se deve sincronizar (a cada 10 mensagens)
-func checkAndSyncIfNeeded(refSocket *zmq.Socket) {
-	messageCounter++
+func (srv *Server) checkAndSyncIfNeeded(refSocket PeerConn) {
+	srv.messageCounter++
 
-	if messageCounter >= 10 {
-		messageCounter = 0
+	if srv.messageCounter >= 10 {
+		srv.messageCounter = 0
 
 		// Determinar coordenador
-		coordinator, err := determineCoordinator(refSocket)
+		coordinator, err := srv.determineCoordinator(refSocket)
 		if err != nil {
 			log.Printf("⚠️  Erro ao determinar coordenador: %v", err)
 			return
 		}
 
-		coordinatorName = coordinator
+		// O servidor de maior rank na lista só é adotado se responder: durante
+		// uma partição ele continua na lista, mas quem foi eleito no lugar
+		// dele deve permanecer
+		if current := srv.coordinator(); coordinator != current && coordinator != srv.serverName {
+			if _, err := srv.pingPeer(coordinator); err != nil {
+				log.Printf("⚠️  %s não respondeu; mantendo coordenador %s", coordinator, current)
+				coordinator = current
+			}
+		}
+		srv.setCoordinator(coordinator)
 
 		// Se sou coordenador, sincronizar
-		if coordinator == serverName {
-			go func() {
-				if err := berkeleyCoordinator(refSocket); err != nil {
+		if coordinator == srv.serverName {
+			srv.spawn(func() {
+				if err := srv.berkeleyCoordinator(refSocket); err != nil {
 					log.Printf("⚠️  Erro na sincronização: %v", err)
 				}
-			}()
+			})
 		}
 	}
 }
 
-func startHeartbeatRoutine(refSocket *zmq.Socket) {
-	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-
-		heartbeatCount := 0
+func (srv *Server) startHeartbeatRoutine(refSocket PeerConn) {
+	heartbeatCount := 0
 
-		for range ticker.C {
-			if err := sendHeartbeat(refSocket); err != nil {
-				log.Printf("⚠️  Erro ao enviar heartbeat: %v", err)
-			}
+	srv.every(srv.cfg.Heartbeat, func() {
+		if err := srv.sendHeartbeat(refSocket); err != nil {
+			log.Printf("⚠️  Erro ao enviar heartbeat: %v", err)
+		}
 
-			heartbeatCount++
+		heartbeatCount++
 
-			// A cada 3 heartbeats (30s), verificar coordenador
-			if heartbeatCount >= 3 {
-				heartbeatCount = 0
-				checkCoordinatorHealth(refSocket)
-			}
+		// A cada 3 heartbeats (30s), verificar coordenador
+		if heartbeatCount >= 3 {
+			heartbeatCount = 0
+			srv.checkCoordinatorHealth(refSocket)
 		}
-	}()
+	})
 }
 
 // Substituir a função handleLogin no main.go
 
-func handleLogin(msg []byte) ([]byte, error) {
+func (srv *Server) handleLogin(msg []byte) ([]byte, error) {
 	// Log do payload bruto para debug
 	log.Printf("🔍 DEBUG: Recebido payload de login (tamanho: %d bytes)", len(msg))
-	
+
 	var req LoginRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		log.Printf("❌ Erro ao deserializar LoginRequest: %v", err)
@@ -788,319 +813,353 @@ func handleLogin(msg []byte) ([]byte, error) {
 	}
 
 	// Log detalhado dos campos recebidos
-	log.Printf("🔍 DEBUG: Service=%s, User='%s', Timestamp=%d, Clock=%d", 
+	log.Printf("🔍 DEBUG: Service=%s, User='%s', Timestamp=%d, Clock=%d",
 		req.Service, req.Data.User, req.Data.Timestamp, req.Data.Clock)
 
 	// Atualizar relógio lógico ao receber mensagem
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	resp := LoginResponse{Service: "login"}
 	resp.Data.Timestamp = time.Now().Unix()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Clock = srv.incrementClock()
 
 	// Validação melhorada
 	trimmedUser := strings.TrimSpace(req.Data.User)
-	
+	srv.dataMutex.Lock()
+	exists := srv.userExists(trimmedUser)
+	srv.dataMutex.Unlock()
+
 	if trimmedUser == "" {
-		log.Printf("⚠️  Login rejeitado: usuário vazio (original: '%s', trimmed: '%s')", 
+		log.Printf("⚠️  Login rejeitado: usuário vazio (original: '%s', trimmed: '%s')",
 			req.Data.User, trimmedUser)
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidName
 		resp.Data.Description = "Nome de usuário não pode ser vazio"
-	} else if userExists(trimmedUser) {
-		log.Printf("⚠️  Login rejeitado: usuário '%s' já existe", trimmedUser)
-		resp.Data.Status = "erro"
-		resp.Data.Description = "Usuário já existe"
+	} else if exists {
+		// Login repetido (reconexão, outro dispositivo): nada a gravar
+		resp.Data.Status = "sucesso"
+		resp.Data.Description = "Usuário já cadastrado"
+		log.Printf("🔁 Login de usuário existente: '%s' (clock: %d)", trimmedUser, resp.Data.Clock)
 	} else {
 		login := UserLogin{
 			Username:  trimmedUser,
 			Timestamp: req.Data.Timestamp,
 		}
 
-		dataMutex.Lock()
-		data.Logins = append(data.Logins, login)
-		dataMutex.Unlock()
+		srv.dataMutex.Lock()
+		srv.data.Logins = append(srv.data.Logins, login)
+		srv.dataMutex.Unlock()
 
-		if err := saveData(); err != nil {
+		if err := srv.saveData(); err != nil {
 			log.Printf("❌ Erro ao salvar dados para usuário '%s': %v", trimmedUser, err)
 			resp.Data.Status = "erro"
+			resp.Data.Error = ErrStorage
 			resp.Data.Description = "Erro ao salvar dados: " + err.Error()
 		} else {
 			resp.Data.Status = "sucesso"
 			log.Printf("✅ Novo usuário cadastrado: '%s' (clock: %d)", trimmedUser, resp.Data.Clock)
 
 			// Replicar para outros servidores (assíncrono)
-			go func() {
-				refURL := os.Getenv("REFERENCE_URL")
-				if refURL == "" {
-					refURL = "tcp://reference:5559"
-				}
-				refSock, err := zmq.NewSocket(zmq.REQ)
-				if err == nil {
-					defer refSock.Close()
-					refSock.Connect(refURL)
-					time.Sleep(500 * time.Millisecond)
-					replicateData(refSock, "login", login)
-				}
-			}()
+			srv.replicateAsync("login", login)
 		}
 	}
 
 	return msgpack.Marshal(resp)
 }
 
-func handleUsers(msg []byte) ([]byte, error) {
+func (srv *Server) handleUsers(msg []byte) ([]byte, error) {
 	var req UsersRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	resp := UsersResponse{Service: "users"}
 	resp.Data.Timestamp = time.Now().Unix()
-	resp.Data.Clock = incrementClock()
-	resp.Data.Users = getUniqueUsers()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Users = srv.getUniqueUsers()
 
 	return msgpack.Marshal(resp)
 }
 
-func handleChannel(msg []byte) ([]byte, error) {
+func (srv *Server) handleChannel(msg []byte) ([]byte, error) {
 	var req ChannelRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	resp := ChannelResponse{Service: "channel"}
 	resp.Data.Timestamp = time.Now().Unix()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Clock = srv.incrementClock()
 
 	if req.Data.Channel == "" {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidName
 		resp.Data.Description = "Nome do canal não pode ser vazio"
-	} else if channelExists(req.Data.Channel) {
+	} else if srv.channelExists(req.Data.Channel) {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelExists
 		resp.Data.Description = "Canal já existe"
 	} else {
-		channel := req.Data.Channel
+		channel := Channel{
+			Name:        req.Data.Channel,
+			Topic:       req.Data.Topic,
+			Description: req.Data.Description,
+			Owner:       req.Data.User,
+			CreatedAt:   req.Data.Timestamp,
+		}
 
-		dataMutex.Lock()
-		data.Channels = append(data.Channels, channel)
-		dataMutex.Unlock()
+		srv.dataMutex.Lock()
+		srv.data.Channels = append(srv.data.Channels, channel)
+		srv.dataMutex.Unlock()
 
-		if err := saveData(); err != nil {
+		if err := srv.saveData(); err != nil {
 			resp.Data.Status = "erro"
+			resp.Data.Error = ErrStorage
 			resp.Data.Description = "Erro ao salvar dados: " + err.Error()
 		} else {
 			resp.Data.Status = "sucesso"
 			log.Printf("✅ Novo canal criado: %s (clock: %d)", req.Data.Channel, resp.Data.Clock)
 
 			// Replicar para outros servidores
-			go func() {
-				refURL := os.Getenv("REFERENCE_URL")
-				if refURL == "" {
-					refURL = "tcp://reference:5559"
-				}
-				refSock, err := zmq.NewSocket(zmq.REQ)
-				if err == nil {
-					defer refSock.Close()
-					refSock.Connect(refURL)
-					time.Sleep(500 * time.Millisecond)
-					replicateData(refSock, "channel", channel)
-				}
-			}()
+			srv.replicateAsync("channel", channel)
 		}
 	}
 
 	return msgpack.Marshal(resp)
 }
 
-func handleChannels(msg []byte) ([]byte, error) {
+func (srv *Server) handleChannels(msg []byte) ([]byte, error) {
 	var req ChannelsRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	resp := ChannelsResponse{Service: "channels"}
 	resp.Data.Timestamp = time.Now().Unix()
-	resp.Data.Clock = incrementClock()
-	resp.Data.Channels = data.Channels
+	resp.Data.Clock = srv.incrementClock()
+
+	srv.dataMutex.Lock()
+	resp.Data.Info = srv.listChannels(req.Data.Filter, req.Data.Sort, req.Data.IncludeArchived)
+	srv.dataMutex.Unlock()
+
+	resp.Data.Channels = []string{}
+	for _, info := range resp.Data.Info {
+		resp.Data.Channels = append(resp.Data.Channels, info.Name)
+	}
 
 	return msgpack.Marshal(resp)
 }
 
 // Novos handlers da Parte 2
-func handlePublish(msg []byte) ([]byte, error) {
+func (srv *Server) handlePublish(msg []byte) ([]byte, error) {
 	var req PublishRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	resp := PublishResponse{Service: "publish"}
 	resp.Data.Timestamp = time.Now().Unix()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Clock = srv.incrementClock()
 
 	// Validações
-	if !channelExists(req.Data.Channel) {
+	if !srv.channelExists(req.Data.Channel) {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelNotFound
 		resp.Data.Message = "Canal não existe"
 		return msgpack.Marshal(resp)
 	}
 
-	if req.Data.Message == "" {
+	if srv.channelArchived(req.Data.Channel) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelArchived
+		resp.Data.Message = "Canal arquivado (somente leitura)"
+		return msgpack.Marshal(resp)
+	}
+
+	if req.Data.Message == "" && len(req.Data.Attachments) == 0 {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrEmptyMessage
 		resp.Data.Message = "Mensagem não pode ser vazia"
 		return msgpack.Marshal(resp)
 	}
 
+	if !srv.validAttachments(req.Data.Attachments) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrAttachmentNotFound
+		resp.Data.Message = "Anexo não encontrado"
+		return msgpack.Marshal(resp)
+	}
+
+	// Respostas sempre apontam para a raiz da thread
+	parentID := ""
+	if req.Data.ParentID != "" {
+		srv.dataMutex.Lock()
+		parentID = srv.threadRoot(req.Data.ParentID, req.Data.Channel)
+		srv.dataMutex.Unlock()
+		if parentID == "" {
+			resp.Data.Status = "erro"
+			resp.Data.Error = ErrMessageNotFound
+			resp.Data.Message = "Mensagem de origem não existe neste canal"
+			return msgpack.Marshal(resp)
+		}
+	}
+
 	// Criar publicação com relógio lógico
 	pub := Publication{
-		User:      req.Data.User,
-		Message:   req.Data.Message,
-		Timestamp: req.Data.Timestamp,
-		Clock:     incrementClock(),
+		ID:          srv.newMessageID(),
+		ParentID:    parentID,
+		User:        req.Data.User,
+		Message:     req.Data.Message,
+		Attachments: req.Data.Attachments,
+		Timestamp:   req.Data.Timestamp,
+		Clock:       srv.incrementClock(),
 	}
 
 	pubData, err := msgpack.Marshal(pub)
 	if err != nil {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInternal
 		resp.Data.Message = "Erro ao serializar mensagem"
 		return msgpack.Marshal(resp)
 	}
 
-	// Publicar no broker (tópico = nome do canal)
-	topic := req.Data.Channel
-	if _, err := pubSocket.SendMessage(topic, pubData); err != nil {
+	// Publicar no broker (tópico "ch/<canal>\x00")
+	topic := topics.Channel(req.Data.Channel)
+	if _, err := srv.pubSocket.SendMessage(topic, pubData); err != nil {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrBrokerUnavailable
 		resp.Data.Message = "Erro ao publicar mensagem: " + err.Error()
-		log.Printf("❌ Erro ao publicar no canal %s: %v", topic, err)
+		log.Printf("❌ Erro ao publicar no canal %s: %v", req.Data.Channel, err)
 		return msgpack.Marshal(resp)
 	}
 
 	// Salvar na persistência
 	channelMsg := ChannelMessage{
-		User:      req.Data.User,
-		Channel:   req.Data.Channel,
-		Message:   req.Data.Message,
-		Timestamp: req.Data.Timestamp,
-	}
-
-	dataMutex.Lock()
-	data.ChannelMessages = append(data.ChannelMessages, channelMsg)
-	dataMutex.Unlock()
-
-	if err := saveData(); err != nil {
+		ID:          pub.ID,
+		ParentID:    parentID,
+		User:        req.Data.User,
+		Channel:     req.Data.Channel,
+		Message:     req.Data.Message,
+		Attachments: req.Data.Attachments,
+		Timestamp:   req.Data.Timestamp,
+		Clock:       pub.Clock,
+	}
+
+	srv.dataMutex.Lock()
+	srv.insertChannelMessage(channelMsg)
+	srv.indexMessage(channelMsg.ID, channelMsg.Message)
+	srv.dataMutex.Unlock()
+
+	if err := srv.saveData(); err != nil {
 		log.Printf("⚠️  Aviso: erro ao salvar mensagem: %v", err)
 	}
 
 	resp.Data.Status = "OK"
+	resp.Data.ID = channelMsg.ID
 	log.Printf("📤 Publicação no canal #%s por %s (clock: %d)", req.Data.Channel, req.Data.User, pub.Clock)
 
 	// Replicar mensagem para outros servidores
-	go func() {
-		refURL := os.Getenv("REFERENCE_URL")
-		if refURL == "" {
-			refURL = "tcp://reference:5559"
-		}
-		refSock, err := zmq.NewSocket(zmq.REQ)
-		if err == nil {
-			defer refSock.Close()
-			refSock.Connect(refURL)
-			time.Sleep(500 * time.Millisecond)
-			replicateData(refSock, "channel_message", channelMsg)
-		}
-	}()
+	srv.replicateAsync("channel_message", channelMsg)
 
 	return msgpack.Marshal(resp)
 }
 
-func handleMessage(msg []byte) ([]byte, error) {
+func (srv *Server) handleMessage(msg []byte) ([]byte, error) {
 	var req MessageRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	resp := MessageResponse{Service: "message"}
 	resp.Data.Timestamp = time.Now().Unix()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Clock = srv.incrementClock()
 
 	// Validações
-	if !userExists(req.Data.Dst) {
+	if !srv.userExists(req.Data.Dst) {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrUserNotFound
 		resp.Data.Message = "Usuário de destino não existe"
 		return msgpack.Marshal(resp)
 	}
 
-	if req.Data.Message == "" {
+	if req.Data.Message == "" && len(req.Data.Attachments) == 0 {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrEmptyMessage
 		resp.Data.Message = "Mensagem não pode ser vazia"
 		return msgpack.Marshal(resp)
 	}
 
+	if !srv.validAttachments(req.Data.Attachments) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrAttachmentNotFound
+		resp.Data.Message = "Anexo não encontrado"
+		return msgpack.Marshal(resp)
+	}
+
 	// Criar mensagem direta com relógio lógico
 	dm := DirectMessage{
-		From:      req.Data.Src,
-		Message:   req.Data.Message,
-		Timestamp: req.Data.Timestamp,
-		Clock:     incrementClock(),
+		ID:          srv.newMessageID(),
+		From:        req.Data.Src,
+		Message:     req.Data.Message,
+		Attachments: req.Data.Attachments,
+		Timestamp:   req.Data.Timestamp,
+		Clock:       srv.incrementClock(),
 	}
 
 	dmData, err := msgpack.Marshal(dm)
 	if err != nil {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInternal
 		resp.Data.Message = "Erro ao serializar mensagem"
 		return msgpack.Marshal(resp)
 	}
 
-	// Publicar no broker (tópico = nome do usuário de destino)
-	topic := req.Data.Dst
-	if _, err := pubSocket.SendMessage(topic, dmData); err != nil {
+	// Publicar no broker (tópico "dm/<destino>\x00")
+	topic := topics.User(req.Data.Dst)
+	if _, err := srv.pubSocket.SendMessage(topic, dmData); err != nil {
 		resp.Data.Status = "erro"
+		resp.Data.Error = ErrBrokerUnavailable
 		resp.Data.Message = "Erro ao enviar mensagem: " + err.Error()
-		log.Printf("❌ Erro ao enviar mensagem para %s: %v", topic, err)
+		log.Printf("❌ Erro ao enviar mensagem para %s: %v", req.Data.Dst, err)
 		return msgpack.Marshal(resp)
 	}
 
 	// Salvar na persistência
 	userMsg := UserMessage{
-		Src:       req.Data.Src,
-		Dst:       req.Data.Dst,
-		Message:   req.Data.Message,
-		Timestamp: req.Data.Timestamp,
+		ID:          dm.ID,
+		Src:         req.Data.Src,
+		Dst:         req.Data.Dst,
+		Message:     req.Data.Message,
+		Attachments: req.Data.Attachments,
+		Timestamp:   req.Data.Timestamp,
+		Clock:       dm.Clock,
 	}
 
-	dataMutex.Lock()
-	data.UserMessages = append(data.UserMessages, userMsg)
-	dataMutex.Unlock()
+	srv.dataMutex.Lock()
+	srv.data.UserMessages = append(srv.data.UserMessages, userMsg)
+	srv.indexMessage(userMsg.ID, userMsg.Message)
+	srv.dataMutex.Unlock()
 
-	if err := saveData(); err != nil {
+	if err := srv.saveData(); err != nil {
 		log.Printf("⚠️  Aviso: erro ao salvar mensagem: %v", err)
 	}
 
 	resp.Data.Status = "OK"
+	resp.Data.ID = userMsg.ID
 	log.Printf("💬 Mensagem de %s para %s (clock: %d)", req.Data.Src, req.Data.Dst, dm.Clock)
 
 	// Replicar mensagem para outros servidores
-	go func() {
-		refURL := os.Getenv("REFERENCE_URL")
-		if refURL == "" {
-			refURL = "tcp://reference:5559"
-		}
-		refSock, err := zmq.NewSocket(zmq.REQ)
-		if err == nil {
-			defer refSock.Close()
-			refSock.Connect(refURL)
-			time.Sleep(500 * time.Millisecond)
-			replicateData(refSock, "user_message", userMsg)
-		}
-	}()
+	srv.replicateAsync("user_message", userMsg)
 
 	return msgpack.Marshal(resp)
 }
@@ -1109,27 +1168,18 @@ func handleMessage(msg []byte) ([]byte, error) {
 // Replicação de dados
 // ----------------------------
 
-func replicateData(refSocket *zmq.Socket, dataType string, content interface{}) error {
+func (srv *Server) replicateData(peer string, dataType string, content interface{}) error {
 	// Monta requisição de replicação
 	req := ReplicationRequest{Service: "replicate"}
 	req.Data.Type = dataType
 	req.Data.Content = content
-	req.Data.Timestamp = getAdjustedTime()
-	req.Data.Clock = incrementClock()
+	req.Data.Timestamp = srv.getAdjustedTime()
+	req.Data.Clock = srv.incrementClock()
 
-	reqData, err := msgpack.Marshal(req)
+	// Envia e espera a confirmação do outro servidor
+	respData, err := srv.callPeer(peer, req)
 	if err != nil {
-		return fmt.Errorf("replicateData: erro ao serializar: %v", err)
-	}
-
-	// Envia e espera resposta do reference
-	if _, err := refSocket.SendBytes(reqData, 0); err != nil {
-		return fmt.Errorf("replicateData: erro ao enviar: %v", err)
-	}
-
-	respData, err := refSocket.RecvBytes(0)
-	if err != nil {
-		return fmt.Errorf("replicateData: erro ao receber: %v", err)
+		return fmt.Errorf("replicateData: %v", err)
 	}
 
 	var resp ReplicationResponse
@@ -1138,26 +1188,54 @@ func replicateData(refSocket *zmq.Socket, dataType string, content interface{})
 	}
 
 	// Atualiza relógio lógico com o clock retornado
-	updateClock(resp.Data.Clock)
+	srv.updateClock(resp.Data.Clock)
 	if resp.Data.Status != "OK" {
-		return fmt.Errorf("replicateData: reference retornou status != OK")
+		return fmt.Errorf("replicateData: %s retornou status != OK", peer)
 	}
 	return nil
 }
 
+// replicateAsync replica uma escrita local em segundo plano para os servidores
+// ativos na referência, usando conexões próprias para não disputar o socket de
+// referência do loop principal. Quem não recebe (fora do ar ou particionado)
+// recupera o que faltou com admin_resync.
+func (srv *Server) replicateAsync(dataType string, content interface{}) {
+	srv.spawn(func() {
+		refSock, err := srv.dial(referencePeer)
+		if err != nil {
+			log.Printf("⚠️  Replicação de %s: %v", dataType, err)
+			return
+		}
+		servers, err := srv.getServerList(refSock)
+		refSock.Close()
+		if err != nil {
+			log.Printf("⚠️  Replicação de %s: erro ao obter lista: %v", dataType, err)
+			return
+		}
+		for _, s := range servers {
+			if s.Name == srv.serverName {
+				continue
+			}
+			if err := srv.replicateData(s.Name, dataType, content); err != nil {
+				log.Printf("⚠️  Replicação de %s para %s falhou: %v", dataType, s.Name, err)
+			}
+		}
+	})
+}
+
 // Handler para requisições "replicate" recebidas por este servidor.
 // Essa função aplica a réplica localmente (append nos slices) para manter persistência.
-func handleReplication(msg []byte) ([]byte, error) {
+func (srv *Server) handleReplication(msg []byte) ([]byte, error) {
 	var req ReplicationRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
 	// Atualiza relógio lógico
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	// Aplicar réplica conforme tipo
-	dataMutex.Lock()
+	srv.dataMutex.Lock()
 	switch req.Data.Type {
 	case "login":
 		// content -> UserLogin
@@ -1165,42 +1243,107 @@ func handleReplication(msg []byte) ([]byte, error) {
 		var ul UserLogin
 		if err := msgpack.Unmarshal(raw, &ul); err == nil {
 			// evitar duplicatas
-			if !userExists(ul.Username) {
-				data.Logins = append(data.Logins, ul)
+			if !srv.userExists(ul.Username) {
+				srv.data.Logins = append(srv.data.Logins, ul)
 			}
 		}
 	case "channel":
 		raw, _ := msgpack.Marshal(req.Data.Content)
-		var ch string
+		var ch Channel
+		if err := msgpack.Unmarshal(raw, &ch); err != nil {
+			// servidores antigos replicam apenas o nome do canal
+			var name string
+			if msgpack.Unmarshal(raw, &name) == nil {
+				ch = Channel{Name: name}
+			}
+		}
+		if ch.Name != "" && !srv.channelExists(ch.Name) {
+			srv.data.Channels = append(srv.data.Channels, ch)
+		}
+	case "channel_update":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var ch Channel
 		if err := msgpack.Unmarshal(raw, &ch); err == nil {
-			if !channelExists(ch) {
-				data.Channels = append(data.Channels, ch)
+			if i := srv.findChannel(ch.Name); i >= 0 {
+				srv.data.Channels[i] = ch
+			} else {
+				srv.data.Channels = append(srv.data.Channels, ch)
 			}
 		}
+	case "channel_rename":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var rn ChannelRename
+		if err := msgpack.Unmarshal(raw, &rn); err == nil {
+			srv.renameChannel(rn.From, rn.To)
+		}
+	case "channel_delete":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var name string
+		if err := msgpack.Unmarshal(raw, &name); err == nil {
+			srv.deleteChannel(name)
+		}
 	case "channel_message":
 		raw, _ := msgpack.Marshal(req.Data.Content)
 		var cm ChannelMessage
-		if err := msgpack.Unmarshal(raw, &cm); err == nil {
-			data.ChannelMessages = append(data.ChannelMessages, cm)
+		if err := msgpack.Unmarshal(raw, &cm); err == nil && !srv.purged(cm.ID) {
+			// Réplica repetida (reenvio ou duplicação na rede) é ignorada
+			if _, known := srv.lookupMessage(cm.ID); !known {
+				srv.insertChannelMessage(cm)
+				srv.indexMessage(cm.ID, cm.Message)
+			}
 		}
 	case "user_message":
 		raw, _ := msgpack.Marshal(req.Data.Content)
 		var um UserMessage
-		if err := msgpack.Unmarshal(raw, &um); err == nil {
-			data.UserMessages = append(data.UserMessages, um)
+		if err := msgpack.Unmarshal(raw, &um); err == nil && !srv.purged(um.ID) {
+			// Réplica repetida (reenvio ou duplicação na rede) é ignorada
+			if _, known := srv.lookupMessage(um.ID); !known {
+				srv.data.UserMessages = append(srv.data.UserMessages, um)
+				srv.indexMessage(um.ID, um.Message)
+			}
+		}
+	case "message_edit":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var me MessageEdit
+		if err := msgpack.Unmarshal(raw, &me); err == nil {
+			srv.applyMessageEdit(me)
+		}
+	case "reaction":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var rc ReactionChange
+		if err := msgpack.Unmarshal(raw, &rc); err == nil {
+			srv.applyReaction(rc)
+		}
+	case "receipt":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var r Receipt
+		if err := msgpack.Unmarshal(raw, &r); err == nil {
+			srv.applyReceipt(r)
+		}
+	case "purge":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var pg Purge
+		if err := msgpack.Unmarshal(raw, &pg); err == nil {
+			srv.purgeMessages(pg.IDs, pg.At)
+		}
+	case "blob":
+		raw, _ := msgpack.Marshal(req.Data.Content)
+		var b Blob
+		if err := msgpack.Unmarshal(raw, &b); err == nil {
+			srv.applyBlob(b)
 		}
 	default:
 		// tipo desconhecido: apenas log
 		log.Printf("handleReplication: tipo desconhecido: %s", req.Data.Type)
 	}
-	_ = saveData() // tenta persistir (ignorar erro aqui)
-	dataMutex.Unlock()
+	_ = srv.writeData() // tenta persistir (ignorar erro aqui)
+	srv.dataMutex.Unlock()
 
 	// Responder OK
 	resp := ReplicationResponse{Service: "replicate"}
 	resp.Data.Status = "OK"
-	resp.Data.Timestamp = getAdjustedTime()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Timestamp = srv.getAdjustedTime()
+	resp.Data.Clock = srv.incrementClock()
 	return msgpack.Marshal(resp)
 }
 
@@ -1210,19 +1353,19 @@ func handleReplication(msg []byte) ([]byte, error) {
 
 // handleElectionRequest — responde a pedidos de eleição.
 // Se receber uma eleição, responde com "OK" e inicia sua própria eleição se tiver rank maior.
-func handleElectionRequest(msg []byte) ([]byte, error) {
+func (srv *Server) handleElectionRequest(msg []byte) ([]byte, error) {
 	var req ElectionRequest
 	if err := msgpack.Unmarshal(msg, &req); err != nil {
 		return nil, err
 	}
 
-	updateClock(req.Data.Clock)
+	srv.updateClock(req.Data.Clock)
 
 	// Responder que recebeu a eleição
 	resp := ElectionResponse{Service: "election"}
 	resp.Data.Election = "OK"
-	resp.Data.Timestamp = getAdjustedTime()
-	resp.Data.Clock = incrementClock()
+	resp.Data.Timestamp = srv.getAdjustedTime()
+	resp.Data.Clock = srv.incrementClock()
 
 	// Se este servidor tem rank maior que o remetente, inicia sua própria eleição
 	// (o payload do request não carrega o nome/ rank do remetente no modelo atual,
@@ -1232,192 +1375,211 @@ func handleElectionRequest(msg []byte) ([]byte, error) {
 }
 
 // Inicia uma eleição Bully simples usando a lista de servidores do reference.
-func initiateElection(refSocket *zmq.Socket) error {
+func (srv *Server) initiateElection(refSocket PeerConn) error {
 	log.Printf("🏳️ Iniciando eleição Bully...")
 
 	// Obter lista de servidores
-	servers, err := getServerList(refSocket)
+	servers, err := srv.getServerList(refSocket)
 	if err != nil {
 		return fmt.Errorf("initiateElection: erro ao obter lista: %v", err)
 	}
 
-	// Se ninguém tem rank maior, me torno coordenador
-	higherExists := false
+	// Pede eleição a quem tem rank maior; se nenhum responder (fora do ar ou
+	// inalcançável), me torno coordenador
 	for _, s := range servers {
-		if s.Name == serverName {
+		if s.Name == srv.serverName || s.Rank <= srv.rank() {
+			continue
+		}
+		sock, err := srv.dial(s.Name)
+		if err != nil {
+			log.Printf("initiateElection: não consegui conectar %s: %v", s.Name, err)
 			continue
 		}
-		if s.Rank > serverRank {
-			higherExists = true
-			// Tentar contatar servidor com rank maior
-			serverURL := fmt.Sprintf("tcp://%s:5555", s.Name)
-			sock, err := createServerSocket(serverURL)
-			if err != nil {
-				log.Printf("initiateElection: não consegui conectar %s: %v", s.Name, err)
-				continue
-			}
-
-			// Envia pedido de eleição
-			req := ElectionRequest{Service: "election"}
-			req.Data.Timestamp = getAdjustedTime()
-			req.Data.Clock = incrementClock()
 
-			reqData, _ := msgpack.Marshal(req)
-			if _, err := sock.SendBytes(reqData, 0); err != nil {
-				log.Printf("initiateElection: erro ao enviar para %s: %v", s.Name, err)
-				sock.Close()
-				continue
-			}
+		// Envia pedido de eleição
+		req := ElectionRequest{Service: "election"}
+		req.Data.Timestamp = srv.getAdjustedTime()
+		req.Data.Clock = srv.incrementClock()
 
-			// Aguarda resposta breve (non-blocking simple)
-			sock.SetRcvtimeo(500 * time.Millisecond)
-			respData, err := sock.RecvBytes(0)
+		reqData, _ := srv.signPeer(req)
+		if _, err := sock.SendBytes(reqData, 0); err != nil {
+			log.Printf("initiateElection: erro ao enviar para %s: %v", s.Name, err)
 			sock.Close()
-			if err == nil && len(respData) > 0 {
-				// recebeu resposta; então existe servidor superior respondendo
-				log.Printf("initiateElection: %s respondeu, candidato superior presente", s.Name)
-				// não me torno coordenador; aguardar anúncio
-				return nil
-			}
+			continue
 		}
-	}
 
-	if !higherExists {
-		// Não há nenhum com rank maior: torno-me coordenador
-		log.Printf("🏆 Nenhum servidor com rank maior encontrado — tornando-me coordenador")
-		coordinatorName = serverName
-		if err := becomeCoordinator(); err != nil {
-			return fmt.Errorf("initiateElection: erro ao anunciar coordenadoria: %v", err)
+		// Aguarda resposta breve
+		sock.SetRcvtimeo(500 * time.Millisecond)
+		respData, err := sock.RecvBytes(0)
+		sock.Close()
+		if err == nil && len(respData) > 0 {
+			// recebeu resposta; então existe servidor superior respondendo
+			log.Printf("initiateElection: %s respondeu, candidato superior presente", s.Name)
+			// não me torno coordenador; aguardar anúncio
+			return nil
 		}
+		log.Printf("initiateElection: %s não respondeu", s.Name)
+	}
+
+	log.Printf("🏆 Nenhum servidor com rank maior respondeu — tornando-me coordenador")
+	srv.setCoordinator(srv.serverName)
+	if err := srv.becomeCoordinator(); err != nil {
+		return fmt.Errorf("initiateElection: erro ao anunciar coordenadoria: %v", err)
 	}
 
 	return nil
 }
 
-// becomeCoordinator — anuncia ao reference (ou à rede) que este servidor é o novo coordenador.
-func becomeCoordinator() error {
-	// Anuncia para o servidor de reference (REQ -> REFERENCE_URL)
-	refURL := os.Getenv("REFERENCE_URL")
-	if refURL == "" {
-		refURL = "tcp://reference:5559"
-	}
-
-	refSock, err := zmq.NewSocket(zmq.REQ)
+// becomeCoordinator — anuncia à referência e aos demais servidores que este
+// servidor é o novo coordenador.
+func (srv *Server) becomeCoordinator() error {
+	refSock, err := srv.dial(referencePeer)
 	if err != nil {
-		return fmt.Errorf("becomeCoordinator: erro ao criar socket: %v", err)
-	}
-	defer refSock.Close()
-
-	if err := refSock.Connect(refURL); err != nil {
 		return fmt.Errorf("becomeCoordinator: erro ao conectar reference: %v", err)
 	}
 
 	ann := CoordinatorAnnouncement{Service: "coordinator"}
-	ann.Data.Coordinator = serverName
-	ann.Data.Timestamp = getAdjustedTime()
-	ann.Data.Clock = incrementClock()
+	ann.Data.Coordinator = srv.serverName
+	ann.Data.Timestamp = srv.getAdjustedTime()
+	ann.Data.Clock = srv.incrementClock()
 
 	data, err := msgpack.Marshal(ann)
 	if err != nil {
+		refSock.Close()
 		return fmt.Errorf("becomeCoordinator: erro ao serializar announcement: %v", err)
 	}
 
 	if _, err := refSock.SendBytes(data, 0); err != nil {
+		refSock.Close()
 		return fmt.Errorf("becomeCoordinator: erro ao enviar announcement: %v", err)
 	}
 
 	// opcional: aguarda ACK
 	_, _ = refSock.RecvBytes(0)
-	log.Printf("👑 Anúncio de coordenador enviado: %s", serverName)
+	log.Printf("👑 Anúncio de coordenador enviado: %s", srv.serverName)
+
+	// A referência não repassa o anúncio: os demais servidores são avisados
+	// diretamente, em segundo plano (um servidor inalcançável espera peerTimeout)
+	srv.spawn(func() {
+		defer refSock.Close()
+		servers, err := srv.getServerList(refSock)
+		if err != nil {
+			log.Printf("⚠️  becomeCoordinator: erro ao obter lista: %v", err)
+			return
+		}
+		for _, s := range servers {
+			if s.Name == srv.serverName {
+				continue
+			}
+			if _, err := srv.callPeer(s.Name, ann); err != nil {
+				log.Printf("⚠️  Anúncio de coordenador para %s falhou: %v", s.Name, err)
+			}
+		}
+	})
 	return nil
 }
 
+// handleCoordinatorAnnouncement aplica o anúncio enviado por um novo coordenador.
+func (srv *Server) handleCoordinatorAnnouncement(msg []byte) ([]byte, error) {
+	var ann CoordinatorAnnouncement
+	if err := msgpack.Unmarshal(msg, &ann); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(ann.Data.Clock)
+	srv.setCoordinator(ann.Data.Coordinator)
+	log.Printf("📣 Recebido anúncio de coordenador: %s (clock: %d)", ann.Data.Coordinator, ann.Data.Clock)
+
+	resp := CoordinatorAck{Service: "coordinator"}
+	resp.Data.Status = "OK"
+	resp.Data.Timestamp = srv.getAdjustedTime()
+	resp.Data.Clock = srv.incrementClock()
+	return msgpack.Marshal(resp)
+}
+
 // ----------------------------
 // Checagem de health do coordenador
 // ----------------------------
 
-func checkCoordinatorHealth(refSocket *zmq.Socket) {
-	// Se não houver coordenador conhecido, tenta determinar
-	if coordinatorName == "" || coordinatorName == serverName {
+func (srv *Server) checkCoordinatorHealth(refSocket PeerConn) {
+	// Sem coordenador conhecido, ou sendo o próprio, não há o que checar
+	coordinator := srv.coordinator()
+	if coordinator == "" || coordinator == srv.serverName {
 		return
 	}
 
-	coordinatorURL := fmt.Sprintf("tcp://%s:5555", coordinatorName)
-	sock, err := createServerSocket(coordinatorURL)
+	clock, err := srv.pingPeer(coordinator)
 	if err != nil {
-		log.Printf("checkCoordinatorHealth: não conseguiu conectar ao coordenador %s: %v", coordinatorName, err)
-		// iniciar eleição
-		go initiateElection(refSocket)
+		log.Printf("checkCoordinatorHealth: coordenador %s não respondeu (%v), iniciando eleição", coordinator, err)
+		srv.spawn(func() { srv.initiateElection(refSocket) })
 		return
 	}
+	log.Printf("checkCoordinatorHealth: coordenador %s está ativo (clock: %d)", coordinator, clock)
+}
+
+// pingPeer envia um heartbeat a outro servidor e espera a resposta por até
+// 500ms; qualquer resposta indica que ele está no ar. Devolve o relógio dela.
+func (srv *Server) pingPeer(peer string) (int64, error) {
+	sock, err := srv.dial(peer)
+	if err != nil {
+		return 0, err
+	}
 	defer sock.Close()
 
 	// Envia heartbeat (usando o mesmo formato)
 	req := HeartbeatRequest{Service: "heartbeat"}
-	req.Data.User = serverName
-	req.Data.Timestamp = getAdjustedTime()
-	req.Data.Clock = incrementClock()
+	req.Data.User = srv.serverName
+	req.Data.Timestamp = srv.getAdjustedTime()
+	req.Data.Clock = srv.incrementClock()
 
 	reqData, _ := msgpack.Marshal(req)
 	if _, err := sock.SendBytes(reqData, 0); err != nil {
-		log.Printf("checkCoordinatorHealth: erro ao enviar heartbeat para %s: %v", coordinatorName, err)
-		go initiateElection(refSocket)
-		return
+		return 0, err
 	}
 
 	// Aguarda resposta com timeout curto
 	sock.SetRcvtimeo(500 * time.Millisecond)
 	respData, err := sock.RecvBytes(0)
-	if err != nil || len(respData) == 0 {
-		log.Printf("checkCoordinatorHealth: coordenador %s não respondeu, iniciando eleição", coordinatorName)
-		go initiateElection(refSocket)
-		return
+	if err != nil {
+		return 0, err
+	}
+	if len(respData) == 0 {
+		return 0, fmt.Errorf("resposta vazia")
 	}
 
-	// Se respondeu, apenas atualize relógio
 	var resp struct {
 		Service string `msgpack:"service"`
 		Data    struct {
-			Status    string `msgpack:"status"`
-			Timestamp int64  `msgpack:"timestamp"`
-			Clock     int64  `msgpack:"clock"`
+			Clock int64 `msgpack:"clock"`
 		} `msgpack:"data"`
 	}
 	if err := msgpack.Unmarshal(respData, &resp); err == nil {
-		updateClock(resp.Data.Clock)
-		log.Printf("checkCoordinatorHealth: coordenador %s está ativo (clock: %d)", coordinatorName, resp.Data.Clock)
+		srv.updateClock(resp.Data.Clock)
 	}
+	return resp.Data.Clock, nil
 }
 
 // ----------------------------
 // Sincronização periódica (Parte 5)
 // ----------------------------
 
-func startSyncRoutine(refSocket *zmq.Socket) {
-	go func() {
-		ticker := time.NewTicker(60 * time.Second) // intervalo de sync configurável
-		defer ticker.Stop()
-		for range ticker.C {
-			// Se sou coordenador, faço sincronização Berkeley (coletar timestamps)
-			if coordinatorName == serverName {
-				if err := berkeleyCoordinator(refSocket); err != nil {
-					log.Printf("startSyncRoutine: erro na sincronização Berkeley: %v", err)
-				}
+func (srv *Server) startSyncRoutine(refSocket PeerConn) {
+	srv.every(srv.cfg.SyncInterval, func() {
+		// Se sou coordenador, faço sincronização Berkeley (coletar timestamps)
+		if srv.isCoordinator() {
+			if err := srv.berkeleyCoordinator(refSocket); err != nil {
+				log.Printf("startSyncRoutine: erro na sincronização Berkeley: %v", err)
 			}
 		}
-	}()
+	})
 }
 
 // ----------------------------
 // Subscrição a anúncios de coordenador (PUB/SUB)
 // ----------------------------
 
-func subscribeToCoordinatorAnnouncements() {
-	announceURL := os.Getenv("COORD_ANNOUNCE_URL")
-	if announceURL == "" {
-		// padrão: referência publica anúncios em 5560 (ajuste conforme sua infra)
-		announceURL = "tcp://reference:5560"
-	}
+func (srv *Server) subscribeToCoordinatorAnnouncements() {
+	announceURL := srv.cfg.AnnounceURL
 
 	subSock, err := zmq.NewSocket(zmq.SUB)
 	if err != nil {
@@ -1434,11 +1596,21 @@ func subscribeToCoordinatorAnnouncements() {
 	// receber tudo
 	subSock.SetSubscribe("") // subscribe to all topics
 
-	for {
-		msg, err := subSock.RecvBytes(0)
+	poller := zmq.NewPoller()
+	poller.Add(subSock, zmq.POLLIN)
+
+	for srv.running() {
+		polled, err := poller.Poll(pollInterval)
+		if err == nil && len(polled) == 0 {
+			continue
+		}
+		var msg []byte
+		if err == nil {
+			msg, err = subSock.RecvBytes(0)
+		}
 		if err != nil {
 			log.Printf("subscribeToCoordinatorAnnouncements: erro ao receber: %v", err)
-			time.Sleep(1 * time.Second)
+			srv.sleep(1 * time.Second)
 			continue
 		}
 
@@ -1449,164 +1621,323 @@ func subscribeToCoordinatorAnnouncements() {
 		}
 
 		// Atualiza estado local
-		coordinatorName = ann.Data.Coordinator
-		updateClock(ann.Data.Clock)
-		log.Printf("📣 Recebido anúncio de coordenador: %s (clock: %d)", coordinatorName, ann.Data.Clock)
+		srv.setCoordinator(ann.Data.Coordinator)
+		srv.updateClock(ann.Data.Clock)
+		log.Printf("📣 Recebido anúncio de coordenador: %s (clock: %d)", ann.Data.Coordinator, ann.Data.Clock)
 	}
 }
 
 func main() {
 	log.Println("🚀 Iniciando servidor...")
 
-	// Obter nome do servidor da variável de ambiente
-	serverName = os.Getenv("SERVER_NAME")
-	if serverName == "" {
-		serverName = "server-default"
+	srv := newServer(configFromEnv())
+	if err := srv.run(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+// pollInterval é a espera máxima dos loops de recepção antes de conferir se
+// o servidor está parando.
+const pollInterval = 100 * time.Millisecond
+
+// run inicia o servidor e atende requisições até Stop.
+func (srv *Server) run() error {
+	defer close(srv.stopped)
+
+	log.Printf("📛 Nome do servidor: %s", srv.serverName)
+	if srv.cfg.ClusterToken == "" {
+		log.Printf("⚠️  CLUSTER_TOKEN não definido: replicação, eleição e Berkeley entre servidores serão recusadas")
 	}
-	log.Printf("📛 Nome do servidor: %s", serverName)
 
 	// Carregar dados persistentes
-	if err := loadData(); err != nil {
-		log.Fatalf("❌ Erro ao carregar dados: %v", err)
+	if err := srv.loadData(); err != nil {
+		return fmt.Errorf("Erro ao carregar dados: %v", err)
 	}
 	log.Printf("📊 Dados carregados: %d logins, %d canais, %d msgs canal, %d msgs usuário",
-		len(data.Logins), len(data.Channels), len(data.ChannelMessages), len(data.UserMessages))
+		len(srv.data.Logins), len(srv.data.Channels), len(srv.data.ChannelMessages), len(srv.data.UserMessages))
+	srv.rebuildSearchIndex()
 
 	// Conectar ao servidor de referência
-	refURL := os.Getenv("REFERENCE_URL")
-	if refURL == "" {
-		refURL = "tcp://reference:5559"
-	}
+	refURL := srv.cfg.ReferenceURL
 
-	refSocket, err := zmq.NewSocket(zmq.REQ)
+	refSocket, err := srv.dial(referencePeer)
 	if err != nil {
-		log.Fatalf("❌ Erro ao criar socket de referência: %v", err)
+		return fmt.Errorf("Erro ao conectar ao servidor de referência: %v", err)
 	}
 	defer refSocket.Close()
-
-	err = refSocket.Connect(refURL)
-	if err != nil {
-		log.Fatalf("❌ Erro ao conectar ao servidor de referência: %v", err)
-	}
 	log.Printf("🔌 Conectado ao servidor de referência: %s", refURL)
 
 	// Aguardar um pouco para garantir conexão
-	time.Sleep(2 * time.Second)
+	time.Sleep(srv.cfg.Settle)
 
 	// Registrar no servidor de referência e obter rank
-	if err := registerWithReference(refSocket); err != nil {
-		log.Fatalf("❌ Erro ao registrar no servidor de referência: %v", err)
+	if err := srv.registerWithReference(refSocket); err != nil {
+		return fmt.Errorf("Erro ao registrar no servidor de referência: %v", err)
 	}
 
+	// As rotinas de fundo usam refSocket; só fechá-lo depois que terminarem
+	defer srv.wg.Wait()
+
 	// Iniciar rotina de heartbeat
-	startHeartbeatRoutine(refSocket)
+	srv.startHeartbeatRoutine(refSocket)
 
 	// Iniciar rotina de sincronização periódica (Parte 5)
-	startSyncRoutine(refSocket)
+	srv.startSyncRoutine(refSocket)
+
+	// Iniciar rotina de retenção de histórico
+	srv.startRetentionRoutine()
+	srv.startHTTPGateway()
+	srv.startGRPCServer()
 
 	// Iniciar goroutine para receber anúncios de coordenador
-	go subscribeToCoordinatorAnnouncements()
+	srv.spawn(srv.subscribeToCoordinatorAnnouncements)
 
 	// Aguardar um pouco para subscrição
-	time.Sleep(2 * time.Second)
+	time.Sleep(srv.cfg.Settle)
 
 	// Determinar coordenador inicial
-	initialCoordinator, err := determineCoordinator(refSocket)
+	initialCoordinator, err := srv.determineCoordinator(refSocket)
 	if err != nil {
 		log.Printf("⚠️  Erro ao determinar coordenador inicial: %v", err)
 	} else {
-		coordinatorName = initialCoordinator
-		log.Printf("👑 Coordenador inicial: %s", coordinatorName)
+		srv.setCoordinator(initialCoordinator)
+		log.Printf("👑 Coordenador inicial: %s", initialCoordinator)
 
 		// Se sou o coordenador, anunciar
-		if coordinatorName == serverName {
-			becomeCoordinator()
+		if initialCoordinator == srv.serverName {
+			srv.becomeCoordinator()
 		}
 	}
 
-	// Configurar socket REQ-REP
-	repSocket, err := zmq.NewSocket(zmq.REP)
+	// Configurar socket de requisições. ROUTER é compatível com os clientes REQ
+	// e expõe a identidade de cada conexão (usada no limite de taxa).
+	repSocket, err := zmq.NewSocket(zmq.ROUTER)
 	if err != nil {
-		log.Fatalf("❌ Erro ao criar socket REP: %v", err)
+		return fmt.Errorf("Erro ao criar socket ROUTER: %v", err)
 	}
 	defer repSocket.Close()
+	repSocket.SetLinger(0)
 
-	err = repSocket.Bind("tcp://*:5555")
+	err = repSocket.Bind(srv.cfg.Bind)
 	if err != nil {
-		log.Fatalf("❌ Erro ao fazer bind REP: %v", err)
+		return fmt.Errorf("Erro ao fazer bind ROUTER: %v", err)
 	}
-	log.Println("📡 Socket REP escutando na porta 5555...")
+	log.Printf("📡 Socket ROUTER escutando em %s...", srv.cfg.Bind)
+
+	// Limites de taxa configurados por ambiente
+	srv.loadRateLimits()
 
 	// Configurar socket PUB (conecta ao broker XSUB)
-	pubSocket, err = zmq.NewSocket(zmq.PUB)
+	srv.pubSocket, err = zmq.NewSocket(zmq.PUB)
 	if err != nil {
-		log.Fatalf("❌ Erro ao criar socket PUB: %v", err)
+		return fmt.Errorf("Erro ao criar socket PUB: %v", err)
 	}
-	defer pubSocket.Close()
+	defer srv.pubSocket.Close()
+	srv.pubSocket.SetLinger(0)
 
-	brokerURL := "tcp://broker:5557"
-	err = pubSocket.Connect(brokerURL)
+	brokerURL := srv.cfg.BrokerURL
+	err = srv.pubSocket.Connect(brokerURL)
 	if err != nil {
-		log.Fatalf("❌ Erro ao conectar ao broker: %v", err)
+		return fmt.Errorf("Erro ao conectar ao broker: %v", err)
 	}
 	log.Printf("🔌 Socket PUB conectado ao broker em %s", brokerURL)
 
-	log.Printf("✅ Servidor '%s' (rank %d) pronto para receber requisições!", serverName, serverRank)
+	log.Printf("✅ Servidor '%s' (rank %d) pronto para receber requisições!", srv.serverName, srv.rank())
 	log.Println("=" + strings.Repeat("=", 70))
+	close(srv.ready)
+
+	poller := zmq.NewPoller()
+	poller.Add(repSocket, zmq.POLLIN)
 
 	// Loop principal
-	for {
-		msg, err := repSocket.RecvBytes(0)
+	for srv.running() {
+		polled, err := poller.Poll(pollInterval)
+		if err == nil && len(polled) == 0 {
+			continue
+		}
+		var frames [][]byte
+		if err == nil {
+			frames, err = repSocket.RecvMessageBytes(0)
+		}
 		if err != nil {
 			log.Printf("❌ Erro ao receber mensagem: %v", err)
 			continue
 		}
 
+		// Envelope do REQ: [identidade, vazio, payload]
+		if len(frames) < 3 {
+			log.Printf("❌ Envelope inválido (%d partes)", len(frames))
+			continue
+		}
+		identity, frame := frames[0], frames[len(frames)-1]
+		conn := hex.EncodeToString(identity)
+
+		// Traduzir JSON/Protobuf para MessagePack, o formato usado pelos handlers
+		format, payload, prefixed := srv.frameFormat(conn, frame)
+		msg, decodeErr := decodeFrame(format, payload)
+
 		// Identificar o tipo de serviço
 		var baseReq struct {
 			Service string `msgpack:"service"`
 		}
-		if err := msgpack.Unmarshal(msg, &baseReq); err != nil {
-			log.Printf("❌ Erro ao parsear mensagem: %v", err)
-			errorResp, _ := msgpack.Marshal(map[string]string{"error": "Formato de mensagem inválido"})
-			repSocket.SendBytes(errorResp, 0)
-			continue
+
+		// A resposta segue a versão do cliente (v2: envelope com request_id) e o codec da requisição
+		reqID := srv.requestID(msg)
+		reply := func(response []byte) {
+			version, ok := srv.clientVersion(conn, msg)
+			if !ok {
+				version = ProtocolVersion
+			}
+			encoded, err := encodeResponse(version, reqID, response)
+			if err != nil {
+				log.Printf("❌ Erro ao montar envelope da resposta: %v", err)
+				encoded, _ = encodeResponse(version, reqID, srv.errorPayload(baseReq.Service, ErrInternal, "Erro interno ao montar resposta"))
+			}
+			if out, err := encodeFrame(format, prefixed, encoded); err != nil {
+				log.Printf("❌ Erro ao codificar resposta em %s: %v", codecs[format].Name(), err)
+			} else {
+				encoded = out
+			}
+			repSocket.SendMessage(identity, "", encoded)
 		}
 
-		var response []byte
-		switch baseReq.Service {
-		case "login":
-			response, err = handleLogin(msg)
-		case "users":
-			response, err = handleUsers(msg)
-		case "channel":
-			response, err = handleChannel(msg)
-		case "channels":
-			response, err = handleChannels(msg)
-		case "publish":
-			response, err = handlePublish(msg)
-		case "message":
-			response, err = handleMessage(msg)
-		case "clock":
-			response, err = handleClockRequest(msg)
-		case "adjust":
-			response, err = handleClockAdjustment(msg)
-		case "election":
-			response, err = handleElectionRequest(msg)
-		case "replicate":
-			response, err = handleReplication(msg)
-		default:
-			response, _ = msgpack.Marshal(map[string]string{"error": fmt.Sprintf("Serviço desconhecido: %s", baseReq.Service)})
+		if decodeErr == nil {
+			decodeErr = msgpack.Unmarshal(msg, &baseReq)
 		}
+		if decodeErr != nil {
+			log.Printf("❌ Erro ao parsear mensagem (%s): %v", codecs[format].Name(), decodeErr)
+			reply(srv.errorPayload("", ErrInvalidPayload, "Formato de mensagem inválido"))
+			continue
+		}
+
+		reply(srv.processRequest(baseReq.Service, conn, msg))
 
+		// Verificar e sincronizar se necessário (a cada 10 mensagens)
+		srv.checkAndSyncIfNeeded(refSocket)
+	}
+
+	log.Printf("🛑 Servidor '%s' encerrado", srv.serverName)
+	return nil
+}
+
+// processRequest aplica versão, validação e limite de taxa e chama o handler
+// do serviço. É usada pelo socket ZeroMQ e pelo gateway HTTP; requestMutex
+// mantém os handlers e o socket PUB com uma requisição por vez, como no loop
+// original.
+func (srv *Server) processRequest(service, conn string, msg []byte) []byte {
+	srv.requestMutex.Lock()
+	defer srv.requestMutex.Unlock()
+
+	if version, ok := srv.clientVersion(conn, msg); !ok {
+		log.Printf("⚠️  Requisição %s com versão de protocolo não suportada: %d", service, version)
+		return srv.unsupportedVersionResponse(service, version)
+	}
+
+	// Serviços administrativos: só a assinatura é conferida (sem limites de
+	// tamanho ou de taxa, para permitir dump/restore de arquivos grandes)
+	if isAdminService(service) {
+		if verr := srv.checkAdminAuth(service, msg); verr != nil {
+			log.Printf("🔒 Requisição %s recusada: %v", service, verr)
+			response, _ := srv.errorResponse(service, verr)
+			return response
+		}
+		response, err := srv.handleAdmin(service, msg)
 		if err != nil {
 			log.Printf("❌ Erro ao processar requisição: %v", err)
-			response, _ = msgpack.Marshal(map[string]string{"error": err.Error()})
+			response = srv.errorPayload(service, ErrInvalidPayload, err.Error())
 		}
+		return response
+	}
 
-		repSocket.SendBytes(response, 0)
+	// Serviços entre servidores exigem a assinatura do cluster; o nome do
+	// serviço sozinho não tira a requisição do limite dos clientes
+	peer := peerServices[service] && len(msg) <= maxPeerPayloadSize() && srv.checkPeerAuth(service, msg)
+	if peerServices[service] && !peer {
+		log.Printf("🔒 Requisição %s recusada: sem assinatura de servidor do cluster", service)
+		response, _ := srv.errorResponse(service, &ValidationError{ErrForbidden, "", "Serviço restrito aos servidores do cluster"})
+		return response
+	}
 
-		// Verificar e sincronizar se necessário (a cada 10 mensagens)
-		checkAndSyncIfNeeded(refSocket)
+	if verr := srv.validateRequest(service, msg, peer); verr != nil {
+		log.Printf("⚠️  Requisição %s rejeitada na validação: %v", service, verr)
+		response, _ := srv.errorResponse(service, verr)
+		return response
+	}
+
+	if ok, retryAfter := srv.checkRateLimit(service, conn, msg); !ok {
+		log.Printf("🚦 Requisição %s barrada pelo limite de taxa (retry_after: %v)", service, retryAfter)
+		response, _ := srv.rateLimitedResponse(service, retryAfter)
+		return response
+	}
+
+	var response []byte
+	var err error
+	switch service {
+	case "hello":
+		response, err = srv.handleHello(conn, msg)
+	case "login":
+		response, err = srv.handleLogin(msg)
+	case "users":
+		response, err = srv.handleUsers(msg)
+	case "channel":
+		response, err = srv.handleChannel(msg)
+	case "channels":
+		response, err = srv.handleChannels(msg)
+	case "channel_update":
+		response, err = srv.handleChannelUpdate(msg)
+	case "channel_rename":
+		response, err = srv.handleChannelRename(msg)
+	case "channel_archive":
+		response, err = srv.handleChannelArchive(msg)
+	case "channel_delete":
+		response, err = srv.handleChannelDelete(msg)
+	case "publish":
+		response, err = srv.handlePublish(msg)
+	case "message":
+		response, err = srv.handleMessage(msg)
+	case "edit_message":
+		response, err = srv.handleEditMessage(msg)
+	case "delete_message":
+		response, err = srv.handleDeleteMessage(msg)
+	case "react":
+		response, err = srv.handleReact(msg)
+	case "unreact":
+		response, err = srv.handleUnreact(msg)
+	case "receipt":
+		response, err = srv.handleReceipt(msg)
+	case "pending":
+		response, err = srv.handlePending(msg)
+	case "upload":
+		response, err = srv.handleUpload(msg)
+	case "download":
+		response, err = srv.handleDownload(msg)
+	case "search":
+		response, err = srv.handleSearch(msg)
+	case "rate_limits":
+		response, err = srv.handleRateLimits(msg)
+	case "history":
+		response, err = srv.handleHistory(msg)
+	case "direct_history":
+		response, err = srv.handleDirectHistory(msg)
+	case "thread":
+		response, err = srv.handleThread(msg)
+	case "clock":
+		response, err = srv.handleClockRequest(msg)
+	case "adjust":
+		response, err = srv.handleClockAdjustment(msg)
+	case "election":
+		response, err = srv.handleElectionRequest(msg)
+	case "replicate":
+		response, err = srv.handleReplication(msg)
+	case "coordinator":
+		response, err = srv.handleCoordinatorAnnouncement(msg)
+	default:
+		response = srv.errorPayload(service, ErrUnknownService, fmt.Sprintf("Serviço desconhecido: %s", service))
+	}
+
+	if err != nil {
+		log.Printf("❌ Erro ao processar requisição: %v", err)
+		response = srv.errorPayload(service, ErrInvalidPayload, err.Error())
 	}
+	return response
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/protocol"
+)
+
+// ----------------------------
+// Autenticação entre servidores
+// ----------------------------
+
+// Os serviços peerServices chegam pelo mesmo socket dos clientes, então o
+// nome do serviço não prova nada. Cada requisição de um servidor leva em
+// "peer" uma assinatura HMAC-SHA256 com CLUSTER_TOKEN (Config.ClusterToken)
+// sobre o serviço, o remetente, o timestamp, um nonce e o SHA-256 dos bytes
+// de "data", que seguem na requisição exatamente como foram assinados. Sem
+// CLUSTER_TOKEN, nenhuma requisição é aceita como de servidor.
+
+// peerServices só são atendidos para requisições assinadas por outro
+// servidor (ver checkPeerAuth).
+var peerServices = map[string]bool{
+	"replicate":   true,
+	"election":    true,
+	"clock":       true,
+	"adjust":      true,
+	"coordinator": true,
+}
+
+// peerMaxSkew é a validade de uma assinatura entre servidores, em segundos.
+const peerMaxSkew = protocol.AdminMaxSkew
+
+type peerAuth struct {
+	From      string `msgpack:"from"`
+	Timestamp int64  `msgpack:"timestamp"`
+	Nonce     string `msgpack:"nonce"`
+	Auth      string `msgpack:"auth"`
+}
+
+// signedPeerRequest é a forma em que uma requisição entre servidores trafega:
+// Data guarda os bytes assinados, e os handlers a decodificam como qualquer
+// outra requisição (o campo "peer" é ignorado por eles).
+type signedPeerRequest struct {
+	Service string             `msgpack:"service"`
+	Data    msgpack.RawMessage `msgpack:"data"`
+	Peer    *peerAuth          `msgpack:"peer,omitempty"`
+}
+
+func peerSignature(token, service string, a peerAuth, data []byte) string {
+	sum := sha256.Sum256(data)
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(service + "\n" + a.From + "\n" + strconv.FormatInt(a.Timestamp, 10) + "\n" +
+		a.Nonce + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signPeer serializa req (uma requisição com service e data) assinada por
+// este servidor.
+func (srv *Server) signPeer(req interface{}) ([]byte, error) {
+	raw, err := msgpack.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var signed signedPeerRequest
+	if err := msgpack.Unmarshal(raw, &signed); err != nil {
+		return nil, err
+	}
+	a := peerAuth{From: srv.serverName, Timestamp: time.Now().Unix(), Nonce: protocol.NewAdminNonce()}
+	a.Auth = peerSignature(srv.cfg.ClusterToken, signed.Service, a, signed.Data)
+	signed.Peer = &a
+	return msgpack.Marshal(signed)
+}
+
+// checkPeerAuth informa se msg foi assinada por um servidor do cluster. Uma
+// assinatura repetida (mesmo nonce) dentro da validade é recusada.
+func (srv *Server) checkPeerAuth(service string, msg []byte) bool {
+	token := srv.cfg.ClusterToken
+	if token == "" {
+		return false
+	}
+	var req signedPeerRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil || req.Peer == nil || req.Peer.Nonce == "" {
+		return false
+	}
+	a := *req.Peer
+	skew := time.Now().Unix() - a.Timestamp
+	if skew < -peerMaxSkew || skew > peerMaxSkew {
+		return false
+	}
+	if !hmac.Equal([]byte(peerSignature(token, service, a, req.Data)), []byte(a.Auth)) {
+		return false
+	}
+	return srv.peerNonces.use(a.From+"/"+a.Nonce, a.Timestamp+peerMaxSkew)
+}
+
+// nonceCache guarda nonces de assinaturas válidas até elas expirarem. O valor
+// zero está pronto para uso.
+type nonceCache struct {
+	mu        sync.Mutex
+	expires   map[string]int64 // nonce -> expiração da assinatura (Unix)
+	lastPurge int64
+}
+
+// use registra nonce e informa se ele ainda não tinha sido usado. Depois de
+// expires, uma repetição já é recusada pelo timestamp e o nonce é esquecido.
+func (c *nonceCache) use(nonce string, expires int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().Unix()
+	if c.expires == nil {
+		c.expires = make(map[string]int64)
+	}
+	if now != c.lastPurge {
+		for n, at := range c.expires {
+			if at < now {
+				delete(c.expires, n)
+			}
+		}
+		c.lastPurge = now
+	}
+	if _, used := c.expires[nonce]; used {
+		return false
+	}
+	c.expires[nonce] = expires
+	return true
+}
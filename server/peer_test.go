@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func replicateRequest(size int) ReplicationRequest {
+	req := ReplicationRequest{Service: "replicate"}
+	req.Data.Type = "purge"
+	req.Data.Content = map[string]interface{}{"ids": []string{"m1"}, "pad": string(bytes.Repeat([]byte("x"), size))}
+	return req
+}
+
+func TestPeerAuth(t *testing.T) {
+	a := newServer(Config{Name: "a", ClusterToken: testClusterToken})
+	b := newServer(Config{Name: "b", ClusterToken: testClusterToken})
+
+	msg, err := a.signPeer(replicateRequest(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.checkPeerAuth("replicate", msg) {
+		t.Fatal("requisição assinada recusada")
+	}
+	if b.checkPeerAuth("replicate", msg) {
+		t.Error("requisição repetida aceita")
+	}
+
+	// Outro serviço, outro conteúdo ou outro segredo invalidam a assinatura
+	msg, _ = a.signPeer(replicateRequest(0))
+	if b.checkPeerAuth("election", msg) {
+		t.Error("assinatura aceita para outro serviço")
+	}
+	tampered := bytes.Replace(msg, []byte("m1"), []byte("m2"), 1)
+	if b.checkPeerAuth("replicate", tampered) {
+		t.Error("conteúdo alterado aceito")
+	}
+	other := newServer(Config{Name: "c", ClusterToken: "outro"})
+	if other.checkPeerAuth("replicate", msg) {
+		t.Error("assinatura aceita com outro CLUSTER_TOKEN")
+	}
+	unsigned, _ := msgpack.Marshal(replicateRequest(0))
+	if b.checkPeerAuth("replicate", unsigned) {
+		t.Error("requisição sem assinatura aceita")
+	}
+}
+
+// Um cliente não chega aos serviços entre servidores nem escapa do limite de
+// tamanho usando o nome deles.
+func TestPeerServicesRejectClients(t *testing.T) {
+	srv := newServer(Config{Name: "a", ClusterToken: testClusterToken})
+	for _, size := range []int{0, 2 * defaultMaxPayloadLen} {
+		msg, _ := msgpack.Marshal(replicateRequest(size))
+		var resp ErrorResponse
+		if err := msgpack.Unmarshal(srv.processRequest("replicate", "cliente", msg), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Data.Error != ErrForbidden {
+			t.Errorf("replicate sem assinatura (%d bytes): erro %q", len(msg), resp.Data.Error)
+		}
+	}
+}
+
+// replicate envia a srv uma réplica do tipo typ, assinada por from (sem
+// assinatura se from for nil), e devolve o código de erro da resposta.
+func replicate(t *testing.T, srv, from *Server, typ string, content interface{}) string {
+	t.Helper()
+	req := ReplicationRequest{Service: "replicate"}
+	req.Data.Type = typ
+	req.Data.Content = content
+	msg, _ := msgpack.Marshal(req)
+	if from != nil {
+		msg, _ = from.signPeer(req)
+	}
+	var resp ErrorResponse
+	if err := msgpack.Unmarshal(srv.processRequest("replicate", "conexao", msg), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data.Error
+}
+
+// peerPair devolve um servidor com dados em disco temporário e outro
+// servidor do mesmo cluster para assinar as réplicas.
+func peerPair(t *testing.T) (srv, peer *Server) {
+	srv = newServer(Config{Name: "a", ClusterToken: testClusterToken, DataDir: t.TempDir()})
+	peer = newServer(Config{Name: "b", ClusterToken: testClusterToken})
+	return srv, peer
+}
+
+func TestReplicatedPurgeNeedsPeer(t *testing.T) {
+	srv, peer := peerPair(t)
+	srv.data.Channels = []Channel{{Name: "geral"}}
+	srv.data.ChannelMessages = []ChannelMessage{{ID: "m1", Channel: "geral", User: "alice", Message: "oi"}}
+	purge := Purge{IDs: []string{"m1"}, At: 1}
+
+	if code := replicate(t, srv, nil, "purge", purge); code != ErrForbidden {
+		t.Fatalf("purge sem assinatura: erro %q", code)
+	}
+	if _, ok := srv.lookupMessage("m1"); !ok || srv.purged("m1") {
+		t.Fatal("purge sem assinatura apagou a mensagem")
+	}
+	if code := replicate(t, srv, peer, "purge", purge); code != "" {
+		t.Fatalf("purge assinado: erro %q", code)
+	}
+	if _, ok := srv.lookupMessage("m1"); ok || !srv.purged("m1") {
+		t.Error("purge assinado não foi aplicado")
+	}
+}
+
+// As alterações de canal replicadas não passam pela checagem de dono e
+// moderador; só outro servidor pode enviá-las.
+func TestReplicatedChannelChangesNeedPeer(t *testing.T) {
+	srv, peer := peerPair(t)
+	srv.data.Channels = []Channel{{Name: "geral", Owner: "alice", Topic: "oi"}}
+
+	for _, tc := range []struct {
+		typ     string
+		content interface{}
+	}{
+		{"channel_update", Channel{Name: "geral", Owner: "mallory", Topic: "invadido"}},
+		{"channel_rename", ChannelRename{From: "geral", To: "outro"}},
+		{"channel_delete", "geral"},
+	} {
+		if code := replicate(t, srv, nil, tc.typ, tc.content); code != ErrForbidden {
+			t.Errorf("%s sem assinatura: erro %q", tc.typ, code)
+		}
+	}
+	if i := srv.findChannel("geral"); i < 0 || srv.data.Channels[i].Owner != "alice" || srv.data.Channels[i].Topic != "oi" {
+		t.Fatalf("canal alterado sem assinatura: %+v", srv.data.Channels)
+	}
+
+	if code := replicate(t, srv, peer, "channel_rename", ChannelRename{From: "geral", To: "outro"}); code != "" {
+		t.Fatalf("channel_rename assinado: erro %q", code)
+	}
+	if srv.findChannel("outro") < 0 {
+		t.Error("channel_rename assinado não foi aplicado")
+	}
+}
+
+// Edições, reações e confirmações replicadas não passam pelas checagens de
+// autor, participante e destinatário; só outro servidor pode enviá-las.
+func TestReplicatedMessageChangesNeedPeer(t *testing.T) {
+	srv, peer := peerPair(t)
+	srv.data.Channels = []Channel{{Name: "geral"}}
+	srv.data.ChannelMessages = []ChannelMessage{{ID: "m1", Channel: "geral", User: "alice", Message: "oi"}}
+	srv.data.UserMessages = []UserMessage{{ID: "d1", Src: "alice", Dst: "bob", Message: "oi"}}
+
+	edit := MessageEdit{ID: "m1", Message: "invadido", By: "mallory", At: 1}
+	for _, tc := range []struct {
+		typ     string
+		content interface{}
+	}{
+		{"message_edit", edit},
+		{"reaction", ReactionChange{ID: "m1", User: "mallory", Emoji: "👎"}},
+		{"receipt", Receipt{ID: "d1", Status: receiptRead, At: 1}},
+	} {
+		if code := replicate(t, srv, nil, tc.typ, tc.content); code != ErrForbidden {
+			t.Errorf("%s sem assinatura: erro %q", tc.typ, code)
+		}
+	}
+	cm := srv.data.ChannelMessages[0]
+	if cm.Message != "oi" || len(cm.History) != 0 || len(cm.Reactions) != 0 {
+		t.Errorf("mensagem alterada sem assinatura: %+v", cm)
+	}
+	if um := srv.data.UserMessages[0]; um.DeliveredAt != 0 || um.ReadAt != 0 {
+		t.Errorf("confirmação aplicada sem assinatura: %+v", um)
+	}
+
+	if code := replicate(t, srv, peer, "message_edit", edit); code != "" {
+		t.Fatalf("message_edit assinado: erro %q", code)
+	}
+	if srv.data.ChannelMessages[0].Message != "invadido" {
+		t.Error("message_edit assinado não foi aplicado")
+	}
+}
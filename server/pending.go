@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Fila de mensagens diretas pendentes (store-and-forward)
+// ----------------------------
+
+// O broker descarta publicações em tópicos sem inscritos, então uma mensagem
+// direta enviada com o destinatário offline se perde. A fila de um usuário é
+// formada pelas mensagens diretas ainda sem confirmação de entrega; "pending"
+// as devolve e as confirma como entregues, então cada uma sai da fila na
+// primeira entrega, mesmo para clientes que não mandam "receipt". Como
+// mensagens e confirmações já são replicadas, todas as réplicas enxergam a
+// mesma fila.
+
+type PendingRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type PendingResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string          `msgpack:"status"`
+		Error     string          `msgpack:"error,omitempty"`
+		Message   string          `msgpack:"message,omitempty"`
+		Messages  []DirectMessage `msgpack:"messages"`
+		Timestamp int64           `msgpack:"timestamp"`
+		Clock     int64           `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// pendingFor retorna as mensagens diretas ainda não entregues ao usuário,
+// em ordem de envio (clock, depois ID). Deve ser chamada com dataMutex
+// travado.
+func (srv *Server) pendingFor(user string) []UserMessage {
+	pending := []UserMessage{}
+	for _, um := range srv.data.UserMessages {
+		if um.Dst == user && um.ID != "" && um.DeliveredAt == 0 && !um.Deleted {
+			pending = append(pending, um)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Clock != pending[j].Clock {
+			return pending[i].Clock < pending[j].Clock
+		}
+		return pending[i].ID < pending[j].ID
+	})
+	return pending
+}
+
+// handlePending entrega ao usuário, na própria resposta, as mensagens que
+// chegaram enquanto ele estava offline, cada uma com o clock com que foi
+// publicada, e as confirma como entregues ("delivered"). O cliente deve
+// chamá-lo após o login; "receipt" fica para a confirmação de leitura.
+func (srv *Server) handlePending(msg []byte) ([]byte, error) {
+	var req PendingRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := PendingResponse{Service: "pending"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Messages = []DirectMessage{}
+
+	srv.dataMutex.Lock()
+	if !srv.userExists(req.Data.User) {
+		srv.dataMutex.Unlock()
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrUserNotFound
+		resp.Data.Message = "Usuário não existe"
+		return msgpack.Marshal(resp)
+	}
+
+	pending := srv.pendingFor(req.Data.User)
+	at := srv.getAdjustedTime()
+	for _, um := range pending {
+		resp.Data.Messages = append(resp.Data.Messages, DirectMessage{
+			ID:          um.ID,
+			From:        um.Src,
+			Message:     um.Message,
+			Attachments: um.Attachments,
+			Timestamp:   um.Timestamp,
+			Clock:       um.Clock,
+		})
+		srv.applyReceipt(Receipt{ID: um.ID, Status: receiptDelivered, At: at})
+	}
+	srv.dataMutex.Unlock()
+
+	resp.Data.Status = "OK"
+	if len(pending) == 0 {
+		return msgpack.Marshal(resp)
+	}
+
+	if err := srv.saveData(); err != nil {
+		log.Printf("⚠️  Aviso: erro ao salvar confirmação: %v", err)
+	}
+	for _, um := range pending {
+		srv.notifyReceipt(um.Src, req.Data.User, Receipt{ID: um.ID, Status: receiptDelivered, At: at})
+	}
+	log.Printf("📦 %d mensagens pendentes entregues a %s", len(pending), req.Data.User)
+
+	return msgpack.Marshal(resp)
+}
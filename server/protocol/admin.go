@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Serviços administrativos ("admin_*") exigem data.timestamp (Unix, em
+// segundos), data.nonce (único por requisição) e data.auth =
+// AdminSignature(ADMIN_TOKEN, serviço, timestamp, nonce, parâmetros).
+// O token não trafega pela rede e a assinatura vale por AdminMaxSkew segundos;
+// dentro dessa janela o servidor recusa um nonce já usado.
+const AdminMaxSkew = 300
+
+// AdminParams são os campos de uma requisição administrativa cobertos pela
+// assinatura (todos os que os serviços "admin_*" leem).
+type AdminParams struct {
+	Peer string // admin_resync
+	Mode string // admin_restore
+	JSON []byte // admin_restore
+}
+
+// digest resume os parâmetros; cada campo leva o tamanho na frente para que
+// valores diferentes não se confundam na concatenação.
+func (p AdminParams) digest() []byte {
+	h := sha256.New()
+	for _, field := range [][]byte{[]byte(p.Peer), []byte(p.Mode), p.JSON} {
+		h.Write([]byte(strconv.Itoa(len(field)) + ":"))
+		h.Write(field)
+	}
+	return h.Sum(nil)
+}
+
+// AdminSignature assina uma requisição administrativa com HMAC-SHA256 sobre
+// o serviço, o timestamp, o nonce e o hash dos parâmetros.
+func AdminSignature(token, service string, timestamp int64, nonce string, params AdminParams) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(service + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n" +
+		hex.EncodeToString(params.digest())))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewAdminNonce gera um nonce aleatório para AdminSignature.
+func NewAdminNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AdminStatus é a resposta de "admin_status".
+type AdminStatus struct {
+	Server          string        `msgpack:"server"`
+	Rank            int           `msgpack:"rank"`
+	Coordinator     string        `msgpack:"coordinator"`
+	Clock           int64         `msgpack:"clock"`     // relógio lógico
+	Time            int64         `msgpack:"time"`      // relógio físico ajustado (Berkeley)
+	Offset          int64         `msgpack:"offset"`    // ajuste acumulado, em segundos
+	LastSync        int64         `msgpack:"last_sync"` // última sincronização Berkeley (como coordenador ou participante)
+	Users           int           `msgpack:"users"`
+	Channels        int           `msgpack:"channels"`
+	ChannelMessages int           `msgpack:"channel_messages"`
+	UserMessages    int           `msgpack:"user_messages"`
+	LastResync      *ResyncResult `msgpack:"last_resync,omitempty"`
+}
+
+// ResyncResult descreve a última ressincronização de réplica ("admin_resync").
+type ResyncResult struct {
+	Peer     string `msgpack:"peer"`
+	Started  int64  `msgpack:"started"`
+	Finished int64  `msgpack:"finished,omitempty"` // zero enquanto em andamento
+	Added    int    `msgpack:"added"`              // itens que faltavam e foram copiados
+	Error    string `msgpack:"error,omitempty"`
+}
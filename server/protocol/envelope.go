@@ -0,0 +1,56 @@
+package protocol
+
+import "github.com/vmihailenco/msgpack/v5"
+
+// Códigos de erro (campo "error.code" do envelope e "error" de data)
+const (
+	ErrInvalidPayload     = "invalid_payload"
+	ErrPayloadTooLarge    = "payload_too_large"
+	ErrUnknownService     = "unknown_service"
+	ErrUnsupportedVersion = "unsupported_version"
+	ErrInvalidName        = "invalid_name"
+	ErrNameConflict       = "name_conflict"
+	ErrEmptyMessage       = "empty_message"
+	ErrMessageTooLarge    = "message_too_large"
+	ErrFieldTooLarge      = "field_too_large"
+	ErrInvalidArgument    = "invalid_argument"
+	ErrRateLimited        = "rate_limited"
+	ErrForbidden          = "forbidden"
+	ErrUserNotFound       = "user_not_found"
+	ErrUserExists         = "user_exists"
+	ErrChannelNotFound    = "channel_not_found"
+	ErrChannelExists      = "channel_exists"
+	ErrChannelArchived    = "channel_archived"
+	ErrMessageNotFound    = "message_not_found"
+	ErrMessageDeleted     = "message_deleted"
+	ErrAttachmentNotFound = "attachment_not_found"
+	ErrBlobNotFound       = "blob_not_found"
+	ErrBlobTooLarge       = "blob_too_large"
+	ErrUploadNotFound     = "upload_not_found"
+	ErrInvalidOffset      = "invalid_offset"
+	ErrBrokerUnavailable  = "broker_unavailable"
+	ErrStorage            = "storage_error"
+	ErrInternal           = "internal_error"
+)
+
+// Status do envelope
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Envelope envolve as respostas a partir do protocolo v2. O campo data continua com o
+// formato específico de cada serviço (incluindo status/description antigos).
+type Envelope struct {
+	Service   string             `msgpack:"service"`
+	Version   int                `msgpack:"version"`
+	RequestID string             `msgpack:"request_id"`
+	Status    string             `msgpack:"status"` // "ok" ou "error"
+	Error     *ErrorInfo         `msgpack:"error,omitempty"`
+	Data      msgpack.RawMessage `msgpack:"data"`
+}
+
+type ErrorInfo struct {
+	Code    string `msgpack:"code"`
+	Message string `msgpack:"message"`
+}
@@ -0,0 +1,185 @@
+// Package protocol define as mensagens trocadas com o servidor pelo socket
+// ZeroMQ (MessagePack). É usado pelo próprio servidor e pelo SDK em
+// server/client.
+package protocol
+
+// Versão atual do protocolo (v2: respostas no envelope com request_id).
+// Sem o campo version, o servidor responde no formato v1.
+const Version = 2
+
+// Header são os campos de topo de toda requisição.
+type Header struct {
+	Service   string `msgpack:"service"`
+	Version   int    `msgpack:"version,omitempty"`
+	RequestID string `msgpack:"request_id,omitempty"`
+}
+
+type LoginRequest struct {
+	Header
+	Data struct {
+		User      string `msgpack:"user"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type LoginResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status      string `msgpack:"status"`
+		Error       string `msgpack:"error,omitempty"`
+		Timestamp   int64  `msgpack:"timestamp"`
+		Clock       int64  `msgpack:"clock"`
+		Description string `msgpack:"description,omitempty"`
+	} `msgpack:"data"`
+}
+
+type UsersRequest struct {
+	Header
+	Data struct {
+		Timestamp int64 `msgpack:"timestamp"`
+		Clock     int64 `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type UsersResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Timestamp int64    `msgpack:"timestamp"`
+		Clock     int64    `msgpack:"clock"`
+		Users     []string `msgpack:"users"`
+	} `msgpack:"data"`
+}
+
+type ChannelRequest struct {
+	Header
+	Data struct {
+		Channel     string `msgpack:"channel"`
+		User        string `msgpack:"user,omitempty"`
+		Topic       string `msgpack:"topic,omitempty"`
+		Description string `msgpack:"description,omitempty"`
+		Timestamp   int64  `msgpack:"timestamp"`
+		Clock       int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ChannelResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status      string `msgpack:"status"`
+		Error       string `msgpack:"error,omitempty"`
+		Timestamp   int64  `msgpack:"timestamp"`
+		Clock       int64  `msgpack:"clock"`
+		Description string `msgpack:"description,omitempty"`
+	} `msgpack:"data"`
+}
+
+type ChannelsRequest struct {
+	Header
+	Data struct {
+		Filter          string `msgpack:"filter,omitempty"`           // substring do nome, tópico ou descrição
+		Sort            string `msgpack:"sort,omitempty"`             // "name" (padrão), "activity" ou "created"
+		IncludeArchived bool   `msgpack:"include_archived,omitempty"` // incluir canais arquivados
+		Timestamp       int64  `msgpack:"timestamp"`
+		Clock           int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type ChannelsResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Timestamp int64         `msgpack:"timestamp"`
+		Clock     int64         `msgpack:"clock"`
+		Channels  []string      `msgpack:"channels"` // apenas nomes (compatível com clientes antigos)
+		Info      []ChannelInfo `msgpack:"info"`
+	} `msgpack:"data"`
+}
+
+type PublishRequest struct {
+	Header
+	Data struct {
+		User        string   `msgpack:"user"`
+		Channel     string   `msgpack:"channel"`
+		Message     string   `msgpack:"message"`
+		Attachments []string `msgpack:"attachments,omitempty"` // hashes de blobs
+		ParentID    string   `msgpack:"parent_id,omitempty"`   // resposta em thread (opcional)
+		Timestamp   int64    `msgpack:"timestamp"`
+		Clock       int64    `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type PublishResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string `msgpack:"status"`
+		Error     string `msgpack:"error,omitempty"`
+		Message   string `msgpack:"message,omitempty"`
+		ID        string `msgpack:"id,omitempty"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type MessageRequest struct {
+	Header
+	Data struct {
+		Src         string   `msgpack:"src"`
+		Dst         string   `msgpack:"dst"`
+		Message     string   `msgpack:"message"`
+		Attachments []string `msgpack:"attachments,omitempty"` // hashes de blobs
+		Timestamp   int64    `msgpack:"timestamp"`
+		Clock       int64    `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type MessageResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string `msgpack:"status"`
+		Error     string `msgpack:"error,omitempty"`
+		Message   string `msgpack:"message,omitempty"`
+		ID        string `msgpack:"id,omitempty"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Estrutura para publicação no broker
+type Publication struct {
+	ID          string   `msgpack:"id"`
+	ParentID    string   `msgpack:"parent_id,omitempty"`
+	User        string   `msgpack:"user"`
+	Message     string   `msgpack:"message"`
+	Attachments []string `msgpack:"attachments,omitempty"` // hashes de blobs
+	Timestamp   int64    `msgpack:"timestamp"`
+	Clock       int64    `msgpack:"clock"`
+}
+
+type DirectMessage struct {
+	ID          string   `msgpack:"id"`
+	From        string   `msgpack:"from"`
+	Message     string   `msgpack:"message"`
+	Attachments []string `msgpack:"attachments,omitempty"` // hashes de blobs
+	Timestamp   int64    `msgpack:"timestamp"`
+	Clock       int64    `msgpack:"clock"`
+}
+
+// Canal com estatísticas de atividade (resposta de "channels")
+type ChannelInfo struct {
+	Name         string           `msgpack:"name"`
+	Topic        string           `msgpack:"topic"`
+	Description  string           `msgpack:"description"`
+	Owner        string           `msgpack:"owner"`
+	CreatedAt    int64            `msgpack:"created_at"`
+	Archived     bool             `msgpack:"archived"`
+	Retention    *RetentionPolicy `msgpack:"retention,omitempty"` // política própria do canal
+	MessageCount int              `msgpack:"message_count"`
+	LastActivity int64            `msgpack:"last_activity"`
+}
+
+// Política de retenção: MaxAge em segundos, MaxCount mensagens. Zero
+// significa sem limite.
+type RetentionPolicy struct {
+	MaxAge   int64 `msgpack:"max_age,omitempty"`
+	MaxCount int   `msgpack:"max_count,omitempty"`
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Limite de taxa (token bucket)
+// ----------------------------
+
+// Limites são identificados por "<serviço>.<escopo>", com escopo "user",
+// "channel" ou "conn" (conexão ZeroMQ do cliente).
+type RateLimit struct {
+	Rate  float64 `msgpack:"rate"`  // tokens por segundo
+	Burst float64 `msgpack:"burst"` // capacidade do balde
+}
+
+type RateLimitsRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string               `msgpack:"user"`
+		Set       map[string]RateLimit `msgpack:"set,omitempty"` // rate 0 remove o limite
+		Timestamp int64                `msgpack:"timestamp"`
+		Clock     int64                `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type RateLimitsResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string               `msgpack:"status"`
+		Error     string               `msgpack:"error,omitempty"`
+		Message   string               `msgpack:"message,omitempty"`
+		Limits    map[string]RateLimit `msgpack:"limits"`
+		Timestamp int64                `msgpack:"timestamp"`
+		Clock     int64                `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// defaultRateLimits devolve os limites padrão; RATE_LIMITS os sobrepõe.
+func defaultRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		"publish.user":    {Rate: 5, Burst: 10},
+		"publish.channel": {Rate: 20, Burst: 40},
+		"publish.conn":    {Rate: 10, Burst: 20},
+		"message.user":    {Rate: 5, Burst: 10},
+		"message.conn":    {Rate: 10, Burst: 20},
+		"channel.user":    {Rate: 1, Burst: 5},
+		"channel.conn":    {Rate: 1, Burst: 5},
+		"login.user":      {Rate: 0.2, Burst: 3},
+		"login.conn":      {Rate: 1, Burst: 3},
+	}
+}
+
+const bucketIdleTimeout = 10 * time.Minute
+
+// loadRateLimits aplica sobreposições de RATE_LIMITS, no formato
+// "publish.user=5:10,login.conn=1:3" (taxa:capacidade).
+func (srv *Server) loadRateLimits() {
+	for _, item := range strings.Split(os.Getenv("RATE_LIMITS"), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			continue
+		}
+		rateStr, burstStr, _ := strings.Cut(value, ":")
+		rate, err1 := strconv.ParseFloat(rateStr, 64)
+		burst, err2 := strconv.ParseFloat(burstStr, 64)
+		if err1 != nil || err2 != nil {
+			log.Printf("⚠️  RATE_LIMITS: entrada inválida %q", item)
+			continue
+		}
+		srv.setRateLimit(key, RateLimit{Rate: rate, Burst: burst})
+	}
+}
+
+// setRateLimit altera um limite e zera os baldes correspondentes.
+// Deve ser chamada com rateLimitMutex travado (ou antes do loop principal).
+func (srv *Server) setRateLimit(key string, limit RateLimit) {
+	if limit.Rate <= 0 {
+		delete(srv.rateLimits, key)
+	} else {
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+		srv.rateLimits[key] = limit
+	}
+	for k := range srv.buckets {
+		if strings.HasPrefix(k, key+":") {
+			delete(srv.buckets, k)
+		}
+	}
+}
+
+// take consome um token do balde; se não houver, retorna quanto esperar.
+func (srv *Server) take(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	b, ok := srv.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: limit.Burst, last: now}
+		srv.buckets[key] = b
+	}
+	b.tokens = math.Min(limit.Burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// checkRateLimit verifica todos os limites aplicáveis à requisição. Os tokens
+// só são consumidos se todos os baldes permitirem.
+func (srv *Server) checkRateLimit(service, conn string, msg []byte) (bool, time.Duration) {
+	var req struct {
+		Data struct {
+			User    string `msgpack:"user"`
+			Src     string `msgpack:"src"`
+			Channel string `msgpack:"channel"`
+		} `msgpack:"data"`
+	}
+	msgpack.Unmarshal(msg, &req)
+	user := req.Data.User
+	if user == "" {
+		user = req.Data.Src
+	}
+
+	subjects := map[string]string{"user": user, "channel": req.Data.Channel, "conn": conn}
+
+	srv.rateLimitMutex.Lock()
+	defer srv.rateLimitMutex.Unlock()
+
+	now := time.Now()
+	srv.rateChecks++
+	if srv.rateChecks%1000 == 0 {
+		for k, b := range srv.buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(srv.buckets, k)
+			}
+		}
+	}
+
+	type pending struct {
+		key   string
+		limit RateLimit
+	}
+	checks := []pending{}
+	for _, scope := range []string{"user", "channel", "conn"} {
+		limit, ok := srv.rateLimits[service+"."+scope]
+		if !ok || subjects[scope] == "" {
+			continue
+		}
+		checks = append(checks, pending{service + "." + scope + ":" + subjects[scope], limit})
+	}
+
+	// Simular antes de consumir, para não gastar tokens de uma requisição barrada
+	var longest time.Duration
+	snapshot := make(map[string]tokenBucket)
+	for _, c := range checks {
+		if b, ok := srv.buckets[c.key]; ok {
+			snapshot[c.key] = *b
+		}
+		if ok, wait := srv.take(c.key, c.limit, now); !ok && wait > longest {
+			longest = wait
+		}
+	}
+	if longest > 0 {
+		for _, c := range checks {
+			if b, ok := snapshot[c.key]; ok {
+				*srv.buckets[c.key] = b
+			} else {
+				delete(srv.buckets, c.key)
+			}
+		}
+		return false, longest
+	}
+	return true, 0
+}
+
+func (srv *Server) rateLimitedResponse(service string, retryAfter time.Duration) ([]byte, error) {
+	resp := ErrorResponse{Service: service}
+	resp.Data.Status = "erro"
+	resp.Data.Error = ErrRateLimited
+	resp.Data.RetryAfter = math.Ceil(retryAfter.Seconds()*1000) / 1000
+	resp.Data.Message = fmt.Sprintf("Limite de requisições excedido, tente novamente em %.1fs", resp.Data.RetryAfter)
+	resp.Data.Description = resp.Data.Message
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	return msgpack.Marshal(resp)
+}
+
+// Handler para consultar e alterar os limites em tempo de execução (moderadores)
+func (srv *Server) handleRateLimits(msg []byte) ([]byte, error) {
+	var req RateLimitsRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := RateLimitsResponse{Service: "rate_limits"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+
+	srv.rateLimitMutex.Lock()
+	defer srv.rateLimitMutex.Unlock()
+
+	if len(req.Data.Set) > 0 {
+		if !isModerator(req.Data.User) {
+			resp.Data.Status = "erro"
+			resp.Data.Error = ErrForbidden
+			resp.Data.Message = "Apenas moderadores podem alterar limites"
+			return msgpack.Marshal(resp)
+		}
+		keys := make([]string, 0, len(req.Data.Set))
+		for key, limit := range req.Data.Set {
+			srv.setRateLimit(key, limit)
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		log.Printf("🚦 Limites alterados por %s: %s", req.Data.User, strings.Join(keys, ", "))
+	}
+
+	resp.Data.Limits = make(map[string]RateLimit, len(srv.rateLimits))
+	for k, v := range srv.rateLimits {
+		resp.Data.Limits[k] = v
+	}
+	resp.Data.Status = "OK"
+	return msgpack.Marshal(resp)
+}
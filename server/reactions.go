@@ -0,0 +1,189 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Reações em mensagens
+// ----------------------------
+
+const maxEmojiBytes = 32
+
+type ReactRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`
+		ID        string `msgpack:"id"`
+		Emoji     string `msgpack:"emoji"`
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Alteração replicada entre servidores ("reaction")
+type ReactionChange struct {
+	ID     string `msgpack:"id"`
+	User   string `msgpack:"user"`
+	Emoji  string `msgpack:"emoji"`
+	Remove bool   `msgpack:"remove"`
+}
+
+// Evento publicado no tópico da mensagem
+type ReactionEvent struct {
+	Event     string         `msgpack:"event"` // "react" ou "unreact"
+	ID        string         `msgpack:"id"`
+	User      string         `msgpack:"user"`
+	Emoji     string         `msgpack:"emoji"`
+	Counts    map[string]int `msgpack:"counts"` // totais atualizados por emoji
+	Timestamp int64          `msgpack:"timestamp"`
+	Clock     int64          `msgpack:"clock"`
+}
+
+// reactionCounts agrega as reações de uma mensagem por emoji.
+func reactionCounts(reactions map[string][]string) map[string]int {
+	counts := make(map[string]int, len(reactions))
+	for emoji, users := range reactions {
+		counts[emoji] = len(users)
+	}
+	return counts
+}
+
+// applyReaction adiciona ou remove a reação de um usuário. É idempotente, para
+// que réplicas repetidas não dupliquem reações. Retorna as reações atualizadas.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) applyReaction(rc ReactionChange) (map[string][]string, bool) {
+	apply := func(reactions *map[string][]string) {
+		if *reactions == nil {
+			*reactions = make(map[string][]string)
+		}
+		users := (*reactions)[rc.Emoji]
+		idx := -1
+		for i, u := range users {
+			if u == rc.User {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case rc.Remove && idx >= 0:
+			users = append(users[:idx], users[idx+1:]...)
+		case !rc.Remove && idx < 0:
+			users = append(users, rc.User)
+		}
+		if len(users) == 0 {
+			delete(*reactions, rc.Emoji)
+		} else {
+			(*reactions)[rc.Emoji] = users
+		}
+	}
+
+	for i := range srv.data.ChannelMessages {
+		cm := &srv.data.ChannelMessages[i]
+		if cm.ID == rc.ID {
+			apply(&cm.Reactions)
+			return cm.Reactions, true
+		}
+	}
+	for i := range srv.data.UserMessages {
+		um := &srv.data.UserMessages[i]
+		if um.ID == rc.ID {
+			apply(&um.Reactions)
+			return um.Reactions, true
+		}
+	}
+	return nil, false
+}
+
+func (srv *Server) handleReact(msg []byte) ([]byte, error) {
+	return srv.changeReaction("react", msg, false)
+}
+
+func (srv *Server) handleUnreact(msg []byte) ([]byte, error) {
+	return srv.changeReaction("unreact", msg, true)
+}
+
+func (srv *Server) changeReaction(service string, msg []byte, remove bool) ([]byte, error) {
+	var req ReactRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := MessageResponse{Service: service}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.ID = req.Data.ID
+
+	if req.Data.Emoji == "" || len(req.Data.Emoji) > maxEmojiBytes {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidArgument
+		resp.Data.Message = "Reação inválida"
+		return msgpack.Marshal(resp)
+	}
+
+	rc := ReactionChange{ID: req.Data.ID, User: req.Data.User, Emoji: req.Data.Emoji, Remove: remove}
+
+	var counts map[string]int
+	srv.dataMutex.Lock()
+	ref, ok := srv.lookupMessage(rc.ID)
+	switch {
+	case !ok:
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageNotFound
+		resp.Data.Message = "Mensagem não encontrada"
+	case !srv.userExists(rc.User):
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrUserNotFound
+		resp.Data.Message = "Usuário não existe"
+	case !ref.Channel && rc.User != ref.Author && rc.User != ref.Target:
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrForbidden
+		resp.Data.Message = "Apenas os participantes podem reagir a uma mensagem direta"
+	case ref.Deleted:
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageDeleted
+		resp.Data.Message = "Mensagem já foi removida"
+	case ref.Channel && srv.isArchived(ref.Target):
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrChannelArchived
+		resp.Data.Message = "Canal arquivado (somente leitura)"
+	default:
+		reactions, _ := srv.applyReaction(rc)
+		counts = reactionCounts(reactions)
+	}
+	srv.dataMutex.Unlock()
+
+	if resp.Data.Status != "" {
+		return msgpack.Marshal(resp)
+	}
+
+	if err := srv.saveData(); err != nil {
+		log.Printf("⚠️  Aviso: erro ao salvar reação: %v", err)
+	}
+
+	event := ReactionEvent{
+		Event:     service,
+		ID:        rc.ID,
+		User:      rc.User,
+		Emoji:     rc.Emoji,
+		Counts:    counts,
+		Timestamp: req.Data.Timestamp,
+		Clock:     srv.incrementClock(),
+	}
+	if eventData, err := msgpack.Marshal(event); err == nil {
+		if _, err := srv.pubSocket.SendMessage(ref.topic(), eventData); err != nil {
+			log.Printf("❌ Erro ao publicar reação em %s: %v", ref.Target, err)
+		}
+	}
+
+	resp.Data.Status = "OK"
+	log.Printf("👍 %s %s na mensagem %s por %s (clock: %d)", service, rc.Emoji, rc.ID, rc.User, event.Clock)
+	srv.replicateAsync("reaction", rc)
+
+	return msgpack.Marshal(resp)
+}
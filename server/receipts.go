@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"server/topics"
+)
+
+// ----------------------------
+// Confirmações de entrega e leitura de mensagens diretas
+// ----------------------------
+
+const (
+	receiptDelivered = "delivered"
+	receiptRead      = "read"
+)
+
+type ReceiptRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`   // destinatário que confirma
+		ID        string `msgpack:"id"`     // ID da mensagem direta
+		Status    string `msgpack:"status"` // "delivered" ou "read"
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// Confirmação replicada entre servidores ("receipt")
+type Receipt struct {
+	ID     string `msgpack:"id"`
+	Status string `msgpack:"status"`
+	At     int64  `msgpack:"at"`
+}
+
+// Evento publicado no tópico do remetente
+type ReceiptEvent struct {
+	Event     string `msgpack:"event"` // sempre "receipt"
+	ID        string `msgpack:"id"`
+	User      string `msgpack:"user"` // quem recebeu/leu
+	Status    string `msgpack:"status"`
+	Timestamp int64  `msgpack:"timestamp"`
+	Clock     int64  `msgpack:"clock"`
+}
+
+// applyReceipt registra a confirmação na mensagem. Leitura implica entrega,
+// e confirmações repetidas mantêm o primeiro horário registrado.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) applyReceipt(r Receipt) bool {
+	for i := range srv.data.UserMessages {
+		um := &srv.data.UserMessages[i]
+		if um.ID != r.ID {
+			continue
+		}
+		if um.DeliveredAt == 0 {
+			um.DeliveredAt = r.At
+		}
+		if r.Status == receiptRead && um.ReadAt == 0 {
+			um.ReadAt = r.At
+		}
+		return true
+	}
+	return false
+}
+
+func (srv *Server) handleReceipt(msg []byte) ([]byte, error) {
+	var req ReceiptRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := MessageResponse{Service: "receipt"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.ID = req.Data.ID
+
+	if req.Data.Status != receiptDelivered && req.Data.Status != receiptRead {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidArgument
+		resp.Data.Message = "Status de confirmação inválido"
+		return msgpack.Marshal(resp)
+	}
+
+	receipt := Receipt{ID: req.Data.ID, Status: req.Data.Status, At: req.Data.Timestamp}
+	if receipt.At == 0 {
+		receipt.At = srv.getAdjustedTime()
+	}
+
+	var sender string
+	srv.dataMutex.Lock()
+	for _, um := range srv.data.UserMessages {
+		if um.ID == req.Data.ID {
+			sender = um.Src
+			if um.Dst != req.Data.User {
+				resp.Data.Status = "erro"
+				resp.Data.Error = ErrForbidden
+				resp.Data.Message = "Apenas o destinatário pode confirmar a mensagem"
+			}
+			break
+		}
+	}
+	if sender == "" {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrMessageNotFound
+		resp.Data.Message = "Mensagem não encontrada"
+	}
+	if resp.Data.Status == "" {
+		srv.applyReceipt(receipt)
+	}
+	srv.dataMutex.Unlock()
+
+	if resp.Data.Status != "" {
+		return msgpack.Marshal(resp)
+	}
+
+	if err := srv.saveData(); err != nil {
+		log.Printf("⚠️  Aviso: erro ao salvar confirmação: %v", err)
+	}
+
+	clock := srv.notifyReceipt(sender, req.Data.User, receipt)
+	resp.Data.Status = "OK"
+	log.Printf("📬 Mensagem %s %s por %s (clock: %d)", receipt.ID, receipt.Status, req.Data.User, clock)
+
+	return msgpack.Marshal(resp)
+}
+
+// notifyReceipt avisa o remetente, no seu próprio tópico, que user confirmou
+// a mensagem, e replica a confirmação. Devolve o clock do evento.
+func (srv *Server) notifyReceipt(sender, user string, r Receipt) int64 {
+	event := ReceiptEvent{
+		Event:     "receipt",
+		ID:        r.ID,
+		User:      user,
+		Status:    r.Status,
+		Timestamp: r.At,
+		Clock:     srv.incrementClock(),
+	}
+	if eventData, err := msgpack.Marshal(event); err == nil {
+		if _, err := srv.pubSocket.SendMessage(topics.User(sender), eventData); err != nil {
+			log.Printf("❌ Erro ao notificar %s: %v", sender, err)
+		}
+	}
+	srv.replicateAsync("receipt", r)
+	return event.Clock
+}
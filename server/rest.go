@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Gateway HTTP/JSON (REST)
+// ----------------------------
+
+// Rotas:
+//
+//	POST /api/login                      login
+//	GET  /api/users                      users
+//	GET  /api/channels                   channels (?filter=&sort=&include_archived=)
+//	POST /api/channels                   channel
+//	GET  /api/channels/{canal}/messages  history (?limit=&before_id=)
+//	POST /api/channels/{canal}/messages  publish
+//	POST /api/users/{usuário}/messages   message
+//
+// Cada rota monta a mesma requisição do socket ZeroMQ e passa por
+// processRequest (validação, limite de taxa e handler). A resposta é o
+// envelope v2 em JSON.
+
+const defaultHTTPAddr = ":8080"
+
+// httpStatus traduz os códigos de erro do envelope em status HTTP.
+var httpStatus = map[string]int{
+	ErrInvalidPayload:     http.StatusBadRequest,
+	ErrUnknownService:     http.StatusNotFound,
+	ErrUnsupportedVersion: http.StatusBadRequest,
+	ErrInvalidName:        http.StatusBadRequest,
+	ErrEmptyMessage:       http.StatusBadRequest,
+	ErrInvalidArgument:    http.StatusBadRequest,
+	ErrInvalidOffset:      http.StatusBadRequest,
+	ErrMessageTooLarge:    http.StatusRequestEntityTooLarge,
+	ErrFieldTooLarge:      http.StatusRequestEntityTooLarge,
+	ErrPayloadTooLarge:    http.StatusRequestEntityTooLarge,
+	ErrBlobTooLarge:       http.StatusRequestEntityTooLarge,
+	ErrForbidden:          http.StatusForbidden,
+	ErrUserNotFound:       http.StatusNotFound,
+	ErrChannelNotFound:    http.StatusNotFound,
+	ErrMessageNotFound:    http.StatusNotFound,
+	ErrBlobNotFound:       http.StatusNotFound,
+	ErrUploadNotFound:     http.StatusNotFound,
+	ErrAttachmentNotFound: http.StatusUnprocessableEntity,
+	ErrUserExists:         http.StatusConflict,
+	ErrChannelExists:      http.StatusConflict,
+	ErrNameConflict:       http.StatusConflict,
+	ErrChannelArchived:    http.StatusConflict,
+	ErrMessageDeleted:     http.StatusGone,
+	ErrRateLimited:        http.StatusTooManyRequests,
+	ErrBrokerUnavailable:  http.StatusServiceUnavailable,
+	ErrStorage:            http.StatusInternalServerError,
+	ErrInternal:           http.StatusInternalServerError,
+}
+
+// Serviços que criam recursos respondem 201 em caso de sucesso
+var createdServices = map[string]bool{"login": true, "channel": true, "publish": true, "message": true}
+
+// restCall executa um serviço com os campos de data e escreve a resposta.
+func (srv *Server) restCall(w http.ResponseWriter, r *http.Request, service string, fields map[string]interface{}) {
+	fields["timestamp"] = time.Now().Unix()
+	req := map[string]interface{}{"service": service, "version": ProtocolVersion, "data": fields}
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		req["request_id"] = id
+	}
+
+	msg, err := msgpack.Marshal(conformTree(requestTypes[service], req))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	response := srv.processRequest(service, "http:"+host, msg)
+
+	envelope, err := wrapResponse(srv.requestID(msg), response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var result struct {
+		Status string     `msgpack:"status"`
+		Error  *ErrorInfo `msgpack:"error"`
+		Data   struct {
+			RetryAfter float64 `msgpack:"retry_after"`
+		} `msgpack:"data"`
+	}
+	msgpack.Unmarshal(envelope, &result)
+
+	body, err := encodeFrame(formatJSON, false, envelope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if result.Error != nil {
+		status = http.StatusInternalServerError
+		if s, ok := httpStatus[result.Error.Code]; ok {
+			status = s
+		}
+		if result.Error.Code == ErrRateLimited {
+			w.Header().Set("Retry-After", strconv.Itoa(int(result.Data.RetryAfter+0.999)))
+		}
+	} else if createdServices[service] {
+		status = http.StatusCreated
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// restBody lê o corpo JSON como mapa de campos. O usuário pode vir no corpo
+// ("user") ou no cabeçalho X-User.
+func restBody(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	fields := map[string]interface{}{}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxPayloadSize())))
+	if err != nil {
+		restError(w, http.StatusRequestEntityTooLarge, ErrPayloadTooLarge, "Corpo da requisição muito grande")
+		return nil, false
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		tree, err := jsonCodec{}.Decode(body)
+		m, ok := tree.(map[string]interface{})
+		if err != nil || !ok {
+			restError(w, http.StatusBadRequest, ErrInvalidPayload, "Corpo deve ser um objeto JSON")
+			return nil, false
+		}
+		fields = m
+	}
+	if user := r.Header.Get("X-User"); user != "" {
+		if _, ok := fields["user"]; !ok {
+			fields["user"] = user
+		}
+	}
+	return fields, true
+}
+
+// restError responde erros do próprio gateway (rota, método, corpo) no
+// mesmo formato do envelope.
+func restError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version": ProtocolVersion,
+		"status":  StatusError,
+		"error":   map[string]string{"code": code, "message": message},
+	})
+}
+
+// queryFields copia parâmetros da URL para os campos da requisição; a
+// conversão para número fica com conformTree, conforme o tipo do campo.
+func queryFields(q url.Values, fields map[string]interface{}, names ...string) {
+	for _, name := range names {
+		if v := q.Get(name); v != "" {
+			fields[name] = v
+		}
+	}
+}
+
+// restNotFound distingue rota inexistente (404) de método errado (405).
+func restNotFound(w http.ResponseWriter, r *http.Request, rest string) {
+	if rest == "" || (strings.HasSuffix(rest, "/messages") && strings.Count(rest, "/") == 1) {
+		restError(w, http.StatusMethodNotAllowed, ErrUnknownService, "Método não permitido")
+		return
+	}
+	restError(w, http.StatusNotFound, ErrUnknownService, fmt.Sprintf("Rota desconhecida: %s %s", r.Method, r.URL.Path))
+}
+
+func (srv *Server) handleRESTLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		restError(w, http.StatusMethodNotAllowed, ErrUnknownService, "Método não permitido")
+		return
+	}
+	if fields, ok := restBody(w, r); ok {
+		srv.restCall(w, r, "login", fields)
+	}
+}
+
+func (srv *Server) handleRESTUsers(w http.ResponseWriter, r *http.Request) {
+	// /api/users ou /api/users/{usuário}/messages
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		srv.restCall(w, r, "users", map[string]interface{}{})
+	case strings.HasSuffix(rest, "/messages") && r.Method == http.MethodPost:
+		fields, ok := restBody(w, r)
+		if !ok {
+			return
+		}
+		fields["dst"] = strings.TrimSuffix(rest, "/messages")
+		if _, ok := fields["src"]; !ok {
+			fields["src"] = fields["user"]
+		}
+		delete(fields, "user")
+		srv.restCall(w, r, "message", fields)
+	default:
+		restNotFound(w, r, rest)
+	}
+}
+
+func (srv *Server) handleRESTChannels(w http.ResponseWriter, r *http.Request) {
+	// /api/channels ou /api/channels/{canal}/messages
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/channels"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		fields := map[string]interface{}{}
+		queryFields(r.URL.Query(), fields, "filter", "sort")
+		fields["include_archived"] = r.URL.Query().Get("include_archived") == "true"
+		srv.restCall(w, r, "channels", fields)
+	case rest == "" && r.Method == http.MethodPost:
+		if fields, ok := restBody(w, r); ok {
+			srv.restCall(w, r, "channel", fields)
+		}
+	case strings.HasSuffix(rest, "/messages") && r.Method == http.MethodGet:
+		fields := map[string]interface{}{"channel": strings.TrimSuffix(rest, "/messages")}
+		queryFields(r.URL.Query(), fields, "limit", "before", "before_id")
+		srv.restCall(w, r, "history", fields)
+	case strings.HasSuffix(rest, "/messages") && r.Method == http.MethodPost:
+		fields, ok := restBody(w, r)
+		if !ok {
+			return
+		}
+		fields["channel"] = strings.TrimSuffix(rest, "/messages")
+		srv.restCall(w, r, "publish", fields)
+	default:
+		restNotFound(w, r, rest)
+	}
+}
+
+// startHTTPGateway sobe o servidor HTTP em HTTP_ADDR (padrão ":8080";
+// "off" desativa).
+func (srv *Server) startHTTPGateway() {
+	addr := srv.cfg.HTTPAddr
+	if addr == "" {
+		addr = defaultHTTPAddr
+	}
+	if addr == "off" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/login", srv.handleRESTLogin)
+	mux.HandleFunc("/api/users", srv.handleRESTUsers)
+	mux.HandleFunc("/api/users/", srv.handleRESTUsers)
+	mux.HandleFunc("/api/channels", srv.handleRESTChannels)
+	mux.HandleFunc("/api/channels/", srv.handleRESTChannels)
+	mux.HandleFunc("/ws", srv.handleWebSocket)
+
+	srv.httpServer = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("🌐 Gateway HTTP escutando em %s", addr)
+		if err := srv.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ Gateway HTTP encerrado: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ----------------------------
+// Retenção de histórico
+// ----------------------------
+
+const (
+	defaultRetentionInterval = 5 * time.Minute
+	tombstoneTTL             = 7 * 24 * 60 * 60 // segundos
+)
+
+// Política de retenção: mensagens mais antigas que MaxAge segundos ou além das
+// MaxCount mais recentes são expurgadas. Zero significa sem limite.
+type RetentionPolicy struct {
+	MaxAge   int64 `msgpack:"max_age,omitempty"`
+	MaxCount int   `msgpack:"max_count,omitempty"`
+}
+
+// Expurgo replicado entre servidores ("purge")
+type Purge struct {
+	IDs []string `msgpack:"ids"`
+	At  int64    `msgpack:"at"`
+}
+
+func envInt64(name string) int64 {
+	v, _ := strconv.ParseInt(os.Getenv(name), 10, 64)
+	return v
+}
+
+// globalRetention lê a política global de RETENTION_MAX_AGE (segundos) e
+// RETENTION_MAX_COUNT (mensagens por canal ou por conversa).
+func globalRetention() RetentionPolicy {
+	return RetentionPolicy{
+		MaxAge:   envInt64("RETENTION_MAX_AGE"),
+		MaxCount: int(envInt64("RETENTION_MAX_COUNT")),
+	}
+}
+
+// merge aplica os campos definidos do canal sobre a política global.
+func (p RetentionPolicy) merge(override *RetentionPolicy) RetentionPolicy {
+	if override == nil {
+		return p
+	}
+	if override.MaxAge != 0 {
+		p.MaxAge = override.MaxAge
+	}
+	if override.MaxCount != 0 {
+		p.MaxCount = override.MaxCount
+	}
+	return p
+}
+
+// expired devolve os índices (em ordem de timestamp) que a política manda expurgar.
+func (p RetentionPolicy) expired(timestamps []int64, now int64) []int {
+	order := make([]int, len(timestamps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return timestamps[order[a]] < timestamps[order[b]] })
+
+	drop := []int{}
+	for rank, i := range order {
+		tooOld := p.MaxAge > 0 && timestamps[i] < now-p.MaxAge
+		tooMany := p.MaxCount > 0 && len(order)-rank > p.MaxCount
+		if tooOld || tooMany {
+			drop = append(drop, i)
+		}
+	}
+	return drop
+}
+
+// purged informa se a mensagem já foi expurgada, para que réplicas atrasadas
+// não a ressuscitem. Deve ser chamada com dataMutex travado.
+func (srv *Server) purged(id string) bool {
+	_, ok := srv.data.Tombstones[id]
+	return id != "" && ok
+}
+
+// expiredMessageIDs aplica as políticas a cada canal e a cada conversa direta.
+// Mensagens sem ID (só possíveis antes de loadData atribuí-los) ficam de
+// fora: não há como expurgá-las nem replicar o expurgo. Deve ser chamada com
+// dataMutex travado.
+func (srv *Server) expiredMessageIDs(now int64) []string {
+	global := globalRetention()
+	ids := []string{}
+
+	byChannel := make(map[string][]int)
+	for i, cm := range srv.data.ChannelMessages {
+		byChannel[cm.Channel] = append(byChannel[cm.Channel], i)
+	}
+	for name, idxs := range byChannel {
+		policy := global
+		if c := srv.findChannel(name); c >= 0 {
+			policy = global.merge(srv.data.Channels[c].Retention)
+		}
+		if policy == (RetentionPolicy{}) {
+			continue
+		}
+		ts := make([]int64, len(idxs))
+		for k, i := range idxs {
+			ts[k] = srv.data.ChannelMessages[i].Timestamp
+		}
+		for _, k := range policy.expired(ts, now) {
+			if id := srv.data.ChannelMessages[idxs[k]].ID; id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if global == (RetentionPolicy{}) {
+		return ids
+	}
+	byConversation := make(map[[2]string][]int)
+	for i, um := range srv.data.UserMessages {
+		key := [2]string{um.Src, um.Dst}
+		if key[0] > key[1] {
+			key[0], key[1] = key[1], key[0]
+		}
+		byConversation[key] = append(byConversation[key], i)
+	}
+	for _, idxs := range byConversation {
+		ts := make([]int64, len(idxs))
+		for k, i := range idxs {
+			ts[k] = srv.data.UserMessages[i].Timestamp
+		}
+		for _, k := range global.expired(ts, now) {
+			if id := srv.data.UserMessages[idxs[k]].ID; id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// purgeMessages remove as mensagens, registra tombstones e descarta
+// tombstones vencidos. Deve ser chamada com dataMutex travado.
+func (srv *Server) purgeMessages(ids []string, at int64) int {
+	if srv.data.Tombstones == nil {
+		srv.data.Tombstones = make(map[string]int64)
+	}
+	drop := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id != "" {
+			drop[id] = true
+			srv.data.Tombstones[id] = at
+		}
+	}
+
+	removed := 0
+	keptCM := srv.data.ChannelMessages[:0]
+	for _, cm := range srv.data.ChannelMessages {
+		if drop[cm.ID] {
+			srv.unindexMessage(cm.ID)
+			removed++
+			continue
+		}
+		keptCM = append(keptCM, cm)
+	}
+	srv.data.ChannelMessages = keptCM
+
+	keptUM := srv.data.UserMessages[:0]
+	for _, um := range srv.data.UserMessages {
+		if drop[um.ID] {
+			srv.unindexMessage(um.ID)
+			removed++
+			continue
+		}
+		keptUM = append(keptUM, um)
+	}
+	srv.data.UserMessages = keptUM
+
+	for id, when := range srv.data.Tombstones {
+		if when < at-tombstoneTTL {
+			delete(srv.data.Tombstones, id)
+		}
+	}
+	return removed
+}
+
+// enforceRetention expurga o que as políticas mandam, compacta o arquivo de
+// dados e replica os IDs removidos.
+func (srv *Server) enforceRetention() {
+	now := srv.getAdjustedTime()
+
+	srv.dataMutex.Lock()
+	ids := srv.expiredMessageIDs(now)
+	if len(ids) == 0 {
+		srv.dataMutex.Unlock()
+		return
+	}
+	removed := srv.purgeMessages(ids, now)
+	err := srv.writeData()
+	srv.dataMutex.Unlock()
+
+	if err != nil {
+		log.Printf("⚠️  Retenção: erro ao salvar dados: %v", err)
+	}
+	log.Printf("🧹 Retenção: %d mensagens expurgadas", removed)
+	srv.replicateAsync("purge", Purge{IDs: ids, At: now})
+}
+
+// startRetentionRoutine executa a retenção periodicamente (RETENTION_INTERVAL,
+// em segundos). Só o coordenador decide o que expurgar; os demais servidores
+// aplicam os expurgos replicados, evitando que cada um remova algo diferente.
+func (srv *Server) startRetentionRoutine() {
+	interval := defaultRetentionInterval
+	if v := envInt64("RETENTION_INTERVAL"); v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+
+	srv.every(interval, func() {
+		if srv.isCoordinator() {
+			srv.enforceRetention()
+		}
+	})
+}
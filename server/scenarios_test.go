@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Cenários de falha sobre o cluster em processo (cluster_test.go).
+
+func hasUser(users []string, user string) bool {
+	for _, u := range users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClusterStartAgreesOnCoordinator(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+
+	for _, name := range []string{"a", "b", "c"} {
+		if got := c.Coordinator(name); got != "c" {
+			t.Errorf("%s: coordenador %q, esperado c", name, got)
+		}
+	}
+	if rank := c.Server("c").rank(); rank != 3 {
+		t.Errorf("rank de c = %d, esperado 3", rank)
+	}
+}
+
+func TestClusterRestartKeepsDataAndRank(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.Client("b").Login(ctx, "alice"); err != nil {
+		t.Fatalf("login em b: %v", err)
+	}
+
+	c.Kill("b")
+	c.Restart("b")
+
+	users, err := c.Client("b").Users(ctx)
+	if err != nil {
+		t.Fatalf("users em b: %v", err)
+	}
+	if !hasUser(users, "alice") {
+		t.Errorf("alice sumiu após o reinício: %v", users)
+	}
+	if rank := c.Server("b").rank(); rank != 2 {
+		t.Errorf("rank de b após o reinício = %d, esperado 2", rank)
+	}
+	if got := c.Coordinator("b"); got != "c" {
+		t.Errorf("b reiniciado vê coordenador %q, esperado c", got)
+	}
+}
+
+func TestClusterCoordinatorCrashElectsNextRank(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+
+	c.Kill("c")
+
+	// b só se elege depois que a referência esquece c (testLiveness)
+	c.Eventually(10*time.Second, "b deveria assumir a coordenação", func() bool {
+		return c.Coordinator("b") == "b"
+	})
+	if got := c.Coordinator("a"); got == "a" {
+		t.Errorf("a se elegeu coordenador com b, de rank maior, no ar")
+	}
+}
+
+func TestClusterPartitionKeepsReachableCoordinator(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+
+	// a perde o coordenador, mas ainda alcança b: a eleição de a para em b
+	c.Partition([]string{"a"}, []string{"c"})
+	c.Consistently(2*time.Second, "ninguém além de c deveria se declarar coordenador", func() bool {
+		return c.Coordinator("a") != "a" && c.Coordinator("b") != "b" && c.Coordinator("c") == "c"
+	})
+
+	c.Heal()
+	c.Kill("c")
+	c.Eventually(10*time.Second, "b deveria assumir após a queda de c", func() bool {
+		return c.Coordinator("b") == "b"
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Busca textual (índice invertido)
+// ----------------------------
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+type SearchRequest struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		User      string `msgpack:"user"`                // quem busca
+		Query     string `msgpack:"query"`               // todas as palavras devem aparecer
+		Author    string `msgpack:"author,omitempty"`    // filtra por autor
+		Channel   string `msgpack:"channel,omitempty"`   // filtra por canal
+		Scope     string `msgpack:"scope,omitempty"`     // "channels", "dm" ou vazio (ambos)
+		Since     int64  `msgpack:"since,omitempty"`     // timestamp mínimo (inclusivo)
+		Until     int64  `msgpack:"until,omitempty"`     // timestamp máximo (inclusivo)
+		Page      int    `msgpack:"page,omitempty"`      // começa em 1
+		PageSize  int    `msgpack:"page_size,omitempty"` // padrão 20, máximo 100
+		Timestamp int64  `msgpack:"timestamp"`
+		Clock     int64  `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+type SearchResult struct {
+	Kind      string `msgpack:"kind"` // "channel" ou "dm"
+	ID        string `msgpack:"id"`
+	User      string `msgpack:"user"`
+	Channel   string `msgpack:"channel,omitempty"`
+	Dst       string `msgpack:"dst,omitempty"`
+	Message   string `msgpack:"message"`
+	Timestamp int64  `msgpack:"timestamp"`
+}
+
+type SearchResponse struct {
+	Service string `msgpack:"service"`
+	Data    struct {
+		Status    string         `msgpack:"status"`
+		Error     string         `msgpack:"error,omitempty"`
+		Message   string         `msgpack:"message,omitempty"`
+		Results   []SearchResult `msgpack:"results"`
+		Total     int            `msgpack:"total"`
+		Page      int            `msgpack:"page"`
+		PageSize  int            `msgpack:"page_size"`
+		Timestamp int64          `msgpack:"timestamp"`
+		Clock     int64          `msgpack:"clock"`
+	} `msgpack:"data"`
+}
+
+// tokenize divide o texto em palavras minúsculas (letras e dígitos).
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	tokens := fields[:0]
+	for _, f := range fields {
+		if !seen[f] {
+			seen[f] = true
+			tokens = append(tokens, f)
+		}
+	}
+	return tokens
+}
+
+// indexMessage (re)indexa o texto de uma mensagem. Deve ser chamada com dataMutex travado.
+func (srv *Server) indexMessage(id, text string) {
+	if id == "" {
+		return
+	}
+	srv.unindexMessage(id)
+
+	tokens := tokenize(text)
+	for _, t := range tokens {
+		if srv.searchIndex[t] == nil {
+			srv.searchIndex[t] = make(map[string]struct{})
+		}
+		srv.searchIndex[t][id] = struct{}{}
+	}
+	srv.docTokens[id] = tokens
+}
+
+// unindexMessage remove a mensagem do índice. Deve ser chamada com dataMutex travado.
+func (srv *Server) unindexMessage(id string) {
+	for _, t := range srv.docTokens[id] {
+		delete(srv.searchIndex[t], id)
+		if len(srv.searchIndex[t]) == 0 {
+			delete(srv.searchIndex, t)
+		}
+	}
+	delete(srv.docTokens, id)
+}
+
+// rebuildSearchIndex reconstrói o índice a partir dos dados persistidos.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) rebuildSearchIndex() {
+	srv.searchIndex = make(map[string]map[string]struct{})
+	srv.docTokens = make(map[string][]string)
+	for _, cm := range srv.data.ChannelMessages {
+		if !cm.Deleted {
+			srv.indexMessage(cm.ID, cm.Message)
+		}
+	}
+	for _, um := range srv.data.UserMessages {
+		if !um.Deleted {
+			srv.indexMessage(um.ID, um.Message)
+		}
+	}
+}
+
+// matchingIDs retorna os IDs que contêm todas as palavras da consulta.
+// Deve ser chamada com dataMutex travado.
+func (srv *Server) matchingIDs(query string) map[string]struct{} {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	// Começar pela palavra mais rara reduz as interseções
+	sort.Slice(tokens, func(i, j int) bool { return len(srv.searchIndex[tokens[i]]) < len(srv.searchIndex[tokens[j]]) })
+
+	result := make(map[string]struct{})
+	for id := range srv.searchIndex[tokens[0]] {
+		result[id] = struct{}{}
+	}
+	for _, t := range tokens[1:] {
+		for id := range result {
+			if _, ok := srv.searchIndex[t][id]; !ok {
+				delete(result, id)
+			}
+		}
+	}
+	return result
+}
+
+// canAccessChannel: canais são públicos, então qualquer usuário cadastrado
+// pode ler qualquer canal existente.
+func (srv *Server) canAccessChannel(user, channel string) bool {
+	return srv.userExists(user) && srv.channelExists(channel)
+}
+
+func (srv *Server) handleSearch(msg []byte) ([]byte, error) {
+	var req SearchRequest
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return nil, err
+	}
+
+	srv.updateClock(req.Data.Clock)
+
+	resp := SearchResponse{Service: "search"}
+	resp.Data.Timestamp = time.Now().Unix()
+	resp.Data.Clock = srv.incrementClock()
+	resp.Data.Results = []SearchResult{}
+
+	page, pageSize := req.Data.Page, req.Data.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = defaultSearchPageSize
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+	resp.Data.Page = page
+	resp.Data.PageSize = pageSize
+
+	srv.dataMutex.Lock()
+	defer srv.dataMutex.Unlock()
+
+	if !srv.userExists(req.Data.User) {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrUserNotFound
+		resp.Data.Message = "Usuário não existe"
+		return msgpack.Marshal(resp)
+	}
+
+	ids := srv.matchingIDs(req.Data.Query)
+	if ids == nil {
+		resp.Data.Status = "erro"
+		resp.Data.Error = ErrInvalidArgument
+		resp.Data.Message = "Consulta vazia"
+		return msgpack.Marshal(resp)
+	}
+
+	inRange := func(ts int64) bool {
+		return (req.Data.Since == 0 || ts >= req.Data.Since) && (req.Data.Until == 0 || ts <= req.Data.Until)
+	}
+
+	matches := []SearchResult{}
+	if req.Data.Scope != "dm" {
+		for _, cm := range srv.data.ChannelMessages {
+			if _, ok := ids[cm.ID]; !ok || cm.Deleted || !inRange(cm.Timestamp) {
+				continue
+			}
+			if (req.Data.Author != "" && cm.User != req.Data.Author) ||
+				(req.Data.Channel != "" && cm.Channel != req.Data.Channel) ||
+				!srv.canAccessChannel(req.Data.User, cm.Channel) {
+				continue
+			}
+			matches = append(matches, SearchResult{
+				Kind: "channel", ID: cm.ID, User: cm.User, Channel: cm.Channel,
+				Message: cm.Message, Timestamp: cm.Timestamp,
+			})
+		}
+	}
+	if req.Data.Scope != "channels" && req.Data.Channel == "" {
+		for _, um := range srv.data.UserMessages {
+			if _, ok := ids[um.ID]; !ok || um.Deleted || !inRange(um.Timestamp) {
+				continue
+			}
+			// Apenas mensagens diretas das quais o usuário participa
+			if um.Src != req.Data.User && um.Dst != req.Data.User {
+				continue
+			}
+			if req.Data.Author != "" && um.Src != req.Data.Author {
+				continue
+			}
+			matches = append(matches, SearchResult{
+				Kind: "dm", ID: um.ID, User: um.Src, Dst: um.Dst,
+				Message: um.Message, Timestamp: um.Timestamp,
+			})
+		}
+	}
+
+	// Mais recentes primeiro
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Timestamp > matches[j].Timestamp })
+
+	resp.Data.Total = len(matches)
+	start, end := pageBounds(len(matches), page, pageSize)
+	resp.Data.Results = matches[start:end]
+
+	resp.Data.Status = "OK"
+	return msgpack.Marshal(resp)
+}
+
+// pageBounds devolve o intervalo [start, end) da página (a partir de 1) numa
+// lista de total itens; páginas além do fim ficam vazias. Compara antes de
+// multiplicar, para que uma página enorme não estoure o int.
+func pageBounds(total, page, pageSize int) (start, end int) {
+	pages := (total + pageSize - 1) / pageSize
+	if page < 1 || page-1 >= pages {
+		return total, total
+	}
+	start = (page - 1) * pageSize
+	end = start + pageSize
+	if end > total {
+		end = total
+	}
+	return start, end
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestPageBounds(t *testing.T) {
+	for _, tc := range []struct {
+		total, page, size int
+		start, end        int
+	}{
+		{45, 1, 20, 0, 20},
+		{45, 3, 20, 40, 45},
+		{45, 4, 20, 45, 45},
+		{0, 1, 20, 0, 0},
+		{45, 0, 20, 45, 45},
+		{45, 1 << 62, 20, 45, 45},
+		{45, math.MaxInt, 100, 45, 45},
+	} {
+		start, end := pageBounds(tc.total, tc.page, tc.size)
+		if start != tc.start || end != tc.end {
+			t.Errorf("pageBounds(%d, %d, %d) = [%d, %d), esperado [%d, %d)",
+				tc.total, tc.page, tc.size, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+// Uma página enorme não pode derrubar o servidor (o loop de requisições não
+// recupera pânicos).
+func TestSearchHugePageIsEmpty(t *testing.T) {
+	srv := &Server{}
+	srv.data.Logins = []UserLogin{{Username: "alice"}}
+	srv.data.Channels = []Channel{{Name: "geral"}}
+	for i := 0; i < 3; i++ {
+		srv.data.ChannelMessages = append(srv.data.ChannelMessages, ChannelMessage{
+			ID: fmt.Sprintf("m%d", i), User: "alice", Channel: "geral", Message: "olá mundo", Timestamp: int64(i),
+		})
+	}
+	srv.rebuildSearchIndex()
+
+	var req SearchRequest
+	req.Service = "search"
+	req.Data.User = "alice"
+	req.Data.Query = "mundo"
+	req.Data.Page = 1 << 62
+	msg, _ := msgpack.Marshal(req)
+
+	out, err := srv.handleSearch(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp SearchResponse
+	if err := msgpack.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Status != "OK" || resp.Data.Total != 3 || len(resp.Data.Results) != 0 {
+		t.Errorf("resposta: status %q, total %d, %d resultados", resp.Data.Status, resp.Data.Total, len(resp.Data.Results))
+	}
+}
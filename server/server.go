@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+	"google.golang.org/grpc"
+
+	"server/protocol"
+)
+
+// ----------------------------
+// Instância do servidor
+// ----------------------------
+
+// Config identifica um servidor e os endereços que ele usa. Em produção vem
+// das variáveis de ambiente (configFromEnv); os testes montam várias no mesmo
+// processo, com endpoints inproc.
+type Config struct {
+	Name         string // SERVER_NAME
+	Bind         string // socket ROUTER das requisições (padrão tcp://*:5555)
+	DataDir      string // server_data.json e blobs (padrão /data)
+	ReferenceURL string // REFERENCE_URL
+	AnnounceURL  string // COORD_ANNOUNCE_URL
+	BrokerURL    string // XSUB do broker, onde o PUB conecta (BROKER_URL)
+	BrokerSubURL string // XPUB do broker, assinado pelos gateways (BROKER_SUB_URL)
+	HTTPAddr     string // HTTP_ADDR ("off" desativa)
+	GRPCAddr     string // GRPC_ADDR ("off" desativa)
+	ClusterToken string // CLUSTER_TOKEN, segredo que autentica os servidores entre si (ver peer.go)
+
+	// PeerURL devolve o endereço de outro servidor pelo nome (padrão
+	// tcp://<nome>:5555).
+	PeerURL func(name string) string
+	// Transport abre as conexões com os outros servidores e com a referência
+	// (padrão: sockets REQ do ZeroMQ).
+	Transport Transport
+
+	Settle       time.Duration // espera para as conexões ZeroMQ se estabelecerem (padrão 2s)
+	Heartbeat    time.Duration // intervalo dos heartbeats; o coordenador é checado a cada 3 (padrão 10s)
+	SyncInterval time.Duration // intervalo da sincronização Berkeley (padrão 60s)
+}
+
+func configFromEnv() Config {
+	return Config{
+		Name:         os.Getenv("SERVER_NAME"),
+		ReferenceURL: os.Getenv("REFERENCE_URL"),
+		AnnounceURL:  os.Getenv("COORD_ANNOUNCE_URL"),
+		BrokerURL:    os.Getenv("BROKER_URL"),
+		BrokerSubURL: os.Getenv("BROKER_SUB_URL"),
+		HTTPAddr:     os.Getenv("HTTP_ADDR"),
+		GRPCAddr:     os.Getenv("GRPC_ADDR"),
+		ClusterToken: os.Getenv("CLUSTER_TOKEN"),
+	}
+}
+
+// withDefaults preenche os campos vazios com os valores do docker-compose.
+func (cfg Config) withDefaults() Config {
+	def := func(v *string, fallback string) {
+		if *v == "" {
+			*v = fallback
+		}
+	}
+	def(&cfg.Name, "server-default")
+	def(&cfg.Bind, "tcp://*:5555")
+	def(&cfg.DataDir, "/data")
+	def(&cfg.ReferenceURL, "tcp://reference:5559")
+	// padrão: referência publica anúncios em 5560 (ajuste conforme sua infra)
+	def(&cfg.AnnounceURL, "tcp://reference:5560")
+	def(&cfg.BrokerURL, "tcp://broker:5557")
+	def(&cfg.BrokerSubURL, "tcp://broker:5558")
+	if cfg.PeerURL == nil {
+		cfg.PeerURL = func(name string) string { return fmt.Sprintf("tcp://%s:5555", name) }
+	}
+	if cfg.Transport == nil {
+		cfg.Transport = zmqTransport{}
+	}
+	if cfg.Settle <= 0 {
+		cfg.Settle = 2 * time.Second
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = 10 * time.Second
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = 60 * time.Second // intervalo de sync configurável
+	}
+	return cfg
+}
+
+// Server guarda o estado de um nó. Os handlers são métodos de Server, de modo
+// que vários nós podem rodar no mesmo processo (ver cluster_test.go).
+type Server struct {
+	cfg Config
+
+	// Relógio lógico e físico, protegidos por clockMutex
+	logicalClock int64
+	clockMutex   sync.Mutex
+	timeOffset   int64 // Ajuste do relógio físico (Berkeley)
+	lastSyncTime int64 // Última sincronização (Parte 5)
+
+	serverName       string
+	serverRank       int    // protegido por coordinatorMutex (ver rank)
+	coordinatorName  string // protegido por coordinatorMutex (ver coordinator)
+	coordinatorMutex sync.Mutex
+	messageCounter   int
+
+	data      PersistentData
+	dataMutex sync.Mutex // Proteger acesso aos dados
+
+	// Índice invertido: palavra -> IDs das mensagens. Protegido por dataMutex.
+	searchIndex map[string]map[string]struct{}
+	docTokens   map[string][]string // ID -> palavras indexadas
+
+	pubSocket    *zmq.Socket
+	requestMutex sync.Mutex // serializa as requisições (loop ZeroMQ e gateways)
+	refMutex     sync.Mutex // serializa o uso do socket de referência pelas rotinas de fundo
+
+	uploads      map[string]*uploadSession
+	uploadsMutex sync.Mutex
+
+	sessions      map[string]*clientSession
+	sessionsMutex sync.Mutex
+
+	rateLimits     map[string]RateLimit
+	buckets        map[string]*tokenBucket
+	rateLimitMutex sync.Mutex
+	rateChecks     int
+
+	eventSubscribers map[eventSubscriber]struct{}
+	eventsMutex      sync.Mutex
+	eventsOnce       sync.Once
+
+	lastResync  *protocol.ResyncResult
+	resyncMutex sync.Mutex
+
+	adminNonces nonceCache // admin_*: nonces de assinaturas ainda válidas
+	peerNonces  nonceCache // peerServices: idem, por remetente
+
+	httpServer *http.Server
+	grpcServer *grpc.Server
+
+	ready    chan struct{} // fechado quando o socket ROUTER está aceitando requisições
+	done     chan struct{} // fechado por Stop
+	stopping sync.Once
+	stopped  chan struct{} // fechado quando run retorna
+	wg       sync.WaitGroup
+}
+
+func newServer(cfg Config) *Server {
+	cfg = cfg.withDefaults()
+	return &Server{
+		cfg:              cfg,
+		serverName:       cfg.Name,
+		searchIndex:      make(map[string]map[string]struct{}),
+		docTokens:        make(map[string][]string),
+		uploads:          make(map[string]*uploadSession),
+		sessions:         make(map[string]*clientSession),
+		rateLimits:       defaultRateLimits(),
+		buckets:          make(map[string]*tokenBucket),
+		eventSubscribers: make(map[eventSubscriber]struct{}),
+		ready:            make(chan struct{}),
+		done:             make(chan struct{}),
+		stopped:          make(chan struct{}),
+	}
+}
+
+func (srv *Server) dataFile() string {
+	return filepath.Join(srv.cfg.DataDir, "server_data.json")
+}
+
+func (srv *Server) peerURL(name string) string {
+	return srv.cfg.PeerURL(name)
+}
+
+// running indica se Stop ainda não foi chamado.
+func (srv *Server) running() bool {
+	select {
+	case <-srv.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// spawn roda fn numa goroutine que Stop espera terminar.
+func (srv *Server) spawn(fn func()) {
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		fn()
+	}()
+}
+
+// every roda tick a cada interval até Stop.
+func (srv *Server) every(interval time.Duration, tick func()) {
+	srv.spawn(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-srv.done:
+				return
+			case <-ticker.C:
+				tick()
+			}
+		}
+	})
+}
+
+// sleep espera d ou até Stop; devolve false se o servidor está parando.
+func (srv *Server) sleep(d time.Duration) bool {
+	select {
+	case <-srv.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Stop encerra os gateways, o loop de requisições e as rotinas de fundo, e
+// espera run retornar (só deve ser chamada depois de run). Os dados ficam no disco; um novo Server com a mesma
+// Config continua de onde este parou.
+func (srv *Server) Stop() {
+	srv.stopping.Do(func() {
+		close(srv.done)
+		if srv.httpServer != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			srv.httpServer.Shutdown(ctx)
+			cancel()
+		}
+		if srv.grpcServer != nil {
+			srv.grpcServer.Stop()
+		}
+	})
+	<-srv.stopped
+}
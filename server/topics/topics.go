@@ -0,0 +1,48 @@
+// Package topics define os nomes de tópicos usados no broker (XPUB/XSUB).
+//
+// Canais e usuários ficam em espaços separados e todo tópico termina com um
+// byte nulo, porque o ZeroMQ filtra inscrições por prefixo: sem o delimitador,
+// quem se inscreve em "al" receberia tudo de "alice".
+//
+//	ch/<canal>\x00   publicações e eventos do canal
+//	dm/<usuário>\x00 mensagens diretas e notificações do usuário
+package topics
+
+import "strings"
+
+const (
+	ChannelPrefix = "ch/"
+	UserPrefix    = "dm/"
+	Delimiter     = "\x00"
+)
+
+// Tipos de tópico devolvidos por Parse
+const (
+	KindChannel = "channel"
+	KindUser    = "dm"
+)
+
+// Channel devolve o tópico de um canal.
+func Channel(name string) string {
+	return ChannelPrefix + name + Delimiter
+}
+
+// User devolve o tópico de mensagens diretas de um usuário.
+func User(name string) string {
+	return UserPrefix + name + Delimiter
+}
+
+// Parse separa um tópico recebido em tipo (KindChannel ou KindUser) e nome.
+func Parse(topic string) (kind, name string, ok bool) {
+	rest, found := strings.CutSuffix(topic, Delimiter)
+	if !found {
+		return "", "", false
+	}
+	if name, found := strings.CutPrefix(rest, ChannelPrefix); found {
+		return KindChannel, name, true
+	}
+	if name, found := strings.CutPrefix(rest, UserPrefix); found {
+		return KindUser, name, true
+	}
+	return "", "", false
+}
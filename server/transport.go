@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+// ----------------------------
+// Transporte entre servidores
+// ----------------------------
+
+// PeerConn é uma conexão requisição-resposta de um servidor com outro servidor
+// ou com a referência. Em produção é um socket REQ do ZeroMQ; os testes a
+// embrulham para perder, atrasar, duplicar e reordenar mensagens
+// (faultnet_test.go).
+type PeerConn interface {
+	SendBytes(data []byte, flags zmq.Flag) (int, error)
+	RecvBytes(flags zmq.Flag) ([]byte, error)
+	SetRcvtimeo(timeout time.Duration) error
+	Close() error
+}
+
+// Transport abre as conexões de um servidor: from é o próprio servidor, to o
+// destino (outro servidor ou referencePeer) e url o endereço dele.
+type Transport interface {
+	Dial(from, to, url string) (PeerConn, error)
+}
+
+// referencePeer é o nome do servidor de referência para o Transport.
+const referencePeer = "reference"
+
+// peerTimeout limita a espera por respostas entre servidores, para que um nó
+// fora do ar não prenda as rotinas de fundo.
+const peerTimeout = 5 * time.Second
+
+type zmqTransport struct{}
+
+func (zmqTransport) Dial(from, to, url string) (PeerConn, error) {
+	socket, err := zmq.NewSocket(zmq.REQ)
+	if err != nil {
+		return nil, err
+	}
+	socket.SetLinger(0)
+	socket.SetRcvtimeo(peerTimeout)
+	// Com timeout, o REQ precisa aceitar um novo envio após uma resposta perdida
+	socket.SetReqRelaxed(1)
+	socket.SetReqCorrelate(1)
+
+	if err := socket.Connect(url); err != nil {
+		socket.Close()
+		return nil, err
+	}
+	return socket, nil
+}
+
+// dial abre uma conexão com outro servidor, pelo nome, ou com a referência.
+func (srv *Server) dial(to string) (PeerConn, error) {
+	url := srv.cfg.ReferenceURL
+	if to != referencePeer {
+		url = srv.peerURL(to)
+	}
+	return srv.cfg.Transport.Dial(srv.serverName, to, url)
+}
+
+// callPeer envia req, assinada (ver signPeer), a outro servidor numa conexão
+// própria e devolve a resposta crua.
+func (srv *Server) callPeer(peer string, req interface{}) ([]byte, error) {
+	reqData, err := srv.signPeer(req)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := srv.dial(peer)
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	if _, err := sock.SendBytes(reqData, 0); err != nil {
+		return nil, fmt.Errorf("erro ao enviar para %s: %v", peer, err)
+	}
+	respData, err := sock.RecvBytes(0)
+	if err != nil {
+		return nil, fmt.Errorf("%s não respondeu: %v", peer, err)
+	}
+	return respData, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ----------------------------
+// Validação de entrada
+// ----------------------------
+
+const (
+	maxNameLength        = 32
+	maxMessageBytes      = 4096
+	maxTopicBytes        = 256
+	maxDescriptionBytes  = 1024
+	defaultMaxPayloadLen = 512 * 1024 // cabe um pedaço de upload com folga
+)
+
+// Nomes de usuário e canal: começam com letra ou dígito e seguem com
+// letras, dígitos, "_", "-" ou ".".
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func maxPayloadSize() int {
+	if v, err := strconv.Atoi(os.Getenv("MAX_PAYLOAD_SIZE")); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxPayloadLen
+}
+
+// maxPeerPayloadSize cabe o maior blob com folga para o envelope: a réplica
+// de um blob leva o arquivo inteiro, bem acima de um pedaço de upload.
+func maxPeerPayloadSize() int {
+	return int(maxBlobSize()) + 64*1024
+}
+
+func validateName(field, name string) *ValidationError {
+	switch {
+	case name == "":
+		return &ValidationError{ErrInvalidName, field, "Nome não pode ser vazio"}
+	case len(name) > maxNameLength:
+		return &ValidationError{ErrInvalidName, field, fmt.Sprintf("Nome deve ter no máximo %d caracteres", maxNameLength)}
+	case !namePattern.MatchString(name):
+		return &ValidationError{ErrInvalidName, field, "Nome deve conter apenas letras, dígitos, '_', '-' ou '.'"}
+	}
+	return nil
+}
+
+func validateMessage(message string, allowEmpty bool) *ValidationError {
+	if message == "" && !allowEmpty {
+		return &ValidationError{ErrEmptyMessage, "message", "Mensagem não pode ser vazia"}
+	}
+	if len(message) > maxMessageBytes {
+		return &ValidationError{ErrMessageTooLarge, "message", fmt.Sprintf("Mensagem deve ter no máximo %d bytes", maxMessageBytes)}
+	}
+	return nil
+}
+
+func validateLength(field, value string, max int) *ValidationError {
+	if len(value) > max {
+		return &ValidationError{ErrFieldTooLarge, field, fmt.Sprintf("Campo %s deve ter no máximo %d bytes", field, max)}
+	}
+	return nil
+}
+
+// validateRequest aplica os limites de formato antes do handler. Regras de
+// negócio (usuário existe, canal existe, permissões) continuam nos handlers.
+// peer indica uma requisição autenticada de outro servidor, que pode passar
+// do limite dos clientes.
+func (srv *Server) validateRequest(service string, msg []byte, peer bool) *ValidationError {
+	limit := maxPayloadSize()
+	if peer {
+		limit = maxPeerPayloadSize()
+	}
+	if len(msg) > limit {
+		return &ValidationError{ErrPayloadTooLarge, "", fmt.Sprintf("Requisição deve ter no máximo %d bytes", limit)}
+	}
+
+	var req struct {
+		Data struct {
+			User        string   `msgpack:"user"`
+			ID          string   `msgpack:"id"`
+			Src         string   `msgpack:"src"`
+			Dst         string   `msgpack:"dst"`
+			Channel     string   `msgpack:"channel"`
+			NewName     string   `msgpack:"new_name"`
+			Message     string   `msgpack:"message"`
+			Topic       *string  `msgpack:"topic"`
+			Description *string  `msgpack:"description"`
+			Attachments []string `msgpack:"attachments"`
+		} `msgpack:"data"`
+	}
+	if err := msgpack.Unmarshal(msg, &req); err != nil {
+		return &ValidationError{ErrInvalidPayload, "", "Formato de mensagem inválido"}
+	}
+	d := req.Data
+
+	srv.dataMutex.Lock()
+	defer srv.dataMutex.Unlock()
+
+	switch service {
+	case "login":
+		user := strings.TrimSpace(d.User)
+		if err := validateName("user", user); err != nil {
+			return err
+		}
+		// Usuários e canais compartilham o mesmo espaço de nomes
+		if srv.channelExists(user) {
+			return &ValidationError{ErrNameConflict, "user", "Já existe um canal com esse nome"}
+		}
+	case "channel", "channel_rename":
+		field, name := "channel", d.Channel
+		if service == "channel_rename" {
+			field, name = "new_name", d.NewName
+		}
+		if err := validateName(field, name); err != nil {
+			return err
+		}
+		if srv.userExists(name) {
+			return &ValidationError{ErrNameConflict, field, "Já existe um usuário com esse nome"}
+		}
+	case "publish":
+		if err := validateMessage(d.Message, len(d.Attachments) > 0); err != nil {
+			return err
+		}
+	case "message":
+		if err := validateMessage(d.Message, len(d.Attachments) > 0); err != nil {
+			return err
+		}
+	case "edit_message":
+		if err := validateMessage(d.Message, false); err != nil {
+			return err
+		}
+	}
+
+	// Mensagens são sempre endereçadas pelo ID; um ID vazio casaria com
+	// qualquer mensagem antiga sem ID
+	switch service {
+	case "edit_message", "delete_message", "react", "unreact", "receipt", "thread":
+		if d.ID == "" {
+			return &ValidationError{ErrInvalidArgument, "id", "ID da mensagem é obrigatório"}
+		}
+	}
+
+	if service == "channel" || service == "channel_update" {
+		if d.Topic != nil {
+			if err := validateLength("topic", *d.Topic, maxTopicBytes); err != nil {
+				return err
+			}
+		}
+		if d.Description != nil {
+			if err := validateLength("description", *d.Description, maxDescriptionBytes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"server/topics"
+)
+
+// ----------------------------
+// Gateway WebSocket (eventos em tempo real)
+// ----------------------------
+
+// Conexão: GET /ws?user=<usuário>&channels=a,b&cursor=<relógio>
+//
+// O usuário precisa existir (mesma identificação do ZeroMQ e do REST). O
+// tópico de mensagens diretas do usuário é assinado automaticamente. Com
+// cursor, as mensagens com relógio maior que ele são reenviadas antes dos
+// eventos ao vivo.
+
+const (
+	wsSendBuffer   = 256 // eventos em fila por conexão antes de desconectar
+	wsReplayLimit  = 1000
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsMaxFrameSize = 64 * 1024
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// Clientes de navegador de qualquer origem; não há cookies de sessão
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Quadro enviado ao cliente
+type wsFrame struct {
+	Type      string                 `json:"type"`                // ready, event, subscribed, unsubscribed, pong, error
+	Kind      string                 `json:"kind,omitempty"`      // channel ou dm
+	Name      string                 `json:"name,omitempty"`      // canal ou usuário
+	Replay    bool                   `json:"replay,omitempty"`    // reenviado a partir do cursor
+	Clock     int64                  `json:"clock,omitempty"`     // novo cursor do cliente
+	Data      map[string]interface{} `json:"data,omitempty"`      // publicação
+	Channels  []string               `json:"channels,omitempty"`  // assinaturas atuais
+	Truncated bool                   `json:"truncated,omitempty"` // replay cortado em wsReplayLimit
+	Error     *wsError               `json:"error,omitempty"`
+
+	replay *wsReplay // quadros internos (wsReplayStart, wsReplayEvents), não vão ao cliente
+}
+
+// Um "subscribe" com cursor passa o reenvio pela fila de escrita em dois
+// quadros internos: wsReplayStart, enfileirado antes de assinar os canais, e
+// wsReplayEvents, com os eventos montados depois. Assim a leitura não bloqueia
+// com centenas de eventos na fila, e o writeLoop sabe o que já foi escrito ao
+// vivo entre os dois (fica em live e não é reenviado).
+const (
+	wsReplayStart  = "replay_start"
+	wsReplayEvents = "replay_events"
+)
+
+type wsReplay struct {
+	events    []Event
+	truncated bool
+	live      replaySet
+}
+
+type wsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Comando recebido do cliente
+type wsCommand struct {
+	Op       string   `json:"op"` // subscribe, unsubscribe, ping
+	Channels []string `json:"channels"`
+	Cursor   int64    `json:"cursor"` // em subscribe: reenviar mensagens após o cursor
+}
+
+type wsClient struct {
+	srv     *Server
+	conn    *websocket.Conn
+	user    string
+	send    chan wsFrame
+	mu      sync.Mutex
+	topics  map[string]bool
+	done    chan struct{}
+	closing sync.Once
+}
+
+func (c *wsClient) wants(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topics[topic]
+}
+
+func (c *wsClient) deliver(ev Event) bool {
+	select {
+	case c.send <- eventFrame(ev, false):
+		return true
+	default:
+		return false
+	}
+}
+
+// drop desconecta um cliente que não acompanhou os eventos. Ele deve
+// reconectar com o último clock recebido como cursor.
+func (c *wsClient) drop() {
+	log.Printf("🐢 WebSocket de %s desconectado: fila cheia", c.user)
+	c.close(websocket.ClosePolicyViolation, "slow consumer: reconecte com cursor")
+}
+
+func (c *wsClient) close(code int, reason string) {
+	c.closing.Do(func() {
+		close(c.done)
+		msg := websocket.FormatCloseMessage(code, reason)
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+		c.conn.Close()
+	})
+}
+
+func eventFrame(ev Event, replay bool) wsFrame {
+	return wsFrame{Type: "event", Kind: ev.Kind, Name: ev.Name, Replay: replay, Clock: ev.Clock, Data: ev.Data}
+}
+
+func (c *wsClient) subscribed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channels := []string{}
+	for topic := range c.topics {
+		if kind, name, ok := topics.Parse(topic); ok && kind == topics.KindChannel {
+			channels = append(channels, name)
+		}
+	}
+	return channels
+}
+
+// subscribe valida os canais e passa a receber seus eventos. Retorna os
+// canais aceitos e o primeiro erro, se algum canal não existir.
+func (c *wsClient) subscribe(channels []string) ([]string, *wsError) {
+	accepted := []string{}
+	var werr *wsError
+	for _, name := range channels {
+		c.srv.dataMutex.Lock()
+		exists := c.srv.channelExists(name)
+		c.srv.dataMutex.Unlock()
+		if !exists {
+			werr = &wsError{ErrChannelNotFound, "Canal não existe: " + name}
+			continue
+		}
+		c.mu.Lock()
+		c.topics[topics.Channel(name)] = true
+		c.mu.Unlock()
+		accepted = append(accepted, name)
+	}
+	return accepted, werr
+}
+
+// write envia um quadro diretamente (usado só pela goroutine de escrita).
+func (c *wsClient) write(frame wsFrame) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(frame)
+}
+
+// replay reenvia as mensagens após cursor e devolve o que foi enviado, para
+// descartar os mesmos eventos se chegarem também ao vivo, e o novo cursor do
+// cliente: o maior clock reenviado, ou o próprio cursor se nada foi.
+func (c *wsClient) replay(channels []string, user string, cursor int64) (replaySet, int64, error) {
+	sent := replaySet{}
+	events, truncated := c.srv.replayEvents(user, channels, cursor, wsReplayLimit)
+	for _, ev := range events {
+		if ev.Clock > cursor {
+			cursor = ev.Clock
+		}
+	}
+	return sent, cursor, c.writeReplay(events, truncated, sent, nil)
+}
+
+// writeReplay escreve os eventos reenviados, exceto os que já estão em skip
+// (escritos ao vivo), e os registra em sent.
+func (c *wsClient) writeReplay(events []Event, truncated bool, sent, skip replaySet) error {
+	for _, ev := range events {
+		if skip.seen(ev.Data) {
+			continue
+		}
+		if err := c.write(eventFrame(ev, true)); err != nil {
+			return err
+		}
+		sent.add(ev.Data)
+	}
+	if truncated {
+		return c.write(wsFrame{Type: "error", Truncated: true, Error: &wsError{ErrInvalidArgument, "Cursor antigo demais; use history para o restante"}})
+	}
+	return nil
+}
+
+// writeLoop é a única goroutine que escreve na conexão.
+func (c *wsClient) writeLoop(replayed replaySet) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	var pending []*wsReplay // reenvios de "subscribe" ainda sem os eventos
+	for {
+		select {
+		case <-c.done:
+			return
+		case frame := <-c.send:
+			var err error
+			switch {
+			case frame.Type == wsReplayStart:
+				pending = append(pending, frame.replay)
+				continue
+			case frame.Type == wsReplayEvents:
+				for i, r := range pending {
+					if r == frame.replay {
+						pending = append(pending[:i], pending[i+1:]...)
+						break
+					}
+				}
+				err = c.writeReplay(frame.replay.events, frame.replay.truncated, replayed, frame.replay.live)
+			case frame.Type == "event" && frame.Data != nil && replayed.seen(frame.Data):
+				continue
+			default:
+				err = c.write(frame)
+				if frame.Type == "event" {
+					for _, r := range pending {
+						r.live.add(frame.Data)
+					}
+				}
+			}
+			if err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// queue coloca um quadro de controle na fila de escrita.
+func (c *wsClient) queue(frame wsFrame) {
+	select {
+	case c.send <- frame:
+	case <-c.done:
+	}
+}
+
+// readLoop processa os comandos do cliente até a conexão fechar.
+func (c *wsClient) readLoop() {
+	c.conn.SetReadLimit(wsMaxFrameSize)
+	c.conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		return nil
+	})
+
+	for {
+		_, raw, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+
+		var cmd wsCommand
+		if err := json.Unmarshal(raw, &cmd); err != nil {
+			c.queue(wsFrame{Type: "error", Error: &wsError{ErrInvalidPayload, "Comando deve ser JSON"}})
+			continue
+		}
+
+		switch cmd.Op {
+		case "subscribe":
+			var r *wsReplay
+			if cmd.Cursor > 0 {
+				r = &wsReplay{live: replaySet{}}
+				c.queue(wsFrame{Type: wsReplayStart, replay: r})
+			}
+			accepted, werr := c.subscribe(cmd.Channels)
+			if r != nil {
+				r.events, r.truncated = c.srv.replayEvents("", accepted, cmd.Cursor, wsReplayLimit)
+				c.queue(wsFrame{Type: wsReplayEvents, replay: r})
+			}
+			if werr != nil {
+				c.queue(wsFrame{Type: "error", Error: werr})
+			}
+			c.queue(wsFrame{Type: "subscribed", Channels: c.subscribed()})
+		case "unsubscribe":
+			c.mu.Lock()
+			for _, name := range cmd.Channels {
+				delete(c.topics, topics.Channel(name))
+			}
+			c.mu.Unlock()
+			c.queue(wsFrame{Type: "unsubscribed", Channels: c.subscribed()})
+		case "ping":
+			c.queue(wsFrame{Type: "pong"})
+		default:
+			c.queue(wsFrame{Type: "error", Error: &wsError{ErrUnknownService, "Operação desconhecida: " + cmd.Op}})
+		}
+	}
+}
+
+func (srv *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	user := q.Get("user")
+	if user == "" {
+		user = r.Header.Get("X-User")
+	}
+	srv.dataMutex.Lock()
+	exists := srv.userExists(user)
+	srv.dataMutex.Unlock()
+	if !exists {
+		restError(w, http.StatusUnauthorized, ErrUserNotFound, "Usuário não existe")
+		return
+	}
+	cursor, _ := strconv.ParseInt(q.Get("cursor"), 10, 64)
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &wsClient{
+		srv:    srv,
+		conn:   conn,
+		user:   user,
+		send:   make(chan wsFrame, wsSendBuffer),
+		topics: map[string]bool{topics.User(user): true},
+		done:   make(chan struct{}),
+	}
+
+	channels := []string{}
+	if v := q.Get("channels"); v != "" {
+		channels = strings.Split(v, ",")
+	}
+	accepted, werr := c.subscribe(channels)
+
+	// Assinar antes do replay: o que chegar nesse meio tempo fica na fila e
+	// é descartado pelo writeLoop se já tiver sido reenviado
+	srv.addEventSubscriber(c)
+	defer srv.removeEventSubscriber(c)
+
+	// O cursor do "ready" é o do último evento já entregue a esta conexão, não
+	// o relógio do servidor: eventos ao vivo ainda na fila trazem o próprio
+	// clock
+	replayed := replaySet{}
+	last := cursor
+	if cursor > 0 {
+		if replayed, last, err = c.replay(accepted, user, cursor); err != nil {
+			c.close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+	if werr != nil {
+		c.write(wsFrame{Type: "error", Error: werr})
+	}
+	if err := c.write(wsFrame{Type: "ready", Clock: last, Channels: c.subscribed()}); err != nil {
+		c.close(websocket.CloseGoingAway, "")
+		return
+	}
+	log.Printf("🔌 WebSocket de %s conectado (canais: %v, cursor: %d)", user, accepted, cursor)
+
+	go c.writeLoop(replayed)
+	c.readLoop()
+	c.close(websocket.CloseNormalClosure, "")
+	log.Printf("🔌 WebSocket de %s desconectado", user)
+}